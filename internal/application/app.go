@@ -2,28 +2,37 @@ package application
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"path/filepath"
+	"slices"
+	"time"
 
 	"os"
 
 	artfs "github.com/alechenninger/orchard/internal/artifacts/fs"
 	hdi "github.com/alechenninger/orchard/internal/cloudinit/hdiutil"
+	"github.com/alechenninger/orchard/internal/config"
 	"github.com/alechenninger/orchard/internal/domain"
+	eventsfs "github.com/alechenninger/orchard/internal/events/fs"
 	runfs "github.com/alechenninger/orchard/internal/runstate/fs"
+	"github.com/alechenninger/orchard/internal/selector"
 	shimproc "github.com/alechenninger/orchard/internal/shim/proc"
-	fsstore "github.com/alechenninger/orchard/internal/vmstore/fs"
+	"github.com/alechenninger/orchard/internal/vmstore"
 	"github.com/spf13/afero"
 )
 
 type App struct {
 	Store     domain.VMStore
 	Shim      domain.ShimProcessManager
+	Run       domain.RuntimeState
 	Artifacts domain.VMArtifacts
 	Clock     domain.Clock
 	FS        afero.Fs
 	SeedBuild domain.CIDATABuilder
+	Journal   domain.EventJournal
+	Config    config.Config
 }
 
 func New(store domain.VMStore, shim domain.ShimProcessManager, art domain.VMArtifacts, fs afero.Fs, builder domain.CIDATABuilder) *App {
@@ -36,24 +45,42 @@ func New(store domain.VMStore, shim domain.ShimProcessManager, art domain.VMArti
 	return &App{Store: store, Shim: shim, Artifacts: art, Clock: domain.RealClock{}, FS: fs, SeedBuild: builder}
 }
 
-func NewDefault() *App {
-	store := fsstore.NewDefault()
-	run := runfs.NewDefault()
-	shim := domain.ShimProcessManager(shimproc.New(store, run))
-	art := artfs.NewDefault()
-	return New(store, shim, art, afero.NewOsFs(), hdi.Builder{})
+// NewFromConfig wires the host implementations. This is the one place the
+// resolved home and runtime directories are handed to each component.
+func NewFromConfig(cfg config.Config) (*App, error) {
+	store, err := vmstore.Open(cfg.Store, cfg.Home)
+	if err != nil {
+		return nil, err
+	}
+	run := runfs.New(cfg.RuntimeDir)
+	shim := shimproc.New(store, run)
+	// Shims resolve the same settings as the process that launched them.
+	shim.Env = cfg.Environ()
+	art := artfs.NewWithBaseDir(cfg.Home)
+	app := New(store, shim, art, afero.NewOsFs(), hdi.Builder{})
+	run.Clock = app.Clock
+	app.Run = run
+	app.Journal = eventsfs.New(cfg.Home)
+	app.Config = cfg
+	return app, nil
 }
 
 type UpParams struct {
+	Name          string // optional; generated when empty
 	ImagePath     string
 	CPUs          int
 	MemoryMiB     int
 	DiskSizeGiB   int
 	SSHKeyPath    string
 	EnableRosetta bool
+	Labels        map[string]string
+	RestartPolicy domain.RestartPolicy
 }
 
-func (a *App) Up(ctx context.Context, p UpParams) (*domain.VM, error) {
+func (a *App) Up(ctx context.Context, p UpParams) (_ *domain.VM, err error) {
+	if err := p.RestartPolicy.Validate(); err != nil {
+		return nil, err
+	}
 	absImage, err := filepath.Abs(p.ImagePath)
 	if err != nil {
 		return nil, err
@@ -62,148 +89,396 @@ func (a *App) Up(ctx context.Context, p UpParams) (*domain.VM, error) {
 		return nil, fmt.Errorf("image path invalid: %w", err)
 	}
 
-	sshKeyPath := p.SSHKeyPath
-	if sshKeyPath == "" {
-		home, _ := os.UserHomeDir()
-		candidates := []string{
-			filepath.Join(home, ".ssh", "id_ed25519.pub"),
-			filepath.Join(home, ".ssh", "id_rsa.pub"),
-		}
-		for _, c := range candidates {
-			if _, err := a.FS.Stat(c); err == nil {
-				sshKeyPath = c
-				break
-			}
-		}
-	}
+	sshKeyPath := a.findSSHKey(p.SSHKeyPath)
 
-	name, err := a.Store.NextName(ctx)
-	if err != nil {
+	name := p.Name
+	if name != "" {
+		if err := a.Store.Reserve(ctx, name); err != nil {
+			return nil, err
+		}
+	} else if name, err = a.Store.NextName(ctx); err != nil {
 		return nil, err
 	}
+	// Release the reserved name and any partial artifacts if creation fails.
+	defer func() {
+		if err != nil {
+			_ = a.Store.Delete(ctx, name)
+		}
+	}()
 
 	vm := domain.VM{
-		Name:          name,
-		CPUs:          p.CPUs,
-		MemoryMiB:     p.MemoryMiB,
-		DiskSizeGiB:   p.DiskSizeGiB,
-		BaseImageRef:  absImage,
-		Hostname:      name,
-		Status:        "stopped",
-		EnableRosetta: p.EnableRosetta,
+		Name: name,
+		VMSpec: domain.VMSpec{
+			CPUs:          p.CPUs,
+			MemoryMiB:     p.MemoryMiB,
+			DiskSizeGiB:   p.DiskSizeGiB,
+			BaseImageRef:  absImage,
+			Hostname:      name,
+			EnableRosetta: p.EnableRosetta,
+			RestartPolicy: p.RestartPolicy,
+		},
+		Labels: p.Labels,
 	}
 	_ = sshKeyPath // reserved for cloud-init later
 
-	// Ensure deterministic CreatedAt via injected clock if not set yet
+	// Ensure deterministic CreatedAt and ID via injected clock if not set yet
 	if vm.CreatedAt == 0 && a.Clock != nil {
 		vm.CreatedAt = a.Clock.Now().UnixNano()
+		vm.ID = domain.NewID(time.Unix(0, vm.CreatedAt))
 	}
 	if err := a.Artifacts.Prepare(ctx, &vm); err != nil {
 		return nil, err
 	}
 	// Generate cloud-init seed ISO: require an SSH public key (provided or auto-detected)
-	if sshKeyPath == "" {
-		return nil, fmt.Errorf("no SSH public key found; specify --ssh-key or create ~/.ssh/id_ed25519.pub")
-	}
-	kb, err := afero.ReadFile(a.FS, sshKeyPath)
+	kb, err := a.readSSHKey(sshKeyPath)
 	if err != nil {
-		return nil, fmt.Errorf("reading ssh key: %w", err)
+		return nil, err
 	}
-	if err := domain.NewCloudInitWithFSAndBuilder(a.FS, a.SeedBuild).Generate(ctx, vm, string(kb), vm.SeedISOPath); err != nil {
+	if err := domain.NewCloudInitWithFSAndBuilder(a.FS, a.SeedBuild).Generate(ctx, vm, kb, vm.SeedISOPath); err != nil {
 		return nil, err
 	}
-	if err := a.Store.Save(ctx, vm); err != nil { // persist updated paths
+	if err := a.Store.Save(ctx, &vm); err != nil { // persist updated paths
 		return nil, err
 	}
+	a.record(ctx, &vm, domain.EventCreated, 0, "", nil)
 	return &vm, nil
 }
 
-func (a *App) ListVMs(ctx context.Context) ([]domain.VM, error) {
+// findSSHKey returns path, or the first default public key that exists if path is
+// empty. It returns "" if none is found.
+func (a *App) findSSHKey(path string) string {
+	if path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	candidates := []string{
+		filepath.Join(home, ".ssh", "id_ed25519.pub"),
+		filepath.Join(home, ".ssh", "id_rsa.pub"),
+	}
+	for _, c := range candidates {
+		if _, err := a.FS.Stat(c); err == nil {
+			return c
+		}
+	}
+	return ""
+}
+
+func (a *App) readSSHKey(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("no SSH public key found; specify --ssh-key or create ~/.ssh/id_ed25519.pub")
+	}
+	kb, err := afero.ReadFile(a.FS, path)
+	if err != nil {
+		return "", fmt.Errorf("reading ssh key: %w", err)
+	}
+	return string(kb), nil
+}
+
+// ListVMs returns the readable VMs and those whose records could not be loaded.
+func (a *App) ListVMs(ctx context.Context) ([]domain.VM, []domain.BrokenVM, error) {
 	return a.Store.List(ctx)
 }
 
-func (a *App) Start(ctx context.Context, nameOrID string) (*domain.VM, error) {
-	vm, err := a.Store.Load(ctx, nameOrID)
+// SelectVMs lists the VMs whose labels match sel. Stores with a label index
+// narrow the candidates by the first equality requirement.
+func (a *App) SelectVMs(ctx context.Context, sel selector.Selector) ([]domain.VM, error) {
+	list := func(ctx context.Context) ([]domain.VM, error) {
+		vms, _, err := a.Store.List(ctx)
+		return vms, err
+	}
+	if key, value, ok := sel.FirstEquality(); ok {
+		if idx, isIdx := a.Store.(domain.LabelIndex); isIdx {
+			list = func(ctx context.Context) ([]domain.VM, error) { return idx.ListByLabel(ctx, key, value) }
+		}
+	}
+	vms, err := list(ctx)
 	if err != nil {
 		return nil, err
 	}
-	_, err = a.Shim.StartDetached(ctx, *vm)
+	var out []domain.VM
+	for _, vm := range vms {
+		if sel.Matches(vm.Labels) {
+			out = append(out, vm)
+		}
+	}
+	return out, nil
+}
+
+// Label sets and removes labels on a VM.
+func (a *App) Label(ctx context.Context, nameOrID string, set map[string]string, remove []string) (*domain.VM, error) {
+	vm, err := a.Store.Load(ctx, nameOrID)
 	if err != nil {
 		return nil, err
 	}
-	pid, err := a.Shim.WaitReadyAndPID(ctx, vm.Name)
+	if vm.Labels == nil {
+		vm.Labels = map[string]string{}
+	}
+	for k, v := range set {
+		vm.Labels[k] = v
+	}
+	for _, k := range remove {
+		delete(vm.Labels, k)
+	}
+	if err := a.Store.Save(ctx, vm); err != nil {
+		return nil, err
+	}
+	a.record(ctx, vm, domain.EventConfigChanged, 0, "labels: "+selector.FormatLabels(vm.Labels), nil)
+	return vm, nil
+}
+
+// SetRestartPolicy changes a VM's restart policy. A running VM keeps the policy
+// it was started with until it is started again.
+func (a *App) SetRestartPolicy(ctx context.Context, nameOrID string, p domain.RestartPolicy) (*domain.VM, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	vm, err := a.Store.Load(ctx, nameOrID)
 	if err != nil {
 		return nil, err
 	}
-	vm.PID = pid
-	vm.Status = "running"
-	_ = a.Store.Save(ctx, *vm)
+	vm.RestartPolicy = p
+	if err := a.Store.Save(ctx, vm); err != nil {
+		return nil, err
+	}
+	a.record(ctx, vm, domain.EventConfigChanged, 0, "restart policy: "+p.String(), nil)
 	return vm, nil
 }
 
-func (a *App) Stop(ctx context.Context, nameOrID string) error {
+// Migrate upgrades stored VM records to the current schema version. With check it
+// only reports what would change.
+func (a *App) Migrate(ctx context.Context, check bool) ([]domain.SchemaMigration, error) {
+	return a.Store.Migrate(ctx, check)
+}
+
+// Usage reports the host storage consumed by a VM's artifacts.
+func (a *App) Usage(ctx context.Context, vm domain.VM) (domain.ArtifactUsage, error) {
+	return a.Artifacts.Usage(ctx, vm)
+}
+
+// HomeUsage reports the bytes allocated under the orchard home directory.
+func (a *App) HomeUsage(ctx context.Context) (int64, error) {
+	return a.Artifacts.HomeUsage(ctx)
+}
+
+// Inspect loads a VM record together with its storage usage.
+func (a *App) Inspect(ctx context.Context, nameOrID string) (*domain.VM, domain.ArtifactUsage, error) {
 	vm, err := a.Store.Load(ctx, nameOrID)
 	if err != nil {
-		return err
+		return nil, domain.ArtifactUsage{}, err
 	}
-	if vm.PID == 0 {
-		if p, err := a.Shim.GetPID(ctx, vm.Name); err == nil {
-			vm.PID = p
-		} else {
-			return nil
+	u, err := a.Artifacts.Usage(ctx, *vm)
+	if err != nil {
+		return nil, domain.ArtifactUsage{}, err
+	}
+	return vm, u, nil
+}
+
+// StartParams controls how long Start blocks.
+type StartParams struct {
+	// WaitFor is the readiness stage to wait for; the default is vm-started.
+	WaitFor domain.ReadinessStage
+	// Timeout bounds the wait. Zero selects DefaultStartTimeout for the stage.
+	Timeout time.Duration
+}
+
+// DefaultStartTimeout returns how long Start waits for stage by default. Guest
+// stages depend on the guest booting, so they get longer.
+func DefaultStartTimeout(stage domain.ReadinessStage) time.Duration {
+	if slices.Contains(domain.GuestStages, stage) {
+		return 5 * time.Minute
+	}
+	return 15 * time.Second
+}
+
+// Start launches the VM's shim and waits until it reports p.WaitFor. If the wait
+// times out the VM keeps starting in the background.
+func (a *App) Start(ctx context.Context, nameOrID string, p StartParams) (*domain.VM, domain.VMStatus, error) {
+	if p.WaitFor == "" {
+		p.WaitFor = domain.StageVMStarted
+	}
+	if p.Timeout == 0 {
+		p.Timeout = DefaultStartTimeout(p.WaitFor)
+	}
+	vm, err := a.Store.Load(ctx, nameOrID)
+	if err != nil {
+		return nil, domain.VMStatus{}, err
+	}
+	if _, err := a.transition(ctx, vm, domain.StateStarting); err != nil {
+		return nil, domain.VMStatus{}, err
+	}
+	a.record(ctx, vm, domain.EventStarting, 0, "", nil)
+	_, err = a.Shim.StartDetached(ctx, *vm)
+	if err != nil {
+		a.record(ctx, vm, domain.EventCrashed, 0, "shim failed to launch", err)
+		return nil, domain.VMStatus{}, err
+	}
+	wctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	if _, err := a.Shim.WaitStage(wctx, vm.Name, p.WaitFor); err != nil {
+		st := a.Observe(ctx, vm)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return vm, st, fmt.Errorf("vm %s did not reach %s within %s (reached %s)", vm.Name, p.WaitFor, p.Timeout, ifNone(st.Stage))
 		}
+		if !st.Active() && st.Reason != "" {
+			return nil, st, fmt.Errorf("%w: %s", err, st.Reason)
+		}
+		return nil, st, err
 	}
-	if err := a.Shim.Stop(ctx, vm.PID); err != nil {
-		return err
+	st := a.Observe(ctx, vm)
+	if !st.Active() {
+		return nil, st, fmt.Errorf("vm %s did not start: %s", vm.Name, st.State)
 	}
-	vm.PID = 0
-	vm.Status = "stopped"
-	return a.Store.Save(ctx, *vm)
+	return vm, st, nil
+}
+
+// StopParams control how Stop stops a VM.
+type StopParams struct {
+	// Force powers the VM off without asking the guest to shut down.
+	Force bool
+	// Timeout is how long the guest gets to shut down before it is powered off.
+	// Zero means domain.DefaultStopTimeout.
+	Timeout time.Duration
+}
+
+// Stop asks the VM's shim to shut the guest down, over its control socket if it
+// has one and with a signal otherwise, and waits for the shim to exit. It fails
+// with domain.ErrNotRunning if there is no live shim.
+func (a *App) Stop(ctx context.Context, nameOrID string, p StopParams) (domain.VMStatus, error) {
+	vm, err := a.Store.Load(ctx, nameOrID)
+	if err != nil {
+		return domain.VMStatus{}, err
+	}
+	st, err := a.transition(ctx, vm, domain.StateStopping)
+	if err != nil {
+		return st, err
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = domain.DefaultStopTimeout
+	}
+	a.record(ctx, vm, domain.EventStopping, st.PID, "", nil)
+	err = a.withControl(ctx, vm.Name, func(ctx context.Context, ctl domain.ShimControl) error {
+		return ctl.Stop(ctx, domain.StopOptions{Force: p.Force, Timeout: p.Timeout})
+	})
+	signaled := errors.Is(err, domain.ErrNoControl)
+	if signaled {
+		err = a.Shim.Stop(ctx, st.PID, p.Force)
+	}
+	if err != nil {
+		return st, err
+	}
+	pid := st.PID
+	st, err = a.waitStopped(ctx, vm, p.Timeout)
+	if err == nil && signaled && p.Force && a.Run != nil {
+		// A killed shim can neither record why it exited nor clear its runtime files.
+		exit := domain.ShimExit{Time: a.Clock.Now().UTC(), PID: pid, State: domain.StateStopped, Reason: "forced power-off; shim killed", Requested: true}
+		_ = a.Run.WriteExit(ctx, vm.Name, exit)
+		_ = a.Run.CleanupIfStale(ctx, vm.Name)
+		st = a.Observe(ctx, vm)
+	}
+	return st, err
+}
+
+// Pause pauses a running VM through its shim, keeping its memory so Resume can
+// continue it. It fails with domain.ErrNotSupported if the VM's provider cannot
+// pause.
+func (a *App) Pause(ctx context.Context, nameOrID string) (domain.VMStatus, error) {
+	return a.setPaused(ctx, nameOrID, true)
+}
+
+// Resume continues a VM paused with Pause.
+func (a *App) Resume(ctx context.Context, nameOrID string) (domain.VMStatus, error) {
+	return a.setPaused(ctx, nameOrID, false)
+}
+
+func (a *App) setPaused(ctx context.Context, nameOrID string, pause bool) (domain.VMStatus, error) {
+	vm, err := a.Store.Load(ctx, nameOrID)
+	if err != nil {
+		return domain.VMStatus{}, err
+	}
+	to, typ, verb := domain.StateRunning, domain.EventResumed, "resume"
+	if pause {
+		to, typ, verb = domain.StatePaused, domain.EventPaused, "pause"
+	}
+	st, err := a.transition(ctx, vm, to)
+	if err != nil {
+		return st, err
+	}
+	// Only the shim holds the VM, so there is no fallback without its socket.
+	err = a.withControl(ctx, vm.Name, func(ctx context.Context, ctl domain.ShimControl) error {
+		if pause {
+			return ctl.Pause(ctx)
+		}
+		return ctl.Resume(ctx)
+	})
+	if err != nil {
+		return st, fmt.Errorf("cannot %s vm %s: %w", verb, vm.Name, err)
+	}
+	a.record(ctx, vm, typ, st.PID, "", nil)
+	return a.Observe(ctx, vm), nil
 }
 
 // Delete removes VM resources and metadata. If the VM is running and force is false,
-// it returns an error. With force=true, it will attempt a Stop first.
+// it returns an error. With force=true, it stops the VM and waits for its shim to
+// exit first.
 func (a *App) Delete(ctx context.Context, nameOrID string, force bool) error {
 	vm, err := a.Store.Load(ctx, nameOrID)
 	if err != nil {
 		return err
 	}
-	// Determine if running
-	running := false
-	if p, err := a.Shim.GetPID(ctx, vm.Name); err == nil && p > 0 {
-		running = true
-		vm.PID = p
-	}
-	if running {
+	_, err = a.transition(ctx, vm, domain.StateDeleting)
+	if errors.Is(err, domain.ErrRunning) {
 		if !force {
-			return fmt.Errorf("vm %s is running; use --force to stop and delete", vm.Name)
+			return fmt.Errorf("%w; use --force to stop and delete", err)
 		}
-		if err := a.Stop(ctx, vm.Name); err != nil {
-			return err
+		// The VM is about to be deleted, so there is no point in shutting it down cleanly.
+		var st domain.VMStatus
+		if st, err = a.Stop(ctx, vm.Name, StopParams{Force: true}); err == nil {
+			err = domain.CheckTransition(vm.Name, st.State, domain.StateDeleting)
 		}
 	}
-	return a.Store.Delete(ctx, vm.Name)
-}
-
-// Status reports whether the VM is running and the live PID if available.
-func (a *App) Status(ctx context.Context, nameOrID string) (bool, int, error) {
-	vm, err := a.Store.Load(ctx, nameOrID)
 	if err != nil {
-		return false, 0, err
+		return err
+	}
+	release, err := a.lock(ctx, vm.Name, domain.LockExclusive)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := a.Store.Delete(ctx, vm.Name); err != nil {
+		return err
 	}
-	if p, err := a.Shim.GetPID(ctx, vm.Name); err == nil && p > 0 {
-		return true, p, nil
+	// The runtime directory may be apart from the home one; a VM created later with
+	// the same name must not inherit its last exit or supervisor.
+	if a.Run != nil {
+		if err := a.Run.Remove(ctx, vm.Name); err != nil {
+			return fmt.Errorf("deleted vm %s, but removing its runtime files failed: %w", vm.Name, err)
+		}
 	}
-	return false, 0, nil
+	a.record(ctx, vm, domain.EventDeleted, 0, "", nil)
+	return nil
 }
 
-// IP resolves the VM's hostname via mDNS (NAME.local) and returns the first IPv4.
+// IP returns the address the VM's shim has observed for the guest. Without a
+// reachable shim it resolves the VM's hostname via mDNS (HOSTNAME.local) and
+// returns the first IPv4.
 func (a *App) IP(ctx context.Context, nameOrID string) (string, error) {
 	vm, err := a.Store.Load(ctx, nameOrID)
 	if err != nil {
 		return "", err
 	}
-	host := vm.Name + ".local"
+	var info domain.GuestInfo
+	err = a.withControl(ctx, vm.Name, func(ctx context.Context, ctl domain.ShimControl) (err error) {
+		info, err = ctl.GuestInfo(ctx)
+		return err
+	})
+	if err == nil && info.Address != "" {
+		return info.Address, nil
+	}
+	hostname := vm.Hostname
+	if hostname == "" {
+		hostname = vm.Name
+	}
+	host := hostname + ".local"
 	addrs, err := net.LookupIP(host)
 	if err != nil {
 		return "", err
@@ -218,3 +493,17 @@ func (a *App) IP(ctx context.Context, nameOrID string) (string, error) {
 	}
 	return "", fmt.Errorf("no IP found for %s", host)
 }
+
+// ImportStore copies every record from src into the configured store, keeping IDs
+// and revisions. Records already present are skipped and src is left untouched.
+func (a *App) ImportStore(ctx context.Context, src domain.VMStore) ([]string, error) {
+	dst, ok := a.Store.(domain.RecordImporter)
+	if !ok {
+		return nil, fmt.Errorf("the configured VM store cannot import records")
+	}
+	vms, _, err := src.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return dst.ImportRecords(ctx, vms)
+}
@@ -1,13 +1,22 @@
 package application
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	artfs "github.com/alechenninger/orchard/internal/artifacts/fs"
 	"github.com/alechenninger/orchard/internal/domain"
+	eventsfs "github.com/alechenninger/orchard/internal/events/fs"
+	runfs "github.com/alechenninger/orchard/internal/runstate/fs"
+	"github.com/alechenninger/orchard/internal/selector"
 	fsstore "github.com/alechenninger/orchard/internal/vmstore/fs"
 	"github.com/spf13/afero"
 )
@@ -45,7 +54,7 @@ func TestUpCreatesVMAndLists(t *testing.T) {
 		t.Fatalf("expected name to be set")
 	}
 
-	vms, err := app.ListVMs(ctx)
+	vms, _, err := app.ListVMs(ctx)
 	if err != nil {
 		t.Fatalf("ListVMs failed: %v", err)
 	}
@@ -62,7 +71,7 @@ func TestUpCreatesVMAndLists(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Up 2 failed: %v", err)
 	}
-	vms, err = app.ListVMs(ctx)
+	vms, _, err = app.ListVMs(ctx)
 	if err != nil {
 		t.Fatalf("ListVMs 2 failed: %v", err)
 	}
@@ -74,17 +83,77 @@ func TestUpCreatesVMAndLists(t *testing.T) {
 	}
 }
 
-type fakeShim struct{ nextPID int }
+// fakeShim reports its last started pid as live until it is stopped. With control
+// set, its shims can be reached over a control socket.
+type fakeShim struct {
+	nextPID int
+	stopped bool
+	// forced is set by a forced Stop; with ignoreStop, Stop leaves the shim up.
+	forced     bool
+	ignoreStop bool
+	control    *fakeControl
+}
 
 func (f *fakeShim) StartDetached(ctx context.Context, vm domain.VM) (int, error) {
 	f.nextPID++
+	f.stopped = false
+	return f.nextPID, nil
+}
+func (f *fakeShim) Stop(ctx context.Context, pid int, force bool) error {
+	f.stopped, f.forced = !f.ignoreStop, force
+	return nil
+}
+func (f *fakeShim) WaitStage(ctx context.Context, vmName string, stage domain.ReadinessStage) (int, error) {
 	return f.nextPID, nil
 }
-func (f *fakeShim) Stop(ctx context.Context, pid int) error { return nil }
-func (f *fakeShim) WaitReadyAndPID(ctx context.Context, vmName string) (int, error) {
+func (f *fakeShim) GetPID(ctx context.Context, vmName string) (int, error) {
+	if f.stopped {
+		return 0, nil
+	}
 	return f.nextPID, nil
 }
-func (f *fakeShim) GetPID(ctx context.Context, vmName string) (int, error) { return f.nextPID, nil }
+func (f *fakeShim) Control(ctx context.Context, vmName string) (domain.ShimControl, error) {
+	if f.control == nil || f.stopped {
+		return nil, domain.ErrNoControl
+	}
+	return f.control, nil
+}
+
+// fakeControl stops its shim as soon as asked, recording how. It pauses the VM
+// only if canPause is set, as a shim on a provider that can pause would.
+type fakeControl struct {
+	shim     *fakeShim
+	stops    []domain.StopOptions
+	canPause bool
+	paused   bool
+}
+
+func (c *fakeControl) Status(ctx context.Context) (domain.ShimInfo, error) {
+	rd := domain.Readiness{domain.StageShimUp: time.Now(), domain.StageVMStarted: time.Now()}
+	return domain.ShimInfo{Protocol: domain.ShimProtocolVersion, PID: c.shim.nextPID, Stage: rd.Stage(), Readiness: rd, Paused: c.paused}, nil
+}
+func (c *fakeControl) Stop(ctx context.Context, opts domain.StopOptions) error {
+	c.stops = append(c.stops, opts)
+	c.shim.stopped = true
+	return nil
+}
+func (c *fakeControl) Pause(ctx context.Context) error  { return c.setPaused(true) }
+func (c *fakeControl) Resume(ctx context.Context) error { return c.setPaused(false) }
+func (c *fakeControl) setPaused(paused bool) error {
+	if !c.canPause {
+		return domain.ErrNotSupported
+	}
+	c.paused = paused
+	return nil
+}
+func (c *fakeControl) Readiness(ctx context.Context) (domain.Readiness, error) {
+	info, err := c.Status(ctx)
+	return info.Readiness, err
+}
+func (c *fakeControl) GuestInfo(ctx context.Context) (domain.GuestInfo, error) {
+	return domain.GuestInfo{}, nil
+}
+func (c *fakeControl) Close() error { return nil }
 
 type fixedClock struct{ t time.Time }
 
@@ -109,27 +178,112 @@ func TestStartStopUpdatesStore(t *testing.T) {
 	if err != nil {
 		t.Fatalf("up failed: %v", err)
 	}
-	if vm.Status != "stopped" {
-		t.Fatalf("expected stopped, got %s", vm.Status)
+	rev := vm.Revision
+	if _, st, _ := app.Status(ctx, vm.Name); st.State != domain.StateStopped {
+		t.Fatalf("expected stopped, got %s", st.State)
 	}
 
-	vm, err = app.Start(ctx, vm.Name)
+	vm, st, err := app.Start(ctx, vm.Name, StartParams{})
 	if err != nil {
 		t.Fatalf("start failed: %v", err)
 	}
-	if vm.Status != "running" || vm.PID == 0 {
-		t.Fatalf("expected running with pid, got %v pid=%d", vm.Status, vm.PID)
+	if st.State != domain.StateRunning || st.PID == 0 {
+		t.Fatalf("expected running with pid, got %v pid=%d", st.State, st.PID)
+	}
+	// Observing never revises the record.
+	if vm.LastObserved == nil || vm.LastObserved.PID != st.PID {
+		t.Fatalf("expected the observation on the vm, got %+v", vm.LastObserved)
+	}
+	if stored, _ := app.Store.Load(ctx, vm.Name); stored.Revision != rev {
+		t.Fatalf("observing revised the record from %d to %d", rev, stored.Revision)
 	}
 
-	if err := app.Stop(ctx, vm.Name); err != nil {
+	if _, _, err := app.Start(ctx, vm.Name, StartParams{}); !errors.Is(err, domain.ErrAlreadyRunning) {
+		t.Fatalf("second start: expected ErrAlreadyRunning, got %v", err)
+	}
+	if err := app.Delete(ctx, vm.Name, false); !errors.Is(err, domain.ErrRunning) {
+		t.Fatalf("delete while running: expected ErrRunning, got %v", err)
+	}
+
+	if _, err := app.Stop(ctx, vm.Name, StopParams{}); err != nil {
 		t.Fatalf("stop failed: %v", err)
 	}
-	vm2, err := app.Store.Load(ctx, vm.Name)
+	if _, err := app.Stop(ctx, vm.Name, StopParams{}); !errors.Is(err, domain.ErrNotRunning) {
+		t.Fatalf("second stop: expected ErrNotRunning, got %v", err)
+	}
+	vm2, st, err := app.Status(ctx, vm.Name)
 	if err != nil {
-		t.Fatalf("load after stop failed: %v", err)
+		t.Fatalf("status after stop failed: %v", err)
+	}
+	if st.State != domain.StateStopped || st.PID != 0 {
+		t.Fatalf("expected stopped with pid=0, got %v pid=%d", st.State, st.PID)
+	}
+	if vm2.LastObserved == nil || vm2.LastObserved.State != domain.StateStopped || vm2.Revision != rev {
+		t.Fatalf("observation not refreshed without revising the record: %+v, revision %d", vm2.LastObserved, vm2.Revision)
+	}
+}
+
+func TestObserveReadsRuntimeState(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	run := runfs.NewWithFS("/testroot", memfs)
+	shim := &fakeShim{stopped: true}
+	app := New(store, shim, artfs.NewWithFS("/testroot", memfs), memfs, noopBuilder{})
+	app.Run = run
+	vm := &domain.VM{Name: "web"}
+	if err := store.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+
+	// A pid file with no live shim behind it is left by a crash.
+	_ = run.WritePID(ctx, "web", 99)
+	if st := app.Observe(ctx, vm); st.State != domain.StateCrashed || st.Reason == "" {
+		t.Fatalf("expected crashed with a reason, got %+v", st)
+	}
+	shim.nextPID, shim.stopped = 99, false
+	if st := app.Observe(ctx, vm); st.State != domain.StateStarting || st.PID != 99 {
+		t.Fatalf("expected starting before the ready marker, got %+v", st)
+	}
+	_ = run.MarkStage(ctx, "web", domain.StageVMStarted)
+	if st := app.Observe(ctx, vm); st.State != domain.StateRunning || st.Stage != domain.StageVMStarted {
+		t.Fatalf("expected running once started, got %+v", st)
+	}
+	_ = run.MarkStage(ctx, "web", domain.StageSSHReachable)
+	if st := app.Observe(ctx, vm); st.Stage != domain.StageSSHReachable || vm.LastObserved.Stage != domain.StageSSHReachable {
+		t.Fatalf("expected stage ssh-reachable to be observed, got %+v", st)
 	}
-	if vm2.Status != "stopped" || vm2.PID != 0 {
-		t.Fatalf("expected stopped with pid=0, got %v pid=%d", vm2.Status, vm2.PID)
+	if cached, _ := run.LastObserved(ctx, "web"); cached == nil || cached.Stage != domain.StageSSHReachable {
+		t.Fatalf("expected the observation cached in the runtime state, got %+v", cached)
+	}
+	if stored, _ := store.Load(ctx, "web"); stored.Revision != vm.Revision || stored.LastObserved != nil {
+		t.Fatalf("observing revised the record: %+v", stored)
+	}
+	_ = run.Clear(ctx, "web")
+	shim.stopped = true
+	_ = run.WriteExit(ctx, "web", domain.ShimExit{PID: 99, State: domain.StateStopped, Reason: "guest shut down"})
+	if st := app.Observe(ctx, vm); st.State != domain.StateStopped || st.Reason != "guest shut down" || st.LastExit == nil {
+		t.Fatalf("expected stopped with the recorded exit after a clean exit, got %+v", st)
+	}
+	_ = run.WriteExit(ctx, "web", domain.ShimExit{PID: 99, State: domain.StateCrashed, Reason: "vm stopped with an error", Code: 3})
+	if st := app.Observe(ctx, vm); st.State != domain.StateCrashed || st.Reason != "vm stopped with an error" {
+		t.Fatalf("expected crashed after the vm failed, got %+v", st)
+	}
+	// A supervisor waiting to restart the VM stands in for its shim.
+	sup := &domain.SupervisorState{PID: os.Getpid(), Restarts: 2, NextRestart: time.Unix(1, 0)}
+	if err := run.SetSupervisor(ctx, "web", sup); err != nil {
+		t.Fatal(err)
+	}
+	if st := app.Observe(ctx, vm); st.State != domain.StateStarting || st.PID != os.Getpid() || st.Restarts != 2 ||
+		!strings.HasSuffix(st.Reason, "after: vm stopped with an error") {
+		t.Fatalf("expected starting while waiting to restart, got %+v", st)
+	}
+	sup.NextRestart = time.Time{}
+	_ = run.SetSupervisor(ctx, "web", sup)
+	if st := app.Observe(ctx, vm); st.State != domain.StateCrashed || st.Restarts != 2 {
+		t.Fatalf("expected crashed with the restart count once no restart is pending, got %+v", st)
 	}
 }
 
@@ -181,6 +335,7 @@ func TestDeleteRunningRequiresForce(t *testing.T) {
 	}
 	// Simulate running by making shim report a PID
 	shim.nextPID = 1234
+	shim.control = &fakeControl{shim: shim}
 
 	if err := app.Delete(ctx, vm.Name, false); err == nil {
 		t.Fatalf("expected error when deleting running VM without force")
@@ -189,4 +344,841 @@ func TestDeleteRunningRequiresForce(t *testing.T) {
 	if err := app.Delete(ctx, vm.Name, true); err != nil {
 		t.Fatalf("force delete failed: %v", err)
 	}
+	// The VM is powered off rather than shut down, since it is being deleted anyway.
+	if stops := shim.control.stops; len(stops) != 1 || !stops[0].Force {
+		t.Fatalf("expected one forced stop, got %+v", stops)
+	}
+}
+
+func TestStopUsesControlSocket(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	shim := &fakeShim{nextPID: 1234}
+	shim.control = &fakeControl{shim: shim}
+	app := New(store, shim, artfs.NewWithFS("/testroot", memfs), memfs, noopBuilder{})
+	vm := &domain.VM{Name: "web"}
+	if err := store.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+
+	if st := app.Observe(ctx, vm); st.State != domain.StateRunning || st.Stage != domain.StageVMStarted {
+		t.Fatalf("expected running at vm-started from the shim, got %v at %s", st.State, st.Stage)
+	}
+	st, err := app.Stop(ctx, vm.Name, StopParams{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != domain.StateStopped {
+		t.Fatalf("expected stop to wait for the shim to exit, got %v", st.State)
+	}
+	want := domain.StopOptions{Timeout: 5 * time.Second}
+	if stops := shim.control.stops; len(stops) != 1 || stops[0] != want {
+		t.Fatalf("expected %+v over the control socket, got %+v", want, stops)
+	}
+}
+
+func TestForcedStopWithoutControlSocket(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	shim := &fakeShim{nextPID: 1234}
+	app := New(store, shim, artfs.NewWithFS("/testroot", memfs), memfs, noopBuilder{})
+	app.Run = runfs.NewWithFS("/testroot", memfs)
+	vm := &domain.VM{Name: "web"}
+	if err := store.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := app.Stop(ctx, vm.Name, StopParams{Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !shim.stopped || !shim.forced {
+		t.Fatalf("expected the shim to be killed, got stopped=%v forced=%v", shim.stopped, shim.forced)
+	}
+	// The killed shim's exit is recorded for it, as a stop that was asked for.
+	if st.State != domain.StateStopped || st.LastExit == nil || !st.LastExit.Requested || st.LastExit.PID != 1234 {
+		t.Fatalf("expected stopped with a requested exit, got %+v", st)
+	}
+}
+
+// Not parallel: it shortens stopExitTimeout.
+func TestSignaledStopKeepsTimeout(t *testing.T) {
+	ctx := context.Background()
+	old := stopExitTimeout
+	stopExitTimeout = 0
+	t.Cleanup(func() { stopExitTimeout = old })
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	shim := &fakeShim{nextPID: 1234, ignoreStop: true}
+	app := New(store, shim, artfs.NewWithFS("/testroot", memfs), memfs, noopBuilder{})
+	vm := &domain.VM{Name: "web"}
+	if err := store.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err := app.Stop(ctx, vm.Name, StopParams{Timeout: 50 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to give up waiting for the shim, got %v", err)
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Fatalf("waited %s, not the requested timeout", waited)
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	shim := &fakeShim{nextPID: 1234}
+	shim.control = &fakeControl{shim: shim}
+	app := New(store, shim, artfs.NewWithFS("/testroot", memfs), memfs, noopBuilder{})
+	app.Journal = eventsfs.NewWithFS("/testroot", memfs)
+	vm := &domain.VM{Name: "web"}
+	if err := store.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.Pause(ctx, "web"); !errors.Is(err, domain.ErrNotSupported) {
+		t.Fatalf("pause on a provider that cannot pause = %v, want ErrNotSupported", err)
+	}
+	if _, st, _ := app.Status(ctx, "web"); st.State != domain.StateRunning {
+		t.Fatalf("expected a refused pause to leave the vm running, got %v", st.State)
+	}
+
+	shim.control.canPause = true
+	st, err := app.Pause(ctx, "web")
+	if err != nil || st.State != domain.StatePaused || !st.Active() {
+		t.Fatalf("pause = %+v, %v; want paused", st, err)
+	}
+	if _, err := app.Pause(ctx, "web"); !errors.Is(err, domain.ErrPaused) {
+		t.Fatalf("pausing a paused vm = %v, want ErrPaused", err)
+	}
+	if _, _, err := app.Start(ctx, "web", StartParams{}); !errors.Is(err, domain.ErrAlreadyRunning) {
+		t.Fatalf("starting a paused vm = %v, want ErrAlreadyRunning", err)
+	}
+	if st, err := app.Resume(ctx, "web"); err != nil || st.State != domain.StateRunning {
+		t.Fatalf("resume = %+v, %v; want running", st, err)
+	}
+	if _, err := app.Resume(ctx, "web"); !errors.Is(err, domain.ErrNotPaused) {
+		t.Fatalf("resuming a running vm = %v, want ErrNotPaused", err)
+	}
+
+	// A paused VM can be stopped.
+	if _, err := app.Pause(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := app.Stop(ctx, "web", StopParams{}); err != nil || st.State != domain.StateStopped {
+		t.Fatalf("stopping a paused vm = %+v, %v", st, err)
+	}
+	if _, err := app.Resume(ctx, "web"); !errors.Is(err, domain.ErrNotRunning) {
+		t.Fatalf("resuming a stopped vm = %v, want ErrNotRunning", err)
+	}
+
+	evs, err := app.Events(ctx, "web", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []domain.EventType
+	for _, e := range evs {
+		types = append(types, e.Type)
+	}
+	want := []domain.EventType{domain.EventPaused, domain.EventResumed, domain.EventPaused, domain.EventStopping}
+	if !slices.Equal(types, want) {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+}
+
+func TestExportImportRegistersStoppedCopy(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	art := artfs.NewWithFS("/testroot", memfs)
+	app := New(store, &fakeShim{}, art, memfs, noopBuilder{})
+
+	img := "/testroot/image.img"
+	key := "/testroot/id_ed25519.pub"
+	_ = afero.WriteFile(memfs, img, []byte("base"), 0o644)
+	_ = afero.WriteFile(memfs, key, []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ test"), 0o644)
+
+	vm, err := app.Up(ctx, UpParams{ImagePath: img, CPUs: 3, SSHKeyPath: key})
+	if err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := app.Export(ctx, vm.Name, &buf); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if _, err := app.Import(ctx, bytes.NewReader(buf.Bytes()), ""); err == nil {
+		t.Fatalf("expected name conflict importing over %s", vm.Name)
+	}
+	imported, err := app.Import(ctx, bytes.NewReader(buf.Bytes()), "copy")
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if imported.Name != "copy" || imported.CPUs != 3 || imported.LastObserved != nil {
+		t.Fatalf("unexpected imported vm: %+v", imported)
+	}
+	if b, err := afero.ReadFile(memfs, imported.DiskPath); err != nil || string(b) != "base" {
+		t.Fatalf("unexpected imported disk %q: %v", b, err)
+	}
+	if _, err := app.Store.Load(ctx, "copy"); err != nil {
+		t.Fatalf("imported vm not loadable: %v", err)
+	}
+}
+
+func TestCollectGarbageFindsOrphansAndRemoves(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	art := artfs.NewWithFS("/testroot", memfs)
+	app := New(store, &fakeShim{}, art, memfs, noopBuilder{})
+	app.Run = runfs.NewWithFS("/testroot", memfs)
+
+	img := "/testroot/image.img"
+	key := "/testroot/id_ed25519.pub"
+	_ = afero.WriteFile(memfs, img, []byte("base"), 0o644)
+	_ = afero.WriteFile(memfs, key, []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ test"), 0o644)
+	vm, err := app.Up(ctx, UpParams{ImagePath: img, SSHKeyPath: key})
+	if err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+
+	// Leftovers: a failed up, a partial import, a stray image and a dead shim's pid file.
+	_ = afero.WriteFile(memfs, "/testroot/vms/vm-009/seed.iso", []byte("orphan"), 0o644)
+	// A disk whose record is missing is listed for repair, so it is not garbage.
+	_ = afero.WriteFile(memfs, "/testroot/vms/vm-010/disk.img", []byte("repairable"), 0o644)
+	_ = afero.WriteFile(memfs, "/testroot/vms/.import-1.tmp/disk.img", []byte("partial"), 0o644)
+	_ = afero.WriteFile(memfs, "/testroot/vms/"+vm.Name+"/old.img", []byte("stray"), 0o644)
+	_ = afero.WriteFile(memfs, "/testroot/vms/"+vm.Name+"/vm.pid", []byte("4194303\n"), 0o644)
+
+	app.Clock = fixedClock{t: time.Now().Add(time.Hour)}
+	items, err := app.CollectGarbage(ctx)
+	if err != nil {
+		t.Fatalf("collect failed: %v", err)
+	}
+	kinds := map[string]string{}
+	for _, g := range items {
+		kinds[g.Path] = g.Kind
+	}
+	want := map[string]string{
+		"/testroot/vms/vm-009":                  GarbageOrphanedVM,
+		"/testroot/vms/.import-1.tmp":           GarbageTempFile,
+		"/testroot/vms/" + vm.Name + "/old.img": GarbageUnreferencedImage,
+		"/testroot/vms/" + vm.Name + "/vm.pid":  GarbageStaleRuntime,
+	}
+	if len(kinds) != len(want) {
+		t.Fatalf("unexpected garbage: %+v", items)
+	}
+	for p, k := range want {
+		if kinds[p] != k {
+			t.Fatalf("expected %s to be %s, got %q", p, k, kinds[p])
+		}
+	}
+
+	if err := app.RemoveGarbage(ctx, items); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	for p := range want {
+		if _, err := memfs.Stat(p); err == nil {
+			t.Fatalf("expected %s removed", p)
+		}
+	}
+	if _, err := memfs.Stat(vm.DiskPath); err != nil {
+		t.Fatalf("referenced disk removed: %v", err)
+	}
+	if _, err := memfs.Stat("/testroot/vms/vm-010/disk.img"); err != nil {
+		t.Fatalf("disk of a vm with a missing record removed: %v", err)
+	}
+}
+
+func TestGarbageSizeIsAllocatedBytes(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	p := filepath.Join(dir, "disk.img")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A sparse image of 1 GiB with nothing written.
+	if err := f.Truncate(1 << 30); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	app := New(nil, &fakeShim{}, nil, afero.NewOsFs(), noopBuilder{})
+	if n := app.sizeOf(dir); n >= 1<<20 {
+		t.Fatalf("sparse image counted as %d reclaimable bytes", n)
+	}
+}
+
+func TestRemoveGarbageSparesShimStartedSinceCollect(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	run := runfs.NewWithFS("/testroot", memfs)
+	app := New(store, &fakeShim{}, artfs.NewWithFS("/testroot", memfs), memfs, noopBuilder{})
+	app.Run = run
+	vm := &domain.VM{Name: "web"}
+	if err := store.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	_ = afero.WriteFile(memfs, "/testroot/vms/web/vm.pid", []byte("4194303\n"), 0o644)
+
+	app.Clock = fixedClock{t: time.Now().Add(time.Hour)}
+	items, err := app.CollectGarbage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Kind != GarbageStaleRuntime {
+		t.Fatalf("expected the dead shim's pid file, got %+v", items)
+	}
+
+	// A shim comes up before the removal is confirmed.
+	if err := run.WritePID(ctx, "web", os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.RemoveGarbage(ctx, items); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := run.LivePID(ctx, "web"); err != nil || pid != os.Getpid() {
+		t.Fatalf("live shim's pid file removed: pid %d, %v", pid, err)
+	}
+}
+
+type fakeUsageArtifacts struct {
+	domain.VMArtifacts
+	usage domain.ArtifactUsage
+}
+
+func (f fakeUsageArtifacts) Usage(ctx context.Context, vm domain.VM) (domain.ArtifactUsage, error) {
+	return f.usage, nil
+}
+
+func TestInspectReportsArtifactUsage(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	art := artfs.NewWithFS("/testroot", memfs)
+	app := New(store, &fakeShim{}, art, memfs, noopBuilder{})
+
+	img := "/testroot/image.img"
+	key := "/testroot/id_ed25519.pub"
+	_ = afero.WriteFile(memfs, img, []byte("base"), 0o644)
+	_ = afero.WriteFile(memfs, key, []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ test"), 0o644)
+	vm, err := app.Up(ctx, UpParams{ImagePath: img, SSHKeyPath: key})
+	if err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	_ = afero.WriteFile(memfs, "/testroot/vms/"+vm.Name+"/serial.log", []byte("hello"), 0o644)
+
+	_, u, err := app.Inspect(ctx, vm.Name)
+	if err != nil {
+		t.Fatalf("inspect failed: %v", err)
+	}
+	if u.DiskLogicalBytes != 4 || u.DiskAllocatedBytes != 4 || u.SerialLogBytes != 5 {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+	total, err := app.HomeUsage(ctx)
+	if err != nil || total == 0 {
+		t.Fatalf("expected non-zero home usage, got %d: %v", total, err)
+	}
+
+	app.Artifacts = fakeUsageArtifacts{VMArtifacts: art, usage: domain.ArtifactUsage{DiskSharedBytes: 1 << 30}}
+	if _, u, _ = app.Inspect(ctx, vm.Name); u.DiskSharedBytes != 1<<30 {
+		t.Fatalf("expected faked shared bytes, got %+v", u)
+	}
+}
+
+func TestUpWithChosenName(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	art := artfs.NewWithFS("/testroot", memfs)
+	app := New(store, &fakeShim{}, art, memfs, noopBuilder{})
+
+	img := "/testroot/image.img"
+	key := "/testroot/id_ed25519.pub"
+	_ = afero.WriteFile(memfs, img, []byte("base"), 0o644)
+	_ = afero.WriteFile(memfs, key, []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ test"), 0o644)
+
+	vm, err := app.Up(ctx, UpParams{Name: "api-dev", ImagePath: img, SSHKeyPath: key})
+	if err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if vm.Name != "api-dev" || vm.Hostname != "api-dev" {
+		t.Fatalf("unexpected name/hostname: %s/%s", vm.Name, vm.Hostname)
+	}
+	if _, err := app.Up(ctx, UpParams{Name: "api-dev", ImagePath: img, SSHKeyPath: key}); !errors.Is(err, domain.ErrVMExists) {
+		t.Fatalf("expected ErrVMExists, got %v", err)
+	}
+	if _, err := app.Up(ctx, UpParams{Name: "Bad_Name", ImagePath: img, SSHKeyPath: key}); err == nil {
+		t.Fatalf("expected invalid name to be rejected")
+	}
+	// Generated names skip over names claimed explicitly.
+	_, _ = app.Up(ctx, UpParams{Name: "vm-001", ImagePath: img, SSHKeyPath: key})
+	gen, err := app.Up(ctx, UpParams{ImagePath: img, SSHKeyPath: key})
+	if err != nil {
+		t.Fatalf("up with generated name failed: %v", err)
+	}
+	if gen.Name != "vm-002" {
+		t.Fatalf("expected vm-002, got %s", gen.Name)
+	}
+	// A failed up releases the name.
+	if _, err := app.Up(ctx, UpParams{Name: "nokey", ImagePath: img, SSHKeyPath: "/missing.pub"}); err == nil {
+		t.Fatalf("expected up with missing key to fail")
+	}
+	if _, err := memfs.Stat("/testroot/vms/nokey"); err == nil {
+		t.Fatalf("expected failed up to release its name")
+	}
+
+	vms, _, err := app.ListVMs(ctx)
+	if err != nil {
+		t.Fatalf("ListVMs failed: %v", err)
+	}
+	if len(vms) != 3 {
+		t.Fatalf("expected 3 VMs, got %d", len(vms))
+	}
+}
+
+func TestLookupByIDPrefixAndBackfill(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	art := artfs.NewWithFS("/testroot", memfs)
+	app := New(store, &fakeShim{}, art, memfs, noopBuilder{})
+
+	img := "/testroot/image.img"
+	key := "/testroot/id_ed25519.pub"
+	_ = afero.WriteFile(memfs, img, []byte("base"), 0o644)
+	_ = afero.WriteFile(memfs, key, []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ test"), 0o644)
+
+	vm, err := app.Up(ctx, UpParams{ImagePath: img, SSHKeyPath: key})
+	if err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if len(vm.ID) != 26 {
+		t.Fatalf("expected ULID, got %q", vm.ID)
+	}
+	got, err := app.Store.Load(ctx, strings.ToLower(vm.ID[:14]))
+	if err != nil || got.Name != vm.Name {
+		t.Fatalf("prefix lookup: got %v, %v", got, err)
+	}
+
+	// A record written before IDs existed is backfilled and keeps its ID.
+	_ = afero.WriteFile(memfs, "/testroot/vms/legacy/config.json", []byte(`{"name":"legacy","createdAt":5}`), 0o644)
+	legacy, err := app.Store.Load(ctx, "legacy")
+	if err != nil || legacy.ID == "" {
+		t.Fatalf("expected backfilled id, got %v, %v", legacy, err)
+	}
+	again, _ := app.Store.Load(ctx, "legacy")
+	if again.ID != legacy.ID {
+		t.Fatalf("backfilled id not persisted: %s != %s", again.ID, legacy.ID)
+	}
+
+	// The two IDs share the leading timestamp digit, so a one-character prefix is ambiguous.
+	var amb *domain.AmbiguousIDError
+	if _, _, err := app.Status(ctx, "0"); !errors.As(err, &amb) || len(amb.Candidates) != 2 {
+		t.Fatalf("expected ambiguous prefix error, got %v", err)
+	}
+	if err := app.Delete(ctx, vm.ID, false); err != nil {
+		t.Fatalf("delete by id failed: %v", err)
+	}
+	if _, err := app.Store.Load(ctx, vm.Name); !errors.Is(err, domain.ErrVMNotFound) {
+		t.Fatalf("expected not found after delete, got %v", err)
+	}
+}
+
+func TestLabelsAndSelection(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	art := artfs.NewWithFS("/testroot", memfs)
+	app := New(store, &fakeShim{}, art, memfs, noopBuilder{})
+
+	img := "/testroot/image.img"
+	key := "/testroot/id_ed25519.pub"
+	_ = afero.WriteFile(memfs, img, []byte("base"), 0o644)
+	_ = afero.WriteFile(memfs, key, []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ test"), 0o644)
+
+	pay, err := app.Up(ctx, UpParams{Name: "pay", ImagePath: img, SSHKeyPath: key, Labels: map[string]string{"team": "payments", "env": "test"}})
+	if err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if _, err := app.Up(ctx, UpParams{Name: "search", ImagePath: img, SSHKeyPath: key, Labels: map[string]string{"team": "search"}}); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+
+	sel, _ := selector.Parse("team=payments")
+	vms, err := app.SelectVMs(ctx, sel)
+	if err != nil || len(vms) != 1 || vms[0].Name != pay.Name {
+		t.Fatalf("unexpected selection: %v, %v", vms, err)
+	}
+
+	if _, err := app.Label(ctx, "search", map[string]string{"env": "test"}, nil); err != nil {
+		t.Fatalf("label failed: %v", err)
+	}
+	if _, err := app.Label(ctx, "pay", nil, []string{"env"}); err != nil {
+		t.Fatalf("unlabel failed: %v", err)
+	}
+	sel, _ = selector.Parse("env in (test)")
+	vms, _ = app.SelectVMs(ctx, sel)
+	if len(vms) != 1 || vms[0].Name != "search" {
+		t.Fatalf("expected only search to have env=test, got %v", vms)
+	}
+}
+
+func TestLifecycleEventsJournaled(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	art := artfs.NewWithFS("/testroot", memfs)
+	app := New(store, &fakeShim{}, art, memfs, noopBuilder{})
+	app.Journal = eventsfs.NewWithFS("/testroot", memfs)
+	t0 := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	app.Clock = fixedClock{t: t0}
+
+	img := "/testroot/image.img"
+	key := "/testroot/id_ed25519.pub"
+	_ = afero.WriteFile(memfs, img, []byte("base"), 0o644)
+	_ = afero.WriteFile(memfs, key, []byte("ssh-ed25519 AAAA test"), 0o644)
+
+	vm, err := app.Up(ctx, UpParams{ImagePath: img, SSHKeyPath: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Label(ctx, vm.Name, map[string]string{"env": "dev"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := app.Start(ctx, vm.Name, StartParams{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Delete(ctx, vm.Name, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The journal outlives the deleted VM.
+	evs, err := app.Events(ctx, vm.Name, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []domain.EventType{domain.EventCreated, domain.EventConfigChanged, domain.EventStarting, domain.EventStopping, domain.EventDeleted}
+	if len(evs) != len(want) {
+		t.Fatalf("got %d events %+v, want %v", len(evs), evs, want)
+	}
+	for i, e := range evs {
+		if e.Type != want[i] || e.Actor != domain.ActorCLI || !e.Time.Equal(t0) || e.VMID != vm.ID {
+			t.Errorf("event %d = %+v, want type %s at %v", i, e, want[i], t0)
+		}
+	}
+	if evs[1].Message != "labels: env=dev" || evs[3].PID == 0 {
+		t.Errorf("missing event details: %+v", evs)
+	}
+	// Unknown names are journal file names, so they must not reach outside it.
+	if _, err := app.Events(ctx, "../../x", time.Time{}); err == nil {
+		t.Error("expected an invalid name to be rejected")
+	}
+}
+
+func TestRenameMovesArtifactsAndHostname(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	art := artfs.NewWithFS("/testroot", memfs)
+	shim := &fakeShim{}
+	app := New(store, shim, art, memfs, noopBuilder{})
+	app.Clock = fixedClock{t: time.Unix(0, 1)}
+
+	img := "/testroot/image.img"
+	key := "/testroot/id_ed25519.pub"
+	kh := "/testroot/known_hosts"
+	_ = afero.WriteFile(memfs, img, []byte("base"), 0o644)
+	_ = afero.WriteFile(memfs, key, []byte("ssh-ed25519 AAAA test"), 0o644)
+	_ = afero.WriteFile(memfs, kh, []byte("web.local ssh-ed25519 AAAAhostkey\n"), 0o600)
+
+	vm, err := app.Up(ctx, UpParams{Name: "web", ImagePath: img, SSHKeyPath: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shim.nextPID = 42
+	if _, err := app.Rename(ctx, "web", "api", RenameParams{}); err == nil {
+		t.Fatal("expected renaming a running VM to fail")
+	}
+	shim.nextPID = 0
+
+	res, err := app.Rename(ctx, vm.ID, "api", RenameParams{UpdateHostname: true, SSHKeyPath: key, KnownHostsPath: kh})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Load(ctx, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != vm.ID || got.Hostname != "api" || res.OldName != "web" {
+		t.Fatalf("renamed record = %+v", got)
+	}
+	for _, p := range []string{got.DiskPath, got.EFIVarsPath, got.SeedISOPath} {
+		if filepath.Dir(p) != "/testroot/vms/api" {
+			t.Errorf("path %s not rewritten", p)
+		}
+		if _, err := memfs.Stat(p); err != nil {
+			t.Errorf("artifact missing after rename: %v", err)
+		}
+	}
+	if _, err := memfs.Stat("/testroot/vms/web"); err == nil {
+		t.Error("old directory still exists")
+	}
+	if _, err := store.Load(ctx, "web"); !errors.Is(err, domain.ErrVMNotFound) {
+		t.Errorf("old name still resolves: %v", err)
+	}
+	b, _ := afero.ReadFile(memfs, kh)
+	if string(b) != "api.local ssh-ed25519 AAAAhostkey\n" || res.KnownHostsUpdated != 1 {
+		t.Errorf("known_hosts = %q (%d updated)", b, res.KnownHostsUpdated)
+	}
+}
+
+func TestRuntimeDirFollowsRenameAndDelete(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	// The runtime dir is apart from the home one, and on disk since locks need it.
+	runDir := t.TempDir()
+	run := runfs.New(runDir)
+	app := New(store, &fakeShim{stopped: true}, artfs.NewWithFS("/testroot", memfs), memfs, noopBuilder{})
+	app.Run = run
+
+	img := "/testroot/image.img"
+	key := "/testroot/id_ed25519.pub"
+	_ = afero.WriteFile(memfs, img, []byte("base"), 0o644)
+	_ = afero.WriteFile(memfs, key, []byte("ssh-ed25519 AAAA test"), 0o644)
+
+	if _, err := app.Up(ctx, UpParams{Name: "web", ImagePath: img, SSHKeyPath: key}); err != nil {
+		t.Fatal(err)
+	}
+	crash := domain.ShimExit{PID: 99, State: domain.StateCrashed, Reason: "vm stopped with an error"}
+	if err := run.WriteExit(ctx, "web", crash); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.Rename(ctx, "web", "api", RenameParams{}); err != nil {
+		t.Fatal(err)
+	}
+	if exit, _ := run.LastExit(ctx, "api"); exit == nil || exit.Reason != crash.Reason {
+		t.Fatalf("last exit after rename = %+v, want it moved to the new name", exit)
+	}
+	if _, err := os.Stat(filepath.Join(runDir, "vms", "web")); err == nil {
+		t.Error("old runtime directory still exists")
+	}
+
+	if err := app.Delete(ctx, "api", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(runDir, "vms", "api")); err == nil {
+		t.Error("runtime directory still exists after delete")
+	}
+	// A new VM reusing the name starts out stopped, not crashed.
+	vm, err := app.Up(ctx, UpParams{Name: "api", ImagePath: img, SSHKeyPath: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := app.Observe(ctx, vm); st.State != domain.StateStopped || st.LastExit != nil {
+		t.Fatalf("new vm observed as %+v", st)
+	}
+}
+
+func TestOfflineOperationsRefuseRestartingVM(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	run := runfs.NewWithFS("/testroot", memfs)
+	app := New(store, &fakeShim{}, artfs.NewWithFS("/testroot", memfs), memfs, noopBuilder{})
+	app.Run = run
+	vm := &domain.VM{Name: "web"}
+	if err := store.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	// No shim is up, but the supervisor is about to start one.
+	sup := &domain.SupervisorState{PID: os.Getpid(), Restarts: 1, NextRestart: time.Now().Add(time.Minute)}
+	if err := run.SetSupervisor(ctx, "web", sup); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Rename(ctx, "web", "api", RenameParams{}); !errors.Is(err, domain.ErrRunning) {
+		t.Fatalf("rename = %v, want ErrRunning", err)
+	}
+	if err := app.Export(ctx, "web", io.Discard); !errors.Is(err, domain.ErrRunning) {
+		t.Fatalf("export = %v, want ErrRunning", err)
+	}
+}
+
+// userDataBuilder keeps the user-data of every seed it builds, by destination.
+type userDataBuilder struct{ userData map[string]string }
+
+func (b *userDataBuilder) Build(ctx context.Context, fs afero.Fs, srcDir string, dstPath string) error {
+	ud, err := afero.ReadFile(fs, filepath.Join(srcDir, "user-data"))
+	if err != nil {
+		return err
+	}
+	b.userData[dstPath] = string(ud)
+	return noopBuilder{}.Build(ctx, fs, srcDir, dstPath)
+}
+
+func TestOnlyRenameReseedKeepsHostKeys(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	seeds := &userDataBuilder{userData: map[string]string{}}
+	app := New(fsstore.NewWithFS("/testroot", memfs), &fakeShim{}, artfs.NewWithFS("/testroot", memfs), memfs, seeds)
+
+	img := "/testroot/image.img"
+	key := "/testroot/id_ed25519.pub"
+	_ = afero.WriteFile(memfs, img, []byte("base"), 0o644)
+	_ = afero.WriteFile(memfs, key, []byte("ssh-ed25519 AAAA test"), 0o644)
+
+	vm, err := app.Up(ctx, UpParams{Name: "web", ImagePath: img, SSHKeyPath: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// New VMs must get host keys of their own, not the base image's.
+	if ud := seeds.userData[vm.SeedISOPath]; ud == "" || strings.Contains(ud, "ssh_deletekeys") {
+		t.Fatalf("up seed user-data = %q", ud)
+	}
+	res, err := app.Rename(ctx, "web", "api", RenameParams{UpdateHostname: true, SSHKeyPath: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ud := seeds.userData[res.VM.SeedISOPath+".tmp"]; !strings.Contains(ud, "ssh_deletekeys: false") {
+		t.Fatalf("rename seed user-data = %q", ud)
+	}
+}
+
+func TestBrokenRecordListedAndRepaired(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	art := artfs.NewWithFS("/testroot", memfs)
+	app := New(store, &fakeShim{}, art, memfs, noopBuilder{})
+	app.Clock = fixedClock{t: time.Unix(1000, 0)}
+
+	img := "/testroot/image.img"
+	key := "/testroot/id_ed25519.pub"
+	_ = afero.WriteFile(memfs, img, []byte("base"), 0o644)
+	_ = afero.WriteFile(memfs, key, []byte("ssh-ed25519 AAAA test"), 0o644)
+	vm, err := app.Up(ctx, UpParams{Name: "web", ImagePath: img, SSHKeyPath: key, CPUs: 4, MemoryMiB: 8192})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncate the record after the memory setting.
+	cfg := "/testroot/vms/web/config.json"
+	raw, _ := afero.ReadFile(memfs, cfg)
+	cut := bytes.Index(raw, []byte(`"diskPath"`))
+	_ = afero.WriteFile(memfs, cfg, raw[:cut], 0o644)
+
+	vms, broken, err := app.ListVMs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vms) != 0 || len(broken) != 1 || broken[0].Name != "web" || broken[0].Kind != domain.BrokenUnreadable {
+		t.Fatalf("expected web to be reported broken, got vms=%v broken=%+v", vms, broken)
+	}
+	if r := broken[0].Recovered; r == nil || r.ID != vm.ID || r.CPUs != 4 {
+		t.Fatalf("recovered = %+v", r)
+	}
+	// A broken VM is repairable, not garbage.
+	garbage, _ := app.CollectGarbage(ctx)
+	for _, g := range garbage {
+		if g.VM == "web" {
+			t.Fatalf("broken VM offered for removal: %+v", g)
+		}
+	}
+
+	res, err := app.Repair(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Load(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != vm.ID || got.CPUs != 4 || got.MemoryMiB != 8192 || got.DiskPath != vm.DiskPath || got.EFIVarsPath != vm.EFIVarsPath {
+		t.Fatalf("repaired record = %+v, want fields of %+v", got, vm)
+	}
+	if len(res.Recovered) == 0 {
+		t.Fatal("expected recovered fields to be reported")
+	}
+	if _, err := memfs.Stat(cfg + ".broken.bak"); err != nil {
+		t.Fatalf("broken record not kept: %v", err)
+	}
+	if _, err := app.Repair(ctx, "web"); err == nil {
+		t.Fatal("expected repairing a healthy VM to fail")
+	}
+}
+
+func TestLogsReadsShimAndSerial(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	memfs := afero.NewMemMapFs()
+	store := fsstore.NewWithFS("/testroot", memfs)
+	app := New(store, &fakeShim{}, artfs.NewWithFS("/testroot", memfs), memfs, noopBuilder{})
+	vm := &domain.VM{Name: "web", VMSpec: domain.VMSpec{DiskPath: "/testroot/vms/web/disk.img"}}
+	if err := store.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	_ = afero.WriteFile(memfs, "/testroot/vms/web/shim.log", []byte(
+		`{"time":"2026-01-02T10:00:00Z","level":"INFO","msg":"shim starting","vm":"web"}`+"\n"+
+			`{"time":"2026-01-02T11:00:00Z","level":"WARN","msg":"guest did not shut down in time"}`+"\n"), 0o644)
+	_ = afero.WriteFile(memfs, "/testroot/vms/web/serial.log", []byte("Booting Linux\nlogin: \n"), 0o644)
+
+	es, err := app.Logs(ctx, "web", LogShim, time.Date(2026, 1, 2, 10, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 1 || es[0].Level != "WARN" {
+		t.Fatalf("shim log since 10:30 = %+v", es)
+	}
+	es, err = app.Logs(ctx, "web", LogSerial, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 || es[0].Message != "Booting Linux" {
+		t.Fatalf("serial log = %+v", es)
+	}
+	if _, err := app.Logs(ctx, "web", LogSerial, time.Now()); err == nil {
+		t.Fatal("expected --since to be refused for the serial console")
+	}
 }
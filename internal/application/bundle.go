@@ -0,0 +1,81 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/bundle"
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+// Export streams a portable bundle of a stopped VM to w.
+func (a *App) Export(ctx context.Context, nameOrID string, w io.Writer) error {
+	vm, err := a.Store.Load(ctx, nameOrID)
+	if err != nil {
+		return err
+	}
+	if err := a.offline(ctx, vm, "exporting"); err != nil {
+		return err
+	}
+	// Keep the VM from being started while its disk is read.
+	release, err := a.lock(ctx, vm.Name, domain.LockShared)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return bundle.Write(w, a.FS, *vm, a.Clock.Now())
+}
+
+// Import registers the VM contained in the bundle read from r. If name is empty the
+// exported name is kept. Any cached status is dropped; the VM starts out stopped.
+func (a *App) Import(ctx context.Context, r io.Reader, name string) (*domain.VM, error) {
+	if name != "" {
+		if err := domain.ValidateName(name); err != nil {
+			return nil, err
+		}
+		if _, err := a.Store.Load(ctx, name); err == nil {
+			return nil, fmt.Errorf("vm %s: %w", name, domain.ErrVMExists)
+		}
+	}
+	// Unpack next to the final location so the move into place is a rename.
+	staging := a.Artifacts.Dir(fmt.Sprintf(".import-%d", a.Clock.Now().UnixNano())) + ".tmp"
+	m, vm, err := bundle.Read(r, a.FS, staging)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = m.Name
+	}
+	if err := a.Store.Reserve(ctx, name); err != nil {
+		_ = a.FS.RemoveAll(staging)
+		if errors.Is(err, domain.ErrVMExists) {
+			return nil, fmt.Errorf("%w; use --name to import under a different name", err)
+		}
+		return nil, err
+	}
+	// The reserved directory is empty, so renaming the staging area over it is atomic.
+	dir := a.Artifacts.Dir(name)
+	if err := a.FS.Rename(staging, dir); err != nil {
+		_ = a.FS.RemoveAll(staging)
+		_ = a.Store.Delete(ctx, name)
+		return nil, err
+	}
+
+	vm.Name = name
+	vm.DiskPath = filepath.Join(dir, bundle.DiskFile)
+	vm.EFIVarsPath = filepath.Join(dir, bundle.NVRAMFile)
+	vm.SeedISOPath = filepath.Join(dir, "seed.iso")
+	vm.CreatedAt = a.Clock.Now().UnixNano()
+	vm.ID = domain.NewID(time.Unix(0, vm.CreatedAt)) // a new VM on this host
+	vm.LastObserved = nil
+	vm.Revision = 0
+	if err := a.Store.Save(ctx, vm); err != nil {
+		return nil, err
+	}
+	a.record(ctx, vm, domain.EventCreated, 0, "imported from bundle "+m.Name, nil)
+	return vm, nil
+}
@@ -0,0 +1,64 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+// record appends a lifecycle event for vm, timestamped with the app's clock.
+// A journal failure never fails the operation being recorded.
+func (a *App) record(ctx context.Context, vm *domain.VM, typ domain.EventType, pid int, msg string, cause error) {
+	if a.Journal == nil {
+		return
+	}
+	e := domain.Event{Time: a.Clock.Now(), VM: vm.Name, VMID: vm.ID, Type: typ, PID: pid, Actor: domain.ActorCLI, Message: msg}
+	if cause != nil {
+		e.Error = cause.Error()
+	}
+	_ = a.Journal.Append(ctx, e)
+}
+
+// Events returns the journal of nameOrID, or of every VM if it is empty, from
+// since onward.
+func (a *App) Events(ctx context.Context, nameOrID string, since time.Time) ([]domain.Event, error) {
+	if a.Journal == nil {
+		return nil, errors.New("no event journal configured")
+	}
+	name, err := a.journalName(ctx, nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	return a.Journal.Read(ctx, name, since)
+}
+
+// FollowEvents streams the journal of nameOrID, or of every VM if it is empty,
+// from since onward, then the events appended to it until ctx is done.
+func (a *App) FollowEvents(ctx context.Context, nameOrID string, since time.Time) (<-chan domain.Event, error) {
+	if a.Journal == nil {
+		return nil, errors.New("no event journal configured")
+	}
+	name, err := a.journalName(ctx, nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	return a.Journal.Follow(ctx, name, since)
+}
+
+// journalName resolves nameOrID to a VM name. Journals outlive their VMs, so an
+// unknown name is used as is to read a deleted VM's history, if it is a valid one.
+func (a *App) journalName(ctx context.Context, nameOrID string) (string, error) {
+	if nameOrID == "" {
+		return "", nil
+	}
+	if vm, err := a.Store.Load(ctx, nameOrID); err == nil {
+		return vm.Name, nil
+	}
+	// The name becomes a path in the journal directory.
+	if err := domain.ValidateName(nameOrID); err != nil {
+		return "", err
+	}
+	return nameOrID, nil
+}
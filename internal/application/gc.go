@@ -0,0 +1,199 @@
+package application
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Kinds of reclaimable items reported by CollectGarbage.
+const (
+	GarbageOrphanedVM        = "orphaned-vm"
+	GarbageStaleRuntime      = "stale-runtime"
+	GarbageTempFile          = "temp-file"
+	GarbageUnreferencedImage = "unreferenced-image"
+)
+
+// gcGracePeriod protects artifacts of operations that may still be in flight,
+// such as an up that has prepared a disk but not yet saved its record.
+const gcGracePeriod = 10 * time.Minute
+
+// Garbage is a file or directory that can be removed to reclaim space.
+type Garbage struct {
+	Path  string `json:"path"`
+	Kind  string `json:"kind"`
+	VM    string `json:"vm,omitempty"`
+	Bytes int64  `json:"bytes"`
+}
+
+// CollectGarbage finds orphaned VM directories, stale runtime files, leftover temp
+// files and disk images no VM record refers to. Nothing is removed.
+func (a *App) CollectGarbage(ctx context.Context) ([]Garbage, error) {
+	vmsDir := filepath.Dir(a.Artifacts.Dir("_"))
+	cutoff := a.Clock.Now().Add(-gcGracePeriod)
+	seen := map[string]bool{}
+	var out []Garbage
+	add := func(path, kind, vm string) {
+		if seen[path] {
+			return
+		}
+		st, err := a.FS.Stat(path)
+		if err != nil || st.ModTime().After(cutoff) {
+			return
+		}
+		seen[path] = true
+		out = append(out, Garbage{Path: path, Kind: kind, VM: vm, Bytes: a.sizeOf(path)})
+	}
+
+	// Temp files anywhere under the orchard home, including partial imports.
+	root := filepath.Dir(vmsDir)
+	_ = afero.Walk(a.FS, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if strings.HasSuffix(info.Name(), ".tmp") {
+			add(path, GarbageTempFile, "")
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+
+	entries, err := afero.ReadDir(a.FS, vmsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return out, nil
+		}
+		return nil, err
+	}
+	repairable, err := a.repairable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || seen[filepath.Join(vmsDir, e.Name())] || repairable[e.Name()] {
+			continue
+		}
+		dir := filepath.Join(vmsDir, e.Name())
+		vm, err := a.Store.Load(ctx, e.Name())
+		if err != nil {
+			add(dir, GarbageOrphanedVM, e.Name())
+			continue
+		}
+		if a.Run != nil {
+			stale, err := a.Run.StalePaths(ctx, vm.Name)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range stale {
+				add(p, GarbageStaleRuntime, vm.Name)
+			}
+		}
+		referenced := map[string]bool{vm.DiskPath: true, vm.EFIVarsPath: true, vm.SeedISOPath: true}
+		files, err := afero.ReadDir(a.FS, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			p := filepath.Join(dir, f.Name())
+			if !f.IsDir() && isImage(f.Name()) && !referenced[p] {
+				add(p, GarbageUnreferencedImage, vm.Name)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// repairable returns the names of VMs list reports as broken, whether their
+// record is unreadable or missing next to a disk. list tells the user to repair
+// them, so their directories are never offered for removal.
+func (a *App) repairable(ctx context.Context) (map[string]bool, error) {
+	_, broken, err := a.Store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, b := range broken {
+		names[b.Name] = true
+	}
+	return names, nil
+}
+
+// RemoveGarbage deletes items previously returned by CollectGarbage. Orphaned VM
+// directories and stale runtime files are re-checked so a record or disk written,
+// or a shim started, in the meantime is not lost.
+func (a *App) RemoveGarbage(ctx context.Context, items []Garbage) error {
+	repairable, err := a.repairable(ctx)
+	if err != nil {
+		return err
+	}
+	stale := map[string]map[string]bool{}
+	for _, g := range items {
+		switch g.Kind {
+		case GarbageOrphanedVM:
+			if _, err := a.Store.Load(ctx, g.VM); err == nil || repairable[g.VM] {
+				continue
+			}
+		case GarbageStaleRuntime:
+			if a.Run == nil {
+				continue
+			}
+			if stale[g.VM] == nil {
+				paths, err := a.Run.StalePaths(ctx, g.VM)
+				if err != nil {
+					return err
+				}
+				stale[g.VM] = map[string]bool{}
+				for _, p := range paths {
+					stale[g.VM][p] = true
+				}
+			}
+			if !stale[g.VM][g.Path] {
+				continue
+			}
+		}
+		if err := a.FS.RemoveAll(g.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sizeOf returns the bytes removing path would free: the blocks allocated to the
+// files under it, which for sparse or cloned disk images is far less than their
+// logical size.
+func (a *App) sizeOf(path string) int64 {
+	var total int64
+	_ = afero.Walk(a.FS, path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += allocatedBytes(info)
+		}
+		return nil
+	})
+	return total
+}
+
+// allocatedBytes returns the bytes backing a file on disk, falling back to its
+// logical size when the filesystem does not expose block counts.
+func allocatedBytes(fi os.FileInfo) int64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+	return fi.Size()
+}
+
+func isImage(name string) bool {
+	switch filepath.Ext(name) {
+	case ".img", ".iso", ".raw", ".bin":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/guestprobe"
+	"github.com/alechenninger/orchard/internal/shimlog"
+)
+
+// LogSource names a log kept in a VM's directory.
+type LogSource string
+
+const (
+	LogShim   LogSource = "shim"   // the shim's structured log
+	LogSerial LogSource = "serial" // the guest's serial console
+)
+
+// logPollInterval is how often followed logs are checked for new lines.
+var logPollInterval = 250 * time.Millisecond
+
+// logPath resolves nameOrID and returns the path of its log from src. Serial
+// console lines carry no time of their own, so since only applies to the shim log.
+func (a *App) logPath(ctx context.Context, nameOrID string, src LogSource, since time.Time) (string, error) {
+	if src == LogSerial && !since.IsZero() {
+		return "", fmt.Errorf("serial console output is not timestamped, so it cannot be read since a time")
+	}
+	vm, err := a.Store.Load(ctx, nameOrID)
+	if err != nil {
+		return "", err
+	}
+	switch src {
+	case LogShim, "":
+		return shimlog.Path(*vm), nil
+	case LogSerial:
+		return guestprobe.SerialLog(*vm), nil
+	}
+	return "", fmt.Errorf("unknown log %q", src)
+}
+
+// Logs returns the entries of a VM's log from since onward.
+func (a *App) Logs(ctx context.Context, nameOrID string, src LogSource, since time.Time) ([]shimlog.Entry, error) {
+	p, err := a.logPath(ctx, nameOrID, src, since)
+	if err != nil {
+		return nil, err
+	}
+	return shimlog.Read(a.FS, p, since)
+}
+
+// FollowLogs streams the entries of a VM's log from since onward, then those
+// appended to it until ctx is done.
+func (a *App) FollowLogs(ctx context.Context, nameOrID string, src LogSource, since time.Time) (<-chan shimlog.Entry, error) {
+	p, err := a.logPath(ctx, nameOrID, src, since)
+	if err != nil {
+		return nil, err
+	}
+	return shimlog.Follow(ctx, a.FS, p, since, logPollInterval)
+}
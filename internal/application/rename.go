@@ -0,0 +1,99 @@
+package application
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/knownhosts"
+)
+
+// RenameParams controls the optional parts of a rename.
+type RenameParams struct {
+	// UpdateHostname also sets the guest hostname to the new name by regenerating
+	// the cloud-init seed; it takes effect on the next boot.
+	UpdateHostname bool
+	// SSHKeyPath is the public key written into the new seed; defaults as for Up.
+	SSHKeyPath string
+	// KnownHostsPath is rewritten from OLDHOST.local to NEWHOST.local when the
+	// hostname changes. Empty skips it.
+	KnownHostsPath string
+}
+
+// RenameResult reports what a rename changed.
+type RenameResult struct {
+	VM                *domain.VM
+	OldName           string
+	KnownHostsUpdated int
+}
+
+// Rename gives a stopped VM a new name. The VM directory moves with a single
+// rename, so the disk and nvram are never split between the two names.
+func (a *App) Rename(ctx context.Context, nameOrID, newName string, p RenameParams) (*RenameResult, error) {
+	if err := domain.ValidateName(newName); err != nil {
+		return nil, err
+	}
+	vm, err := a.Store.Load(ctx, nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	if vm.Name == newName {
+		return nil, fmt.Errorf("vm %s is already named %s", vm.Name, newName)
+	}
+	if err := a.offline(ctx, vm, "renaming"); err != nil {
+		return nil, err
+	}
+	release, err := a.lock(ctx, vm.Name, domain.LockExclusive)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	var key string
+	if p.UpdateHostname {
+		// Read the key before anything changes so a missing key fails cleanly.
+		if key, err = a.readSSHKey(a.findSSHKey(p.SSHKeyPath)); err != nil {
+			return nil, err
+		}
+	}
+
+	oldName, oldHost := vm.Name, vm.Hostname
+	if err := a.Store.Rename(ctx, vm, newName); err != nil {
+		return nil, err
+	}
+	if a.Run != nil {
+		if err := a.Run.Rename(ctx, oldName, newName); err != nil {
+			return nil, fmt.Errorf("renamed %s to %s, but moving its runtime files failed: %w", oldName, newName, err)
+		}
+	}
+	res := &RenameResult{VM: vm, OldName: oldName}
+	a.record(ctx, &domain.VM{Name: oldName, ID: vm.ID}, domain.EventConfigChanged, 0, "renamed to "+newName, nil)
+	a.record(ctx, vm, domain.EventConfigChanged, 0, "renamed from "+oldName, nil)
+	if !p.UpdateHostname {
+		return res, nil
+	}
+
+	vm.Hostname = newName
+	// Build next to the current seed and swap it in, so a failed build keeps the old one.
+	tmp := vm.SeedISOPath + ".tmp"
+	// The VM's host keys are already in known_hosts; the new instance-id must not
+	// replace them.
+	ci := domain.NewCloudInitWithFSAndBuilder(a.FS, a.SeedBuild)
+	ci.KeepHostKeys = true
+	if err := ci.Generate(ctx, *vm, key, tmp); err != nil {
+		_ = a.FS.Remove(tmp)
+		return res, fmt.Errorf("renamed %s to %s, but regenerating the seed failed: %w", oldName, newName, err)
+	}
+	if err := a.FS.Rename(tmp, vm.SeedISOPath); err != nil {
+		return res, err
+	}
+	if err := a.Store.Save(ctx, vm); err != nil {
+		return res, err
+	}
+	a.record(ctx, vm, domain.EventConfigChanged, 0, fmt.Sprintf("hostname %s -> %s", oldHost, newName), nil)
+	if p.KnownHostsPath != "" && oldHost != "" {
+		if res.KnownHostsUpdated, err = knownhosts.RenameHost(a.FS, p.KnownHostsPath, oldHost+".local", newName+".local"); err != nil {
+			return res, fmt.Errorf("updating %s: %w", p.KnownHostsPath, err)
+		}
+	}
+	return res, nil
+}
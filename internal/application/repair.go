@@ -0,0 +1,123 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+// Defaults for fields a repair cannot recover; they match the up defaults.
+const (
+	repairCPUs      = 2
+	repairMemoryMiB = 2048
+)
+
+// RepairResult describes a rebuilt record.
+type RepairResult struct {
+	VM *domain.VM
+	// Recovered lists the fields taken from the broken record; the rest were
+	// derived from the artifacts on disk or defaulted.
+	Recovered []string
+}
+
+// Repair rebuilds the record of a broken VM from what could be salvaged of the old
+// record and the artifacts in its directory (disk.img, nvram.bin, seed.iso).
+func (a *App) Repair(ctx context.Context, name string) (*RepairResult, error) {
+	_, broken, err := a.Store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var b *domain.BrokenVM
+	for i := range broken {
+		if broken[i].Name == name {
+			b = &broken[i]
+		}
+	}
+	if b == nil {
+		if _, err := a.Store.Load(ctx, name); err == nil {
+			return nil, fmt.Errorf("vm %s has a readable record; nothing to repair", name)
+		}
+		return nil, fmt.Errorf("%w: %s", domain.ErrVMNotFound, name)
+	}
+	// The record is unreadable, but the runtime state is kept by name.
+	if err := a.offline(ctx, &domain.VM{Name: name}, "repairing"); err != nil {
+		return nil, err
+	}
+	release, err := a.lock(ctx, name, domain.LockExclusive)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	dir := a.Artifacts.Dir(name)
+	disk, err := a.FS.Stat(filepath.Join(dir, "disk.img"))
+	if err != nil {
+		return nil, fmt.Errorf("vm %s: cannot repair without a disk: %w", name, err)
+	}
+	vm := domain.VM{}
+	res := &RepairResult{VM: &vm}
+	if r := b.Recovered; r != nil {
+		vm = *r
+		res.Recovered = recoveredFields(r)
+	}
+	vm.Name = name
+	vm.DiskPath = filepath.Join(dir, "disk.img")
+	vm.EFIVarsPath = a.existing(filepath.Join(dir, "nvram.bin"))
+	vm.SeedISOPath = filepath.Join(dir, "seed.iso") // recreated on demand by a reseed
+	if vm.DiskSizeGiB == 0 {
+		vm.DiskSizeGiB = int((disk.Size() + 1<<30 - 1) >> 30)
+	}
+	if vm.CPUs == 0 {
+		vm.CPUs = repairCPUs
+	}
+	if vm.MemoryMiB == 0 {
+		vm.MemoryMiB = repairMemoryMiB
+	}
+	if vm.Hostname == "" {
+		vm.Hostname = name
+	}
+	if vm.CreatedAt == 0 {
+		vm.CreatedAt = disk.ModTime().UnixNano()
+	}
+	if vm.ID == "" {
+		vm.ID = domain.NewID(time.Unix(0, vm.CreatedAt))
+	}
+	vm.LastObserved = nil
+	vm.Revision = 0
+	if err := a.Store.Recover(ctx, &vm); err != nil {
+		return nil, err
+	}
+	a.record(ctx, &vm, domain.EventConfigChanged, 0, "record rebuilt by repair; was: "+b.Error, nil)
+	return res, nil
+}
+
+// existing returns p if it exists and "" otherwise.
+func (a *App) existing(p string) string {
+	if _, err := a.FS.Stat(p); err != nil {
+		return ""
+	}
+	return p
+}
+
+func recoveredFields(vm *domain.VM) []string {
+	var out []string
+	add := func(name string, ok bool) {
+		if ok {
+			out = append(out, name)
+		}
+	}
+	add("id", vm.ID != "")
+	add("createdAt", vm.CreatedAt != 0)
+	add("cpus", vm.CPUs != 0)
+	add("memoryMiB", vm.MemoryMiB != 0)
+	add("diskSizeGiB", vm.DiskSizeGiB != 0)
+	add("macAddress", vm.MACAddress != "")
+	add("hostname", vm.Hostname != "")
+	add("baseImageRef", vm.BaseImageRef != "")
+	add("enableRosetta", vm.EnableRosetta)
+	add("labels", len(vm.Labels) > 0)
+	return out
+}
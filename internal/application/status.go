@@ -0,0 +1,199 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+// Observe determines vm's status from the shim and the runtime state. The result is
+// set on vm as LastObserved and, when the state changed, cached in the runtime
+// state; failing to cache it is not an error, since the next observation
+// recomputes it anyway. The VM's record is never written.
+func (a *App) Observe(ctx context.Context, vm *domain.VM) domain.VMStatus {
+	st := a.observe(ctx, vm.Name)
+	last := vm.LastObserved
+	if last == nil && a.Run != nil {
+		last, _ = a.Run.LastObserved(ctx, vm.Name)
+	}
+	if a.Run != nil && (last == nil || last.State != st.State || last.PID != st.PID || last.Stage != st.Stage) {
+		_ = a.Run.SaveObserved(ctx, vm.Name, st)
+	}
+	vm.LastObserved = &st
+	return st
+}
+
+func (a *App) observe(ctx context.Context, name string) domain.VMStatus {
+	st := domain.VMStatus{State: domain.StateStopped, ObservedAt: a.Clock.Now()}
+	var (
+		exit *domain.ShimExit
+		sup  *domain.SupervisorState
+	)
+	if a.Run != nil {
+		exit, _ = a.Run.LastExit(ctx, name)
+		st.LastExit = exit
+		if sup, _ = a.Run.Supervisor(ctx, name); sup != nil {
+			st.Restarts = sup.Restarts
+		}
+	}
+	if pid, err := a.Shim.GetPID(ctx, name); err == nil && pid > 0 {
+		st.State, st.PID = domain.StateRunning, pid
+		// The shim knows best, e.g. whether it is already stopping; older shims
+		// without a control socket are observed through their runtime files.
+		var info domain.ShimInfo
+		err := a.withControl(ctx, name, func(ctx context.Context, ctl domain.ShimControl) (err error) {
+			info, err = ctl.Status(ctx)
+			return err
+		})
+		if err == nil {
+			st.Stage, st.Readiness = info.Stage, info.Readiness
+			switch {
+			case info.Stopping:
+				st.State = domain.StateStopping
+			case !info.Readiness.Reached(domain.StageVMStarted):
+				st.State = domain.StateStarting
+			case info.Paused:
+				st.State = domain.StatePaused
+			}
+			return st
+		}
+		if a.Run != nil {
+			if rd, err := a.Run.Readiness(ctx, name); err == nil {
+				st.Stage, st.Readiness = rd.Stage(), rd
+				if !rd.Reached(domain.StageVMStarted) {
+					st.State = domain.StateStarting
+				}
+			}
+		}
+		return st
+	}
+	if a.Run == nil {
+		return st
+	}
+	// Between shims, a supervisor waiting to restart the VM stands in for them, so
+	// stopping the VM stops the supervisor.
+	if sup != nil && sup.Alive && !sup.NextRestart.IsZero() {
+		st.State, st.PID = domain.StateStarting, sup.PID
+		st.Reason = fmt.Sprintf("restart %d at %s", sup.Restarts, sup.NextRestart.Local().Format(time.TimeOnly))
+		if exit != nil {
+			st.Reason += " after: " + exit.Reason
+		}
+		return st
+	}
+	// The shim clears its pid file on every orderly exit, so one naming a dead
+	// process means it died without cleaning up, unless it recorded why.
+	if pid, err := a.Run.ReadPID(ctx, name); err == nil && pid > 0 {
+		st.State = domain.StateCrashed
+		st.Reason = fmt.Sprintf("shim (pid %d) exited without shutting the VM down", pid)
+		if exit != nil && exit.PID == pid {
+			st.Reason = exit.Reason
+		}
+		return st
+	}
+	if exit != nil {
+		st.Reason = exit.Reason
+		if exit.State == domain.StateCrashed {
+			st.State = domain.StateCrashed
+		}
+	}
+	return st
+}
+
+// controlTimeout bounds a request to a shim's control socket, so a wedged shim
+// does not hang the CLI.
+var controlTimeout = 5 * time.Second
+
+// withControl runs fn on a connection to the VM's shim. It fails with
+// domain.ErrNoControl if the shim cannot be reached that way.
+func (a *App) withControl(ctx context.Context, name string, fn func(context.Context, domain.ShimControl) error) error {
+	ctx, cancel := context.WithTimeout(ctx, controlTimeout)
+	defer cancel()
+	ctl, err := a.Shim.Control(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer ctl.Close()
+	return fn(ctx, ctl)
+}
+
+// transition observes vm and checks that it may move to the given state.
+func (a *App) transition(ctx context.Context, vm *domain.VM, to domain.VMState) (domain.VMStatus, error) {
+	st := a.Observe(ctx, vm)
+	return st, domain.CheckTransition(vm.Name, st.State, to)
+}
+
+// offline observes vm and fails unless no shim holds it, as renaming, exporting
+// and repairing it require; see domain.CheckOffline.
+func (a *App) offline(ctx context.Context, vm *domain.VM, verb string) error {
+	st := a.Observe(ctx, vm)
+	if err := domain.CheckOffline(vm.Name, st.State); err != nil {
+		return fmt.Errorf("%w; stop it before %s", err, verb)
+	}
+	return nil
+}
+
+// lock takes the VM's runtime lock. It is a no-op without runtime state.
+func (a *App) lock(ctx context.Context, name string, mode domain.LockMode) (func() error, error) {
+	if a.Run == nil {
+		return func() error { return nil }, nil
+	}
+	return a.Run.AcquireLock(ctx, name, mode)
+}
+
+// Waiting for a stopping VM's shim. The poll interval only applies without
+// runtime change notifications.
+var (
+	stopPollInterval = 100 * time.Millisecond
+	// stopExitTimeout is how long a shim gets to power its VM off and exit once
+	// the guest's time to shut down has run out.
+	stopExitTimeout = 15 * time.Second
+)
+
+// waitStopped waits for the shim of a VM that is stopping to exit and checks that
+// it ended in a legal state. The guest is given timeout to shut down.
+func (a *App) waitStopped(ctx context.Context, vm *domain.VM, timeout time.Duration) (domain.VMStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout+stopExitTimeout)
+	defer cancel()
+	var changes <-chan struct{}
+	tick := stopPollInterval
+	if a.Run != nil {
+		changes, tick = a.Run.Changes(ctx, vm.Name)
+	}
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	for {
+		st := a.Observe(ctx, vm)
+		if !st.Active() {
+			return st, domain.CheckTransition(vm.Name, domain.StateStopping, st.State)
+		}
+		select {
+		case <-ctx.Done():
+			return st, fmt.Errorf("waiting for vm %s to stop: %w", vm.Name, ctx.Err())
+		case _, ok := <-changes:
+			if !ok {
+				changes = nil
+				t.Reset(stopPollInterval)
+			}
+		case <-t.C:
+		}
+	}
+}
+
+// Status loads a VM and observes its current status.
+func (a *App) Status(ctx context.Context, nameOrID string) (*domain.VM, domain.VMStatus, error) {
+	vm, err := a.Store.Load(ctx, nameOrID)
+	if err != nil {
+		return nil, domain.VMStatus{}, err
+	}
+	return vm, a.Observe(ctx, vm), nil
+}
+
+// ifNone renders an unreached stage.
+func ifNone(s domain.ReadinessStage) string {
+	if s == "" {
+		return "none"
+	}
+	return string(s)
+}
@@ -9,7 +9,6 @@ import (
 	"os"
 
 	"github.com/alechenninger/orchard/internal/domain"
-	fsstore "github.com/alechenninger/orchard/internal/vmstore/fs"
 	"github.com/spf13/afero"
 )
 
@@ -19,11 +18,10 @@ type FsVmArtifacts struct {
 }
 
 func NewWithBaseDir(baseDir string) *FsVmArtifacts           { return &FsVmArtifacts{baseDir: baseDir, fs: afero.NewOsFs()} }
-func NewDefault() *FsVmArtifacts                             { return &FsVmArtifacts{baseDir: fsstore.DefaultBaseDir(), fs: afero.NewOsFs()} }
 func NewWithFS(baseDir string, fsys afero.Fs) *FsVmArtifacts { return &FsVmArtifacts{baseDir: baseDir, fs: fsys} }
 
 func (s *FsVmArtifacts) Prepare(ctx context.Context, vm *domain.VM) error {
-	vmDir := filepath.Join(s.baseDir, "vms", vm.Name)
+	vmDir := s.Dir(vm.Name)
 	af := &afero.Afero{Fs: s.fs}
 	if err := af.MkdirAll(vmDir, 0o755); err != nil {
 		return err
@@ -46,6 +44,8 @@ func (s *FsVmArtifacts) Prepare(ctx context.Context, vm *domain.VM) error {
 	return nil
 }
 
+func (s *FsVmArtifacts) Dir(name string) string { return filepath.Join(s.baseDir, "vms", name) }
+
 func copyFile(fsys afero.Fs, src, dst string) error {
 	in, err := fsys.Open(src)
 	if err != nil {
@@ -0,0 +1,79 @@
+package fs
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+type extent struct{ start, end int64 }
+
+// sharedExtentBytes intersects the physical extents of two files, which on APFS
+// reveals blocks still shared after clonefile(2).
+func sharedExtentBytes(a, b string) (int64, error) {
+	ea, err := physicalExtents(a)
+	if err != nil {
+		return 0, err
+	}
+	eb, err := physicalExtents(b)
+	if err != nil {
+		return 0, err
+	}
+	var shared int64
+	for i, j := 0, 0; i < len(ea) && j < len(eb); {
+		lo, hi := max(ea[i].start, eb[j].start), min(ea[i].end, eb[j].end)
+		if lo < hi {
+			shared += hi - lo
+		}
+		if ea[i].end < eb[j].end {
+			i++
+		} else {
+			j++
+		}
+	}
+	return shared, nil
+}
+
+// physicalExtents maps a file's data ranges to device offsets using F_LOG2PHYS_EXT,
+// skipping holes with SEEK_DATA. The result is sorted by device offset.
+func physicalExtents(path string) ([]extent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := st.Size()
+	fd := int(f.Fd())
+	var out []extent
+	for off := int64(0); off < size; {
+		data, err := unix.Seek(fd, off, unix.SEEK_DATA)
+		if err != nil {
+			break // ENXIO: no more data
+		}
+		// struct log2phys is packed: u32 flags, off_t contigbytes, off_t devoffset.
+		// On input devoffset is the file offset to map, not the fd's position; on
+		// output it is the device offset.
+		var l2p [20]byte
+		binary.LittleEndian.PutUint64(l2p[4:12], uint64(size-data))
+		binary.LittleEndian.PutUint64(l2p[12:20], uint64(data))
+		if _, _, errno := unix.Syscall(unix.SYS_FCNTL, uintptr(fd), unix.F_LOG2PHYS_EXT, uintptr(unsafe.Pointer(&l2p))); errno != 0 {
+			return nil, errno
+		}
+		contig := int64(binary.LittleEndian.Uint64(l2p[4:12]))
+		dev := int64(binary.LittleEndian.Uint64(l2p[12:20]))
+		if contig <= 0 {
+			break
+		}
+		out = append(out, extent{start: dev, end: dev + contig})
+		off = data + contig
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].start < out[j].start })
+	return out, nil
+}
@@ -0,0 +1,10 @@
+//go:build !darwin
+
+package fs
+
+import "errors"
+
+// sharedExtentBytes is only implemented for APFS clones on macOS.
+func sharedExtentBytes(a, b string) (int64, error) {
+	return 0, errors.New("shared extent accounting not supported on this platform")
+}
@@ -0,0 +1,84 @@
+package fs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/shimlog"
+	"github.com/spf13/afero"
+)
+
+// Log file names written into each VM directory.
+const (
+	SerialLogFile = "serial.log"
+	ShimLogFile   = shimlog.FileName
+)
+
+func (s *FsVmArtifacts) Usage(ctx context.Context, vm domain.VM) (domain.ArtifactUsage, error) {
+	var u domain.ArtifactUsage
+	if vm.DiskPath != "" {
+		st, err := s.fs.Stat(vm.DiskPath)
+		if err != nil && !os.IsNotExist(err) {
+			return u, err
+		}
+		if err == nil {
+			u.DiskLogicalBytes = st.Size()
+			u.DiskAllocatedBytes = allocated(st)
+		}
+		if vm.BaseImageRef != "" && u.DiskAllocatedBytes > 0 {
+			u.DiskSharedBytes = s.sharedBytes(vm.DiskPath, vm.BaseImageRef)
+		}
+	}
+	dir := s.Dir(vm.Name)
+	if st, err := s.fs.Stat(filepath.Join(dir, SerialLogFile)); err == nil {
+		u.SerialLogBytes = st.Size()
+	}
+	for _, p := range shimlog.Files(s.fs, filepath.Join(dir, ShimLogFile)) {
+		if st, err := s.fs.Stat(p); err == nil {
+			u.ShimLogBytes += st.Size()
+		}
+	}
+	return u, nil
+}
+
+func (s *FsVmArtifacts) HomeUsage(ctx context.Context) (int64, error) {
+	var total int64
+	err := afero.Walk(s.fs, s.baseDir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			total += allocated(info)
+		}
+		return nil
+	})
+	return total, err
+}
+
+// sharedBytes reports how much of disk's storage is physically shared with base.
+// Only meaningful on the host filesystem; other filesystems report zero.
+func (s *FsVmArtifacts) sharedBytes(disk, base string) int64 {
+	if _, ok := s.fs.(*afero.OsFs); !ok {
+		return 0
+	}
+	n, err := sharedExtentBytes(disk, base)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// allocated returns the bytes backing a file on disk, falling back to its logical
+// size when the filesystem does not expose block counts.
+func allocated(fi os.FileInfo) int64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+	return fi.Size()
+}
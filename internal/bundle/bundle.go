@@ -0,0 +1,345 @@
+// Package bundle reads and writes portable VM export bundles.
+//
+// A bundle is a zstd-compressed tar stream with the following entries, in order:
+//
+//	manifest.json        format identifier, version and disk geometry
+//	config.json          the exported domain.VM record, schema-versioned
+//	nvram.bin            EFI variable store
+//	disk/<offset>        one entry per run of non-zero disk blocks
+//	checksums.json       sha256 of config, nvram and disk contents
+//
+// Disk holes are never written to the stream; on import the disk is truncated to
+// its logical size and only the data runs are written back, so sparseness survives
+// the round trip.
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/vmstore/schema"
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/afero"
+)
+
+const (
+	// Format identifies orchard bundles in the manifest.
+	Format = "orchard-vm-bundle"
+	// Version is the bundle layout version written by this package.
+	Version = 1
+
+	// BlockSize is the granularity at which zero blocks are detected and skipped.
+	BlockSize = 4096
+	// chunkSize bounds the size of a single disk entry (and export memory use).
+	chunkSize = 1 << 20
+
+	manifestEntry  = "manifest.json"
+	configEntry    = "config.json"
+	nvramEntry     = "nvram.bin"
+	diskPrefix     = "disk/"
+	checksumsEntry = "checksums.json"
+
+	// DiskFile and NVRAMFile are the file names used for imported artifacts.
+	DiskFile  = "disk.img"
+	NVRAMFile = "nvram.bin"
+)
+
+var (
+	// ErrIncompatible is returned when a bundle was written by an unsupported format or version.
+	ErrIncompatible = errors.New("incompatible bundle")
+	// ErrCorrupt is returned when a bundle is malformed or fails checksum verification.
+	ErrCorrupt = errors.New("corrupt bundle")
+)
+
+// Manifest describes the bundle contents.
+type Manifest struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	Name       string    `json:"name"`
+	ExportedAt time.Time `json:"exportedAt"`
+	DiskSize   int64     `json:"diskSize"`
+	BlockSize  int       `json:"blockSize"`
+}
+
+// Checksums holds hex-encoded sha256 digests of the bundle payloads.
+type Checksums struct {
+	Config string `json:"config"`
+	NVRAM  string `json:"nvram"`
+	Disk   string `json:"disk"`
+}
+
+// Write streams vm's record, nvram and disk to w as a bundle.
+func Write(w io.Writer, fsys afero.Fs, vm domain.VM, now time.Time) error {
+	disk, err := fsys.Open(vm.DiskPath)
+	if err != nil {
+		return fmt.Errorf("open disk: %w", err)
+	}
+	defer disk.Close()
+	st, err := disk.Stat()
+	if err != nil {
+		return err
+	}
+	nvram, err := afero.ReadFile(fsys, vm.EFIVarsPath)
+	if err != nil {
+		return fmt.Errorf("read nvram: %w", err)
+	}
+
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+
+	m := Manifest{Format: Format, Version: Version, Name: vm.Name, ExportedAt: now.UTC(), DiskSize: st.Size(), BlockSize: BlockSize}
+	mb, _ := json.MarshalIndent(m, "", "  ")
+	if err := writeEntry(tw, manifestEntry, mb, now); err != nil {
+		return err
+	}
+	cb, err := schema.Encode(vm)
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(tw, configEntry, cb, now); err != nil {
+		return err
+	}
+	if err := writeEntry(tw, nvramEntry, nvram, now); err != nil {
+		return err
+	}
+	diskSum := sha256.New()
+	if err := writeDisk(tw, disk, st.Size(), diskSum, now); err != nil {
+		return err
+	}
+	sums := Checksums{Config: digest(cb), NVRAM: digest(nvram), Disk: hex.EncodeToString(diskSum.Sum(nil))}
+	sb, _ := json.MarshalIndent(sums, "", "  ")
+	if err := writeEntry(tw, checksumsEntry, sb, now); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeDisk emits one tar entry per run of non-zero blocks, never spanning a chunk boundary.
+func writeDisk(tw *tar.Writer, disk io.Reader, size int64, sum hash.Hash, now time.Time) error {
+	buf := make([]byte, chunkSize)
+	var off int64
+	for off < size {
+		n, err := io.ReadFull(disk, buf[:min(int64(chunkSize), size-off)])
+		if err != nil {
+			return fmt.Errorf("read disk at %d: %w", off, err)
+		}
+		chunk := buf[:n]
+		for start := 0; start < n; {
+			if isZero(chunk[start:min(start+BlockSize, n)]) {
+				start += BlockSize
+				continue
+			}
+			end := start + BlockSize
+			for end < n && !isZero(chunk[end:min(end+BlockSize, n)]) {
+				end += BlockSize
+			}
+			end = min(end, n)
+			run := chunk[start:end]
+			hashRun(sum, off+int64(start), run)
+			if err := writeEntry(tw, diskPrefix+fmt.Sprintf("%016x", off+int64(start)), run, now); err != nil {
+				return err
+			}
+			start = end
+		}
+		off += int64(n)
+	}
+	return nil
+}
+
+// Read unpacks a bundle from r into dstDir, which must not exist yet. It verifies the
+// format, version and checksums and returns the manifest and the exported record with
+// its artifact paths rewritten to dstDir. On error dstDir is removed.
+func Read(r io.Reader, fsys afero.Fs, dstDir string) (m *Manifest, vm *domain.VM, err error) {
+	if _, err := fsys.Stat(dstDir); err == nil {
+		return nil, nil, fmt.Errorf("%s already exists", dstDir)
+	}
+	if err := fsys.MkdirAll(dstDir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = fsys.RemoveAll(dstDir)
+		}
+	}()
+
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	m = &Manifest{}
+	if err := readJSONEntry(tr, manifestEntry, m); err != nil {
+		return nil, nil, err
+	}
+	if m.Format != Format {
+		return nil, nil, fmt.Errorf("%w: unknown format %q", ErrIncompatible, m.Format)
+	}
+	if m.Version != Version {
+		return nil, nil, fmt.Errorf("%w: version %d not supported (want %d)", ErrIncompatible, m.Version, Version)
+	}
+	if m.DiskSize < 0 {
+		return nil, nil, fmt.Errorf("%w: negative disk size", ErrCorrupt)
+	}
+
+	cb, err := readEntry(tr, configEntry)
+	if err != nil {
+		return nil, nil, err
+	}
+	if vm, err = schema.Decode(cb); err != nil {
+		return nil, nil, fmt.Errorf("%w: config: %v", ErrIncompatible, err)
+	}
+	nvram, err := readEntry(tr, nvramEntry)
+	if err != nil {
+		return nil, nil, err
+	}
+	nvramPath := filepath.Join(dstDir, NVRAMFile)
+	if err := afero.WriteFile(fsys, nvramPath, nvram, 0o644); err != nil {
+		return nil, nil, err
+	}
+
+	diskPath := filepath.Join(dstDir, DiskFile)
+	disk, err := fsys.OpenFile(diskPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer disk.Close()
+	if err := disk.Truncate(m.DiskSize); err != nil {
+		return nil, nil, err
+	}
+	diskSum := sha256.New()
+	var sb []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil, fmt.Errorf("%w: missing %s", ErrCorrupt, checksumsEntry)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		if hdr.Name == checksumsEntry {
+			if sb, err = io.ReadAll(tr); err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+			}
+			break
+		}
+		if err := readDiskRun(tr, hdr, disk, m.DiskSize, diskSum); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := disk.Sync(); err != nil {
+		return nil, nil, err
+	}
+
+	var sums Checksums
+	if err := json.Unmarshal(sb, &sums); err != nil {
+		return nil, nil, fmt.Errorf("%w: checksums: %v", ErrCorrupt, err)
+	}
+	if got := digest(cb); got != sums.Config {
+		return nil, nil, fmt.Errorf("%w: config checksum mismatch", ErrCorrupt)
+	}
+	if got := digest(nvram); got != sums.NVRAM {
+		return nil, nil, fmt.Errorf("%w: nvram checksum mismatch", ErrCorrupt)
+	}
+	if got := hex.EncodeToString(diskSum.Sum(nil)); got != sums.Disk {
+		return nil, nil, fmt.Errorf("%w: disk checksum mismatch", ErrCorrupt)
+	}
+
+	vm.DiskPath = diskPath
+	vm.EFIVarsPath = nvramPath
+	return m, vm, nil
+}
+
+func readDiskRun(tr *tar.Reader, hdr *tar.Header, disk afero.File, size int64, sum hash.Hash) error {
+	if !strings.HasPrefix(hdr.Name, diskPrefix) {
+		return fmt.Errorf("%w: unexpected entry %q", ErrCorrupt, hdr.Name)
+	}
+	off, err := strconv.ParseInt(path.Base(hdr.Name), 16, 64)
+	if err != nil || off < 0 || hdr.Size > chunkSize || off+hdr.Size > size {
+		return fmt.Errorf("%w: bad disk entry %q", ErrCorrupt, hdr.Name)
+	}
+	run := make([]byte, hdr.Size)
+	if _, err := io.ReadFull(tr, run); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	hashRun(sum, off, run)
+	if _, err := disk.WriteAt(run, off); err != nil {
+		return err
+	}
+	return nil
+}
+
+func writeEntry(tw *tar.Writer, name string, b []byte, now time.Time) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(b)), ModTime: now, Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(b)
+	return err
+}
+
+func readEntry(tr *tar.Reader, name string) ([]byte, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading %s: %v", ErrCorrupt, name, err)
+	}
+	if hdr.Name != name {
+		return nil, fmt.Errorf("%w: expected %s, found %q", ErrCorrupt, name, hdr.Name)
+	}
+	b, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading %s: %v", ErrCorrupt, name, err)
+	}
+	return b, nil
+}
+
+func readJSONEntry(tr *tar.Reader, name string, v any) error {
+	b, err := readEntry(tr, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrCorrupt, name, err)
+	}
+	return nil
+}
+
+// hashRun feeds a disk run into the disk digest, binding the data to its offset.
+func hashRun(h hash.Hash, off int64, run []byte) {
+	var hdr [16]byte
+	binary.BigEndian.PutUint64(hdr[:8], uint64(off))
+	binary.BigEndian.PutUint64(hdr[8:], uint64(len(run)))
+	h.Write(hdr[:])
+	h.Write(run)
+}
+
+func digest(b []byte) string {
+	s := sha256.Sum256(b)
+	return hex.EncodeToString(s[:])
+}
+
+var zeroBlock = make([]byte, BlockSize)
+
+func isZero(b []byte) bool { return bytes.Equal(b, zeroBlock[:len(b)]) }
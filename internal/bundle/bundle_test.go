@@ -0,0 +1,190 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/afero"
+)
+
+var epoch = time.Unix(1700000000, 0)
+
+// sparseVM writes a 20 MiB disk with data only at its head and at 10 MiB.
+func sparseVM(t *testing.T, fsys afero.Fs, dir string) domain.VM {
+	t.Helper()
+	vm := domain.VM{Name: "vm-001", VMSpec: domain.VMSpec{CPUs: 2, MemoryMiB: 1024, DiskPath: filepath.Join(dir, "disk.img"), EFIVarsPath: filepath.Join(dir, "nvram.bin")}}
+	if err := fsys.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Create(vm.DiskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(20 << 20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("boot sector"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(bytes.Repeat([]byte{0xab}, 3*BlockSize+7), 10<<20); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if err := afero.WriteFile(fsys, vm.EFIVarsPath, []byte("nvram"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+func TestRoundTripKeepsHoles(t *testing.T) {
+	t.Parallel()
+	fsys := afero.NewOsFs()
+	root := t.TempDir()
+	vm := sparseVM(t, fsys, filepath.Join(root, "src"))
+
+	var buf bytes.Buffer
+	if err := Write(&buf, fsys, vm, epoch); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if buf.Len() > 1<<20 {
+		t.Fatalf("bundle too large for sparse disk: %d bytes", buf.Len())
+	}
+
+	dst := filepath.Join(root, "dst")
+	m, got, err := Read(&buf, fsys, dst)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if m.Name != vm.Name || m.DiskSize != 20<<20 {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+	if got.CPUs != 2 || got.DiskPath != filepath.Join(dst, DiskFile) || got.EFIVarsPath != filepath.Join(dst, NVRAMFile) {
+		t.Fatalf("unexpected record: %+v", got)
+	}
+	want, _ := afero.ReadFile(fsys, vm.DiskPath)
+	have, _ := afero.ReadFile(fsys, got.DiskPath)
+	if !bytes.Equal(want, have) {
+		t.Fatalf("disk contents differ")
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(got.DiskPath, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Blocks*512 >= 20<<20 {
+		t.Fatalf("imported disk is not sparse: %d bytes allocated", st.Blocks*512)
+	}
+}
+
+func TestReadRejectsCorruptDisk(t *testing.T) {
+	t.Parallel()
+	fsys := afero.NewMemMapFs()
+	vm := sparseVM(t, fsys, "/src")
+	var buf bytes.Buffer
+	if err := Write(&buf, fsys, vm, epoch); err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-encode with one byte of the second disk run flipped.
+	corrupt := rewrite(t, buf.Bytes(), func(hdr *tar.Header, b []byte) []byte {
+		if hdr.Name == diskPrefix+"0000000000a00000" {
+			b[0] ^= 0xff
+		}
+		return b
+	})
+	_, _, err := Read(bytes.NewReader(corrupt), fsys, "/dst")
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	if _, err := fsys.Stat("/dst"); err == nil {
+		t.Fatalf("expected destination to be removed after failed import")
+	}
+}
+
+func TestReadRejectsTruncatedBundle(t *testing.T) {
+	t.Parallel()
+	fsys := afero.NewMemMapFs()
+	vm := sparseVM(t, fsys, "/src")
+	var buf bytes.Buffer
+	if err := Write(&buf, fsys, vm, epoch); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := Read(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), fsys, "/dst")
+	if err == nil {
+		t.Fatalf("expected error for truncated bundle")
+	}
+}
+
+func TestReadRejectsIncompatibleVersion(t *testing.T) {
+	t.Parallel()
+	fsys := afero.NewMemMapFs()
+	vm := sparseVM(t, fsys, "/src")
+	var buf bytes.Buffer
+	if err := Write(&buf, fsys, vm, epoch); err != nil {
+		t.Fatal(err)
+	}
+	future := rewrite(t, buf.Bytes(), func(hdr *tar.Header, b []byte) []byte {
+		if hdr.Name != manifestEntry {
+			return b
+		}
+		var m Manifest
+		_ = json.Unmarshal(b, &m)
+		m.Version = Version + 1
+		out, _ := json.Marshal(m)
+		return out
+	})
+	_, _, err := Read(bytes.NewReader(future), fsys, "/dst")
+	if !errors.Is(err, ErrIncompatible) {
+		t.Fatalf("expected ErrIncompatible, got %v", err)
+	}
+}
+
+func TestReadRejectsForeignArchive(t *testing.T) {
+	t.Parallel()
+	fsys := afero.NewMemMapFs()
+	_, _, err := Read(bytes.NewReader([]byte("not a bundle")), fsys, "/dst")
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+// rewrite decodes a bundle, passes every entry through fn and re-encodes it.
+func rewrite(t *testing.T, in []byte, fn func(*tar.Header, []byte) []byte) []byte {
+	t.Helper()
+	zr, err := zstd.NewReader(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	var out bytes.Buffer
+	zw, _ := zstd.NewWriter(&out)
+	tw := tar.NewWriter(zw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		b = fn(hdr, b)
+		hdr.Size = int64(len(b))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = tw.Write(b)
+	}
+	_ = tw.Close()
+	_ = zw.Close()
+	return out.Bytes()
+}
@@ -3,33 +3,44 @@ package cli
 import (
 	"fmt"
 
-	"github.com/alechenninger/orchard/internal/application"
 	"github.com/spf13/cobra"
 )
 
-var flagDeleteForce bool
+var (
+	flagDeleteForce    bool
+	flagDeleteSelector string
+)
 
 func init() {
 	rootCmd.AddCommand(deleteCmd)
 	deleteCmd.Flags().BoolVarP(&flagDeleteForce, "force", "f", false, "force stop if running before delete")
+	deleteCmd.Flags().StringVarP(&flagDeleteSelector, "selector", "l", "", "delete every VM matching this label selector")
 }
 
 var deleteCmd = &cobra.Command{
 	Use:   "delete NAME",
 	Short: "Delete a VM and its resources",
-	Args:  cobra.ExactArgs(1),
+	Args:  nameOrSelector(&flagDeleteSelector),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
-		app := application.NewDefault()
-		name := args[0]
-		if err := app.Delete(ctx, name, flagDeleteForce); err != nil {
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		names, err := targets(ctx, app, args, flagDeleteSelector)
+		if err != nil {
 			return err
 		}
-		if flagJSON {
-			fmt.Printf("{\"name\":\"%s\",\"deleted\":true}\n", name)
-			return nil
+		for _, name := range names {
+			if err := app.Delete(ctx, name, flagDeleteForce); err != nil {
+				return err
+			}
+			if flagJSON {
+				fmt.Printf("{\"name\":\"%s\",\"deleted\":true}\n", name)
+				continue
+			}
+			fmt.Printf("Deleted %s\n", name)
 		}
-		fmt.Printf("Deleted %s\n", name)
 		return nil
 	},
 }
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagEventsFollow bool
+	flagEventsSince  string
+)
+
+func init() {
+	rootCmd.AddCommand(eventsCmd)
+	eventsCmd.Flags().BoolVarP(&flagEventsFollow, "follow", "f", false, "keep printing events as they are recorded")
+	eventsCmd.Flags().StringVar(&flagEventsSince, "since", "", "only show events since a duration ago (e.g. 1h) or an RFC 3339 time")
+}
+
+var eventsCmd = &cobra.Command{
+	Use:   "events [NAME]",
+	Short: "Show VM lifecycle events",
+	Long: `Show the lifecycle event journal of a VM, or of every VM when NAME is omitted.
+Journals are kept after a VM is deleted.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		var name string
+		if len(args) == 1 {
+			name = args[0]
+		}
+		since, err := parseSince(flagEventsSince, app.Clock.Now())
+		if err != nil {
+			return err
+		}
+		if flagEventsFollow {
+			live, err := app.FollowEvents(ctx, name, since)
+			if err != nil {
+				return err
+			}
+			for e := range live {
+				printEvent(e)
+			}
+			return nil
+		}
+		evs, err := app.Events(ctx, name, since)
+		if err != nil {
+			return err
+		}
+		for _, e := range evs {
+			printEvent(e)
+		}
+		return nil
+	},
+}
+
+// parseSince accepts a duration before now or an absolute RFC 3339 time. An empty
+// value means the beginning of the journal.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: want a duration like 30m or an RFC 3339 time", s)
+}
+
+func printEvent(e domain.Event) {
+	if flagJSON {
+		b, _ := json.Marshal(e)
+		fmt.Println(string(b))
+		return
+	}
+	line := fmt.Sprintf("%s  %-8s %-14s %-5s", e.Time.Local().Format(time.RFC3339), e.VM, e.Type, e.Actor)
+	if e.PID != 0 {
+		line += " pid=" + strconv.Itoa(e.PID)
+	}
+	if e.Message != "" {
+		line += " " + e.Message
+	}
+	if e.Error != "" {
+		line += " error=" + strconv.Quote(e.Error)
+	}
+	fmt.Println(line)
+}
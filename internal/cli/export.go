@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var flagExportOutput string
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVarP(&flagExportOutput, "output", "o", "", "bundle path (default NAME.tar.zst; - for stdout)")
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export NAME",
+	Short: "Export a stopped VM to a portable bundle",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		out := flagExportOutput
+		if out == "" {
+			out = args[0] + ".tar.zst"
+		}
+		if out == "-" {
+			return app.Export(ctx, args[0], os.Stdout)
+		}
+		// Write to a temp file beside the target so a failed export leaves nothing behind.
+		f, err := os.CreateTemp(filepath.Dir(out), filepath.Base(out)+".*.tmp")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		if err := app.Export(ctx, args[0], f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(f.Name(), out); err != nil {
+			return err
+		}
+		if flagJSON {
+			fmt.Printf("{\"name\":\"%s\",\"bundle\":\"%s\"}\n", args[0], out)
+			return nil
+		}
+		fmt.Printf("Exported %s to %s\n", args[0], out)
+		return nil
+	},
+}
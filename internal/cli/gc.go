@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alechenninger/orchard/internal/application"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagGCDryRun bool
+	flagGCYes    bool
+)
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+	gcCmd.Flags().BoolVar(&flagGCDryRun, "dry-run", false, "report reclaimable items without removing them")
+	gcCmd.Flags().BoolVarP(&flagGCYes, "yes", "y", false, "remove without asking for confirmation")
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove orphaned VM state, stale runtime files and leftover temp files",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		items, err := app.CollectGarbage(ctx)
+		if err != nil {
+			return err
+		}
+		var total int64
+		for _, g := range items {
+			total += g.Bytes
+		}
+		if flagJSON {
+			b, _ := json.Marshal(struct {
+				Items   []application.Garbage `json:"items"`
+				Bytes   int64                 `json:"bytes"`
+				Removed bool                  `json:"removed"`
+			}{items, total, !flagGCDryRun && flagGCYes})
+			if !flagGCDryRun && flagGCYes && len(items) > 0 {
+				if err := app.RemoveGarbage(ctx, items); err != nil {
+					return err
+				}
+			}
+			fmt.Println(string(b))
+			return nil
+		}
+		if len(items) == 0 {
+			fmt.Println("Nothing to clean up")
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "KIND\tVM\tSIZE\tPATH")
+		for _, g := range items {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", g.Kind, ifEmpty(g.VM, "-"), humanBytes(g.Bytes), g.Path)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("%d items, %s reclaimable\n", len(items), humanBytes(total))
+		if flagGCDryRun {
+			return nil
+		}
+		if !flagGCYes && !confirm(fmt.Sprintf("Remove %d items?", len(items))) {
+			fmt.Println("Aborted")
+			return nil
+		}
+		if err := app.RemoveGarbage(ctx, items); err != nil {
+			return err
+		}
+		fmt.Printf("Reclaimed %s\n", humanBytes(total))
+		return nil
+	},
+}
+
+// confirm asks a yes/no question on stdin, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var flagImportName string
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringVar(&flagImportName, "name", "", "name for the imported VM (default: exported name)")
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import BUNDLE",
+	Short: "Import a VM from a bundle created by export",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		vm, err := app.Import(ctx, r, flagImportName)
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			fmt.Printf("{\"name\":\"%s\",\"imported\":true}\n", vm.Name)
+			return nil
+		}
+		fmt.Printf("Imported VM %s\n", vm.Name)
+		return nil
+	},
+}
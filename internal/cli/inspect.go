@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/selector"
+	"github.com/spf13/cobra"
+)
+
+func init() { rootCmd.AddCommand(inspectCmd) }
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect NAME",
+	Short: "Show a VM's configuration and storage usage",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		vm, u, err := app.Inspect(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		st := app.Observe(ctx, vm)
+		if flagJSON {
+			b, _ := json.MarshalIndent(struct {
+				VM     *domain.VM           `json:"vm"`
+				Status domain.VMStatus      `json:"status"`
+				Usage  domain.ArtifactUsage `json:"usage"`
+			}{vm, st, u}, "", "  ")
+			fmt.Println(string(b))
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "ID:\t%s\n", vm.ID)
+		fmt.Fprintf(tw, "Name:\t%s\n", vm.Name)
+		fmt.Fprintf(tw, "Status:\t%s\n", describeStatus(st))
+		fmt.Fprintf(tw, "CPUs:\t%d\n", vm.CPUs)
+		fmt.Fprintf(tw, "Memory:\t%d MiB\n", vm.MemoryMiB)
+		fmt.Fprintf(tw, "Hostname:\t%s\n", vm.Hostname)
+		fmt.Fprintf(tw, "Restart policy:\t%s\n", vm.RestartPolicy)
+		fmt.Fprintf(tw, "Labels:\t%s\n", ifEmpty(selector.FormatLabels(vm.Labels), "<none>"))
+		fmt.Fprintf(tw, "Base image:\t%s\n", vm.BaseImageRef)
+		fmt.Fprintf(tw, "Disk:\t%s\n", vm.DiskPath)
+		fmt.Fprintf(tw, "  Logical size:\t%s\n", humanBytes(u.DiskLogicalBytes))
+		fmt.Fprintf(tw, "  Allocated:\t%s\n", humanBytes(u.DiskAllocatedBytes))
+		fmt.Fprintf(tw, "  Shared with base:\t%s\n", humanBytes(u.DiskSharedBytes))
+		fmt.Fprintf(tw, "Serial log:\t%s\n", humanBytes(u.SerialLogBytes))
+		fmt.Fprintf(tw, "Shim log:\t%s\n", humanBytes(u.ShimLogBytes))
+		return tw.Flush()
+	},
+}
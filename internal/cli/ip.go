@@ -3,7 +3,6 @@ package cli
 import (
 	"fmt"
 
-	"github.com/alechenninger/orchard/internal/application"
 	"github.com/spf13/cobra"
 )
 
@@ -15,7 +14,10 @@ var ipCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
-		app := application.NewDefault()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
 		ip, err := app.IP(ctx, args[0])
 		if err != nil {
 			return err
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alechenninger/orchard/internal/selector"
+	"github.com/spf13/cobra"
+)
+
+func init() { rootCmd.AddCommand(labelCmd) }
+
+var labelCmd = &cobra.Command{
+	Use:   "label NAME KEY=VALUE... [KEY-...]",
+	Short: "Set or remove VM labels (KEY- removes KEY)",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		var assignments, remove []string
+		for _, a := range args[1:] {
+			if k, ok := strings.CutSuffix(a, "-"); ok && !strings.Contains(a, "=") {
+				if err := selector.ValidateKey(k); err != nil {
+					return err
+				}
+				remove = append(remove, k)
+				continue
+			}
+			assignments = append(assignments, a)
+		}
+		set, err := selector.ParseLabels(assignments)
+		if err != nil {
+			return err
+		}
+		vm, err := app.Label(ctx, args[0], set, remove)
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			fmt.Printf("{\"name\":\"%s\",\"labels\":%s}\n", vm.Name, labelsJSON(vm.Labels))
+			return nil
+		}
+		fmt.Printf("Labeled %s: %s\n", vm.Name, ifEmpty(selector.FormatLabels(vm.Labels), "<none>"))
+		return nil
+	},
+}
+
+func labelsJSON(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%q:%q", k, labels[k])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
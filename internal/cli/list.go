@@ -6,12 +6,16 @@ import (
 	"os"
 	"text/tabwriter"
 
-	"github.com/alechenninger/orchard/internal/application"
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/selector"
 	"github.com/spf13/cobra"
 )
 
+var flagListSelector string
+
 func init() {
 	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().StringVarP(&flagListSelector, "selector", "l", "", "label selector, e.g. team=payments,env in (test,dev)")
 }
 
 var listCmd = &cobra.Command{
@@ -19,23 +23,69 @@ var listCmd = &cobra.Command{
 	Short: "List VMs",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
-		app := application.NewDefault()
-		vms, err := app.ListVMs(ctx)
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		sel, err := selector.Parse(flagListSelector)
+		if err != nil {
+			return err
+		}
+		vms, err := app.SelectVMs(ctx, sel)
+		if err != nil {
+			return err
+		}
+		// Broken VMs carry no trustworthy labels, so they are only shown unfiltered.
+		var broken []domain.BrokenVM
+		if sel.Empty() {
+			if _, broken, err = app.ListVMs(ctx); err != nil {
+				return err
+			}
+		}
+		total, err := app.HomeUsage(ctx)
 		if err != nil {
 			return err
 		}
 		if flagJSON {
-			for _, vm := range vms {
-				slog.Info("vm", "name", vm.Name, "status", vm.Status, "cpus", vm.CPUs, "memoryMiB", vm.MemoryMiB)
+			for i, vm := range vms {
+				u, _ := app.Usage(ctx, vm)
+				st := app.Observe(ctx, &vms[i])
+				slog.Info("vm", "id", vm.ID, "name", vm.Name, "status", st.State, "pid", st.PID, "cpus", vm.CPUs, "memoryMiB", vm.MemoryMiB,
+					"labels", selector.FormatLabels(vm.Labels), "diskLogicalBytes", u.DiskLogicalBytes, "diskAllocatedBytes", u.DiskAllocatedBytes, "diskSharedBytes", u.DiskSharedBytes,
+					"serialLogBytes", u.SerialLogBytes, "shimLogBytes", u.ShimLogBytes)
+			}
+			for _, b := range broken {
+				slog.Info("vm", "name", b.Name, "status", "BROKEN", "dir", b.Dir, "problem", b.Kind, "error", b.Error, "recovered", b.Recovered != nil)
 			}
+			slog.Info("total", "homeAllocatedBytes", total)
 			return nil
 		}
 		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-		fmt.Fprintln(tw, "NAME\tSTATUS\tCPUS\tMEM(MiB)")
-		for _, vm := range vms {
-			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", vm.Name, ifEmpty(vm.Status, "stopped"), vm.CPUs, vm.MemoryMiB)
+		fmt.Fprintln(tw, "ID\tNAME\tSTATUS\tCPUS\tMEM(MiB)\tDISK\tALLOCATED\tSHARED\tLOGS")
+		for i, vm := range vms {
+			u, _ := app.Usage(ctx, vm)
+			st := app.Observe(ctx, &vms[i])
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n", shortID(vm.ID), vm.Name, st.State, vm.CPUs, vm.MemoryMiB,
+				humanBytes(u.DiskLogicalBytes), humanBytes(u.DiskAllocatedBytes), humanBytes(u.DiskSharedBytes), humanBytes(u.SerialLogBytes+u.ShimLogBytes))
+		}
+		for _, b := range broken {
+			id := "-"
+			if b.Recovered != nil && b.Recovered.ID != "" {
+				id = shortID(b.Recovered.ID)
+			}
+			fmt.Fprintf(tw, "%s\t%s\tBROKEN\t-\t-\t-\t-\t-\t-\n", id, b.Name)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
 		}
-		return tw.Flush()
+		if len(broken) > 0 {
+			fmt.Println()
+		}
+		for _, b := range broken {
+			fmt.Printf("%s: %s: %s\n  run `orchard repair %s` to rebuild its record from %s\n", b.Name, b.Kind, b.Error, b.Name, b.Dir)
+		}
+		fmt.Printf("\nTotal on disk: %s\n", humanBytes(total))
+		return nil
 	},
 }
 
@@ -45,3 +95,11 @@ func ifEmpty(s, alt string) string {
 	}
 	return s
 }
+
+// shortID abbreviates a VM ID for tables; any unambiguous prefix is accepted as NAME.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/application"
+	"github.com/alechenninger/orchard/internal/shimlog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagLogsShim   bool
+	flagLogsSerial bool
+	flagLogsFollow bool
+	flagLogsSince  string
+)
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.Flags().BoolVar(&flagLogsShim, "shim", false, "show the shim's log (the default)")
+	logsCmd.Flags().BoolVar(&flagLogsSerial, "serial", false, "show the guest's serial console")
+	logsCmd.Flags().BoolVarP(&flagLogsFollow, "follow", "f", false, "keep printing lines as they are written")
+	logsCmd.Flags().StringVar(&flagLogsSince, "since", "", "only show shim log entries since a duration ago (e.g. 1h) or an RFC 3339 time")
+	logsCmd.MarkFlagsMutuallyExclusive("shim", "serial")
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs NAME",
+	Short: "Show a VM's shim log or serial console",
+	Long: `Show the log of a VM's shim, which is rotated by size and kept in the VM's
+directory, or with --serial the output of the guest's serial console.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		src := application.LogShim
+		if flagLogsSerial {
+			src = application.LogSerial
+		}
+		since, err := parseSince(flagLogsSince, app.Clock.Now())
+		if err != nil {
+			return err
+		}
+		if flagLogsFollow {
+			live, err := app.FollowLogs(ctx, args[0], src, since)
+			if err != nil {
+				return err
+			}
+			for e := range live {
+				printLogEntry(e)
+			}
+			return nil
+		}
+		es, err := app.Logs(ctx, args[0], src, since)
+		if err != nil {
+			return err
+		}
+		for _, e := range es {
+			printLogEntry(e)
+		}
+		return nil
+	},
+}
+
+func printLogEntry(e shimlog.Entry) {
+	if flagJSON {
+		b, _ := json.Marshal(e)
+		fmt.Println(string(b))
+		return
+	}
+	// Plain lines, such as serial console output, are printed as they were written.
+	if e.Level == "" {
+		fmt.Println(e.Message)
+		return
+	}
+	line := fmt.Sprintf("%s  %-5s %s", e.Time.Local().Format(time.RFC3339), e.Level, e.Message)
+	for _, k := range slices.Sorted(maps.Keys(e.Attrs)) {
+		line += fmt.Sprintf(" %s=%v", k, e.Attrs[k])
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var flagMigrateCheck bool
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().BoolVar(&flagMigrateCheck, "check", false, "report pending migrations without changing anything")
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade stored VM records to the current config schema",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		migs, err := app.Migrate(ctx, flagMigrateCheck)
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			b, _ := json.Marshal(migs)
+			fmt.Println(string(b))
+			return nil
+		}
+		if len(migs) == 0 {
+			fmt.Println("All VM records are up to date")
+			return nil
+		}
+		verb := "Migrated"
+		if flagMigrateCheck {
+			verb = "Would migrate"
+		}
+		for _, m := range migs {
+			fmt.Printf("%s %s from v%d to v%d\n", verb, m.VM, m.From, m.To)
+			fmt.Printf("  %s\n", strings.Join(m.Steps, "\n  "))
+			if m.Backup != "" {
+				fmt.Printf("  backup: %s\n", m.Backup)
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alechenninger/orchard/internal/application"
+	"github.com/alechenninger/orchard/internal/domain"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagPauseSelector  string
+	flagResumeSelector string
+)
+
+func init() {
+	rootCmd.AddCommand(pauseCmd, resumeCmd)
+	pauseCmd.Flags().StringVarP(&flagPauseSelector, "selector", "l", "", "pause every VM matching this label selector")
+	resumeCmd.Flags().StringVarP(&flagResumeSelector, "selector", "l", "", "resume every VM matching this label selector")
+}
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause NAME",
+	Short: "Pause a running VM",
+	Long: `Pause a running VM. Its vCPUs stop but its memory stays allocated, so resume
+continues it where it left off. Providers that cannot pause refuse.`,
+	Args: nameOrSelector(&flagPauseSelector),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setPaused(cmd.Context(), args, flagPauseSelector, "paused", domain.ErrPaused, (*application.App).Pause)
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume NAME",
+	Short: "Resume a paused VM",
+	Args:  nameOrSelector(&flagResumeSelector),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setPaused(cmd.Context(), args, flagResumeSelector, "resumed", domain.ErrNotPaused, (*application.App).Resume)
+	},
+}
+
+// setPaused applies op to the named or selected VMs. VMs a selector matches that
+// are not running, or are already as asked (skip), are skipped.
+func setPaused(ctx context.Context, args []string, selector, done string, skip error,
+	op func(*application.App, context.Context, string) (domain.VMStatus, error)) error {
+	app, err := newApp()
+	if err != nil {
+		return err
+	}
+	names, err := targets(ctx, app, args, selector)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		_, err := op(app, ctx, name)
+		if selector != "" && (errors.Is(err, domain.ErrNotRunning) || errors.Is(err, skip)) {
+			fmt.Fprintf(os.Stderr, "%s: %v; skipping\n", name, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			fmt.Printf("{\"name\":%q,\"%s\":true}\n", name, done)
+			continue
+		}
+		fmt.Printf("%s %s\n", strings.ToUpper(done[:1])+done[1:], name)
+	}
+	return nil
+}
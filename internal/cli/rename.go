@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/alechenninger/orchard/internal/application"
+	"github.com/alechenninger/orchard/internal/knownhosts"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagRenameHostname bool
+	flagRenameSSHKey   string
+)
+
+func init() {
+	rootCmd.AddCommand(renameCmd)
+	renameCmd.Flags().BoolVar(&flagRenameHostname, "hostname", false, "also change the guest hostname (applied on next boot) and update ~/.ssh/known_hosts")
+	renameCmd.Flags().StringVar(&flagRenameSSHKey, "ssh-key", "", "public key for the regenerated seed (default ~/.ssh/id_ed25519.pub or id_rsa.pub)")
+}
+
+var renameCmd = &cobra.Command{
+	Use:   "rename OLD NEW",
+	Short: "Rename a stopped VM",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		res, err := app.Rename(ctx, args[0], args[1], application.RenameParams{
+			UpdateHostname: flagRenameHostname,
+			SSHKeyPath:     flagRenameSSHKey,
+			KnownHostsPath: knownhosts.DefaultPath(),
+		})
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			fmt.Printf("{\"name\":\"%s\",\"oldName\":\"%s\",\"hostname\":\"%s\",\"knownHostsUpdated\":%d}\n", res.VM.Name, res.OldName, res.VM.Hostname, res.KnownHostsUpdated)
+			return nil
+		}
+		fmt.Printf("Renamed %s to %s\n", res.OldName, res.VM.Name)
+		if flagRenameHostname {
+			fmt.Printf("Hostname will be %s.local after the next start\n", res.VM.Hostname)
+			if res.KnownHostsUpdated > 0 {
+				fmt.Printf("Updated %d known_hosts entries\n", res.KnownHostsUpdated)
+			}
+		}
+		return nil
+	},
+}
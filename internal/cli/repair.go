@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() { rootCmd.AddCommand(repairCmd) }
+
+var repairCmd = &cobra.Command{
+	Use:   "repair NAME",
+	Short: "Rebuild the record of a VM shown as BROKEN from its disk, nvram and seed",
+	Long: `Rebuild the record of a VM shown as BROKEN by list.
+
+Fields that can still be read from the damaged record are kept; artifact paths
+come from the VM directory and anything else falls back to the up defaults.
+The damaged record is kept as a backup.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		res, err := app.Repair(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			b, _ := json.Marshal(struct {
+				Name      string   `json:"name"`
+				ID        string   `json:"id"`
+				Recovered []string `json:"recovered"`
+			}{res.VM.Name, res.VM.ID, res.Recovered})
+			fmt.Println(string(b))
+			return nil
+		}
+		fmt.Printf("Repaired %s (id %s)\n", res.VM.Name, res.VM.ID)
+		if len(res.Recovered) > 0 {
+			fmt.Printf("  recovered: %s\n", strings.Join(res.Recovered, ", "))
+		} else {
+			fmt.Println("  nothing could be recovered from the old record; check CPUs and memory")
+		}
+		fmt.Printf("  cpus=%d memory=%dMiB disk=%dGiB\n", res.VM.CPUs, res.VM.MemoryMiB, res.VM.DiskSizeGiB)
+		return nil
+	},
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/spf13/cobra"
+)
+
+var flagPolicyMaxRestarts int
+
+func init() {
+	rootCmd.AddCommand(restartPolicyCmd)
+	restartPolicyCmd.Flags().IntVar(&flagPolicyMaxRestarts, "max-restarts", 0, "consecutive restarts before giving up (0: no limit)")
+}
+
+var restartPolicyCmd = &cobra.Command{
+	Use:   "restart-policy NAME no|on-failure|always",
+	Short: "Set whether a VM is started again after it stops by itself",
+	Long: `Set a VM's restart policy. With on-failure the VM is restarted after it or
+its shim fails; with always also after its guest shuts down. A VM stopped with
+orchard stop is never restarted. Restarts back off exponentially, from one second
+up to five minutes, and --max-restarts bounds how many happen in a row.
+
+A running VM keeps the policy it was started with until it is started again.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		mode, err := domain.ParseRestartMode(args[1])
+		if err != nil {
+			return err
+		}
+		vm, err := app.SetRestartPolicy(ctx, args[0], domain.RestartPolicy{Mode: mode, MaxRetries: flagPolicyMaxRestarts})
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			fmt.Printf("{\"name\":%q,\"restartPolicy\":{\"mode\":%q,\"maxRetries\":%d}}\n", vm.Name, vm.RestartPolicy.Mode, vm.RestartPolicy.MaxRetries)
+			return nil
+		}
+		fmt.Printf("Restart policy of %s: %s\n", vm.Name, vm.RestartPolicy)
+		return nil
+	},
+}
@@ -2,10 +2,14 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 
+	"github.com/alechenninger/orchard/internal/application"
+	"github.com/alechenninger/orchard/internal/config"
+	"github.com/alechenninger/orchard/internal/shim/proc"
 	"github.com/spf13/cobra"
 )
 
@@ -25,17 +29,34 @@ var (
 
 	flagJSON    bool
 	flagVerbose bool
+	flagHome    string
 )
 
 func init() {
 	rootCmd.PersistentFlags().BoolVar(&flagJSON, "json", false, "enable JSON log output")
 	rootCmd.PersistentFlags().BoolVarP(&flagVerbose, "verbose", "v", false, "enable verbose (debug) logging")
+	rootCmd.PersistentFlags().StringVar(&flagHome, "home", "", "orchard home directory (default $ORCHARD_HOME or ~/.orchard)")
+}
+
+// newApp builds the application for the home selected by --home, the environment
+// or the config file.
+func newApp() (*application.App, error) {
+	cfg, err := config.Resolve(flagHome)
+	if err != nil {
+		return nil, err
+	}
+	return application.NewFromConfig(cfg)
 }
 
 func Execute(version string) {
 	rootCmd.Version = version
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
+		// A shim's exit status tells how its VM ended.
+		var exit *proc.ExitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.Code)
+		}
 		os.Exit(1)
 	}
 }
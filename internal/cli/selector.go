@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alechenninger/orchard/internal/application"
+	"github.com/alechenninger/orchard/internal/selector"
+	"github.com/spf13/cobra"
+)
+
+// nameOrSelector accepts exactly one NAME, or none when a -l selector is given.
+func nameOrSelector(flag *string) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if *flag != "" {
+			if len(args) > 0 {
+				return fmt.Errorf("specify either NAME or --selector, not both")
+			}
+			return nil
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	}
+}
+
+// targets resolves the VMs a command operates on: the NAME argument, or every VM
+// matching the selector expression.
+func targets(ctx context.Context, app *application.App, args []string, expr string) ([]string, error) {
+	if expr == "" {
+		return args, nil
+	}
+	sel, err := selector.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	vms, err := app.SelectVMs(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		names = append(names, vm.Name)
+	}
+	return names, nil
+}
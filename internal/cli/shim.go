@@ -2,14 +2,15 @@ package cli
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
+	"os"
 	"time"
 
+	"github.com/alechenninger/orchard/internal/guestprobe"
 	vfprov "github.com/alechenninger/orchard/internal/provider/vz"
-	runfs "github.com/alechenninger/orchard/internal/runstate/fs"
 	"github.com/alechenninger/orchard/internal/shim/proc"
-	fsstore "github.com/alechenninger/orchard/internal/vmstore/fs"
+	"github.com/alechenninger/orchard/internal/shimlog"
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 )
 
@@ -29,18 +30,33 @@ var shimCmd = &cobra.Command{
 	Short: "internal: per-VM shim entrypoint",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
-		slog.Info("shim starting", "vm", flagShimVM)
 		cctx, cancel := context.WithCancel(ctx)
 		defer cancel()
-		store := fsstore.NewDefault()
-		run := runfs.NewDefault()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		vm, err := app.Store.Load(ctx, flagShimVM)
+		if err != nil {
+			return err
+		}
+		logw, err := shimlog.Open(afero.NewOsFs(), shimlog.Path(*vm))
+		if err != nil {
+			return err
+		}
+		defer logw.Close()
+		if err := logw.CaptureStdio(); err != nil {
+			slog.Warn("shim output outside the log will be lost", "vm", flagShimVM, "error", err)
+		}
+		slog.SetDefault(slog.New(slog.NewJSONHandler(logw, &slog.HandlerOptions{Level: chooseLevel(flagVerbose)})))
+		slog.Info("shim starting", "vm", flagShimVM, "pid", os.Getpid())
 		provider := vfprov.New()
-		if err := proc.RunChild(cctx, store, run, provider, flagShimVM); err != nil {
+		if err := proc.RunChild(cctx, app.Store, app.Run, provider, guestprobe.New(), app.Journal, app.Clock, flagShimVM); err != nil {
 			return err
 		}
 		// Should not reach here until signaled; just in case
 		time.Sleep(10 * time.Millisecond)
-		fmt.Println("shim exiting for", flagShimVM)
+		slog.Info("shim exiting", "vm", flagShimVM)
 		return nil
 	},
 }
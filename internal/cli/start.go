@@ -1,32 +1,72 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/alechenninger/orchard/internal/application"
+	"github.com/alechenninger/orchard/internal/domain"
 	"github.com/spf13/cobra"
 )
 
+var (
+	flagStartSelector string
+	flagStartWaitFor  string
+	flagStartTimeout  time.Duration
+)
+
 func init() {
 	rootCmd.AddCommand(startCmd)
+	startCmd.Flags().StringVarP(&flagStartSelector, "selector", "l", "", "start every VM matching this label selector")
+	startCmd.Flags().StringVar(&flagStartWaitFor, "wait-for", string(domain.StageVMStarted), "readiness stage to wait for: "+stageNames())
+	startCmd.Flags().DurationVar(&flagStartTimeout, "timeout", 0, "how long to wait for the stage (default 15s for host stages, 5m for guest stages)")
+}
+
+func stageNames() string {
+	names := make([]string, len(domain.Stages))
+	for i, st := range domain.Stages {
+		names[i] = string(st)
+	}
+	return strings.Join(names, ", ")
 }
 
 var startCmd = &cobra.Command{
 	Use:   "start NAME",
 	Short: "Start a VM",
-	Args:  cobra.ExactArgs(1),
+	Args:  nameOrSelector(&flagStartSelector),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
-		app := application.NewDefault()
-		vm, err := app.Start(ctx, args[0])
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		stage, err := domain.ParseStage(flagStartWaitFor)
+		if err != nil {
+			return err
+		}
+		names, err := targets(ctx, app, args, flagStartSelector)
 		if err != nil {
 			return err
 		}
-		if flagJSON {
-			fmt.Printf("{\"name\":\"%s\",\"pid\":%d}\n", vm.Name, vm.PID)
-			return nil
+		for _, name := range names {
+			vm, st, err := app.Start(ctx, name, application.StartParams{WaitFor: stage, Timeout: flagStartTimeout})
+			// A selector may match VMs that are already running; skip them.
+			if flagStartSelector != "" && errors.Is(err, domain.ErrAlreadyRunning) {
+				fmt.Fprintf(os.Stderr, "%s is already running; skipping\n", name)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if flagJSON {
+				fmt.Printf("{\"name\":\"%s\",\"pid\":%d,\"stage\":\"%s\"}\n", vm.Name, st.PID, st.Stage)
+				continue
+			}
+			fmt.Printf("Started %s: %s\n", vm.Name, describeStatus(st))
 		}
-		fmt.Printf("Started %s (pid %d)\n", vm.Name, vm.PID)
 		return nil
 	},
 }
@@ -1,9 +1,11 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 
-	"github.com/alechenninger/orchard/internal/application"
+	"github.com/alechenninger/orchard/internal/domain"
+
 	"github.com/spf13/cobra"
 )
 
@@ -15,20 +17,50 @@ var statusCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
-		app := application.NewDefault()
-		running, pid, err := app.Status(ctx, args[0])
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		vm, st, err := app.Status(ctx, args[0])
 		if err != nil {
 			return err
 		}
 		if flagJSON {
-			fmt.Printf("{\"name\":\"%s\",\"running\":%v,\"pid\":%d}\n", args[0], running, pid)
+			b, _ := json.Marshal(struct {
+				Name string `json:"name"`
+				domain.VMStatus
+				Running bool `json:"running"`
+			}{vm.Name, st, st.State == domain.StateRunning})
+			fmt.Println(string(b))
 			return nil
 		}
-		if running {
-			fmt.Printf("%s: running (pid %d)\n", args[0], pid)
-		} else {
-			fmt.Printf("%s: stopped\n", args[0])
-		}
+		fmt.Printf("%s: %s\n", vm.Name, describeStatus(st))
 		return nil
 	},
 }
+
+// describeStatus renders an observed status as e.g. "running (pid 42, ssh-reachable)",
+// "crashed: vm stopped with an error (shim exit status 3)" or, for a VM its restart
+// policy brought back, "running (pid 42); restarts: 2, last exit: guest shut down".
+func describeStatus(st domain.VMStatus) string {
+	s := string(st.State)
+	switch {
+	case st.PID != 0 && st.Stage != "":
+		s += fmt.Sprintf(" (pid %d, %s)", st.PID, st.Stage)
+	case st.PID != 0:
+		s += fmt.Sprintf(" (pid %d)", st.PID)
+	}
+	if st.Reason != "" {
+		s += ": " + st.Reason
+	}
+	if st.LastExit != nil && st.LastExit.Code != 0 && !st.Active() {
+		s += fmt.Sprintf(" (shim exit status %d)", st.LastExit.Code)
+	}
+	if st.Restarts > 0 {
+		s += fmt.Sprintf("; restarts: %d", st.Restarts)
+		if st.Reason == "" && st.LastExit != nil {
+			s += ", last exit: " + st.LastExit.Reason
+		}
+	}
+	return s
+}
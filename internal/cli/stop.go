@@ -1,31 +1,62 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/alechenninger/orchard/internal/application"
+	"github.com/alechenninger/orchard/internal/domain"
+
 	"github.com/spf13/cobra"
 )
 
+var (
+	flagStopSelector string
+	flagStopForce    bool
+	flagStopTimeout  time.Duration
+)
+
 func init() {
 	rootCmd.AddCommand(stopCmd)
+	stopCmd.Flags().StringVarP(&flagStopSelector, "selector", "l", "", "stop every VM matching this label selector")
+	stopCmd.Flags().BoolVarP(&flagStopForce, "force", "f", false, "power the VM off without asking the guest to shut down")
+	stopCmd.Flags().DurationVar(&flagStopTimeout, "timeout", domain.DefaultStopTimeout, "how long the guest gets to shut down before it is powered off")
 }
 
 var stopCmd = &cobra.Command{
 	Use:   "stop NAME",
 	Short: "Stop a VM",
-	Args:  cobra.ExactArgs(1),
+	Long: `Stop a VM by asking the guest to shut down, powering it off if it has not
+done so within --timeout. Stop returns once the VM's shim has exited.`,
+	Args: nameOrSelector(&flagStopSelector),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
-		app := application.NewDefault()
-		if err := app.Stop(ctx, args[0]); err != nil {
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		names, err := targets(ctx, app, args, flagStopSelector)
+		if err != nil {
 			return err
 		}
-		if flagJSON {
-			fmt.Printf("{\"name\":\"%s\",\"stopped\":true}\n", args[0])
-			return nil
+		for _, name := range names {
+			_, err := app.Stop(ctx, name, application.StopParams{Force: flagStopForce, Timeout: flagStopTimeout})
+			// A selector may match VMs that are already stopped; skip them.
+			if flagStopSelector != "" && errors.Is(err, domain.ErrNotRunning) {
+				fmt.Fprintf(os.Stderr, "%s is not running; skipping\n", name)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if flagJSON {
+				fmt.Printf("{\"name\":\"%s\",\"stopped\":true}\n", name)
+				continue
+			}
+			fmt.Printf("Stopped %s\n", name)
 		}
-		fmt.Printf("Stopped %s\n", args[0])
 		return nil
 	},
 }
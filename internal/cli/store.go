@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	fsstore "github.com/alechenninger/orchard/internal/vmstore/fs"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(storeCmd)
+	storeCmd.AddCommand(storeImportCmd)
+}
+
+var storeCmd = &cobra.Command{
+	Use:   "store",
+	Short: "Manage the VM record store",
+}
+
+var storeImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Copy VM records from per-VM config.json files into the configured store",
+	Long: `Copy VM records from per-VM config.json files into the configured store.
+
+Set "store": "bolt" in <home>/config.json first. Records already in the
+store are skipped, so the import can be re-run safely; the JSON files are left
+in place.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		names, err := app.ImportStore(ctx, fsstore.New(app.Config.Home))
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			b, _ := json.Marshal(struct {
+				Imported []string `json:"imported"`
+			}{names})
+			fmt.Println(string(b))
+			return nil
+		}
+		for _, n := range names {
+			fmt.Printf("Imported %s\n", n)
+		}
+		fmt.Printf("%d records imported\n", len(names))
+		return nil
+	},
+}
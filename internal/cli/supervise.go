@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/alechenninger/orchard/internal/shim/proc"
+	"github.com/alechenninger/orchard/internal/shimlog"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagSuperviseVM string
+)
+
+func init() {
+	rootCmd.AddCommand(superviseCmd)
+	superviseCmd.Hidden = true
+	superviseCmd.Flags().StringVar(&flagSuperviseVM, "vm", "", "VM name to supervise")
+	_ = superviseCmd.MarkFlagRequired("vm")
+}
+
+var superviseCmd = &cobra.Command{
+	Use:   "_supervise",
+	Short: "internal: runs a VM's shim under its restart policy",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// SIGTERM, as sent by stop while the VM waits to restart, ends supervision
+		// and is passed on to a running shim.
+		ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGTERM, syscall.SIGINT)
+		defer cancel()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		vm, err := app.Store.Load(ctx, flagSuperviseVM)
+		if err != nil {
+			return err
+		}
+		// The supervisor shares the shim's log, without taking over its stdio:
+		// the shim does that once it is up.
+		logw, err := shimlog.Open(afero.NewOsFs(), shimlog.Path(*vm))
+		if err != nil {
+			return err
+		}
+		defer logw.Close()
+		slog.SetDefault(slog.New(slog.NewJSONHandler(logw, &slog.HandlerOptions{Level: chooseLevel(flagVerbose)})).With("component", "supervisor"))
+		slog.Info("supervisor starting", "vm", vm.Name, "pid", os.Getpid(), "policy", vm.RestartPolicy.String())
+		shims := proc.New(app.Store, app.Run)
+		shims.Env = app.Config.Environ()
+		s := &proc.Supervisor{Run: app.Run, Events: app.Journal, Clock: app.Clock, Launch: shims.LaunchShim}
+		if err := s.Supervise(ctx, *vm); err != nil {
+			return err
+		}
+		slog.Info("supervisor exiting", "vm", vm.Name)
+		return nil
+	},
+}
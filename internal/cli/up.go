@@ -4,26 +4,36 @@ import (
 	"fmt"
 
 	"github.com/alechenninger/orchard/internal/application"
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/selector"
 	"github.com/spf13/cobra"
 )
 
 var (
+	flagUpName        string
 	flagImagePath     string
 	flagCPUs          int
 	flagMemoryMiB     int
 	flagDiskSizeGiB   int
 	flagSSHKeyPath    string
 	flagEnableRosetta bool
+	flagUpLabels      []string
+	flagRestart       string
+	flagMaxRestarts   int
 )
 
 func init() {
 	rootCmd.AddCommand(upCmd)
+	upCmd.Flags().StringVar(&flagUpName, "name", "", "VM name; must be a valid hostname label (default: generated vm-NNN)")
 	upCmd.Flags().StringVar(&flagImagePath, "image", "", "path to base Fedora image (required)")
 	upCmd.Flags().IntVar(&flagCPUs, "cpus", 2, "number of vCPUs")
 	upCmd.Flags().IntVar(&flagMemoryMiB, "memory", 2048, "memory in MiB")
 	upCmd.Flags().IntVar(&flagDiskSizeGiB, "disk-size", 20, "disk size in GiB")
 	upCmd.Flags().StringVar(&flagSSHKeyPath, "ssh-key", "", "path to SSH public key (optional)")
 	upCmd.Flags().BoolVar(&flagEnableRosetta, "rosetta", false, "enable Rosetta for x86 binary translation (requires macOS Ventura+)")
+	upCmd.Flags().StringArrayVar(&flagUpLabels, "label", nil, "label in KEY=VALUE form (repeatable)")
+	upCmd.Flags().StringVar(&flagRestart, "restart", "no", "restart policy: no, on-failure or always")
+	upCmd.Flags().IntVar(&flagMaxRestarts, "max-restarts", 0, "consecutive restarts before giving up (0: no limit)")
 	_ = upCmd.MarkFlagRequired("image")
 }
 
@@ -32,14 +42,28 @@ var upCmd = &cobra.Command{
 	Short: "Create a VM record and resources (no start)",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
-		app := application.NewDefault()
+		app, err := newApp()
+		if err != nil {
+			return err
+		}
+		labels, err := selector.ParseLabels(flagUpLabels)
+		if err != nil {
+			return err
+		}
+		mode, err := domain.ParseRestartMode(flagRestart)
+		if err != nil {
+			return err
+		}
 		vm, err := app.Up(ctx, application.UpParams{
+			Name:          flagUpName,
 			ImagePath:     flagImagePath,
 			CPUs:          flagCPUs,
 			MemoryMiB:     flagMemoryMiB,
 			DiskSizeGiB:   flagDiskSizeGiB,
 			SSHKeyPath:    flagSSHKeyPath,
 			EnableRosetta: flagEnableRosetta,
+			Labels:        labels,
+			RestartPolicy: domain.RestartPolicy{Mode: mode, MaxRetries: flagMaxRestarts},
 		})
 		if err != nil {
 			return err
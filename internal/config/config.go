@@ -0,0 +1,144 @@
+// Package config resolves where orchard keeps its data and which backends it uses.
+//
+// The orchard home holds durable data: VM disks, records and event journals. It is
+// chosen, in order of precedence, by the --home flag, ORCHARD_HOME, the "home"
+// setting in ~/.orchard/config.json, or ~/.orchard. Settings for a home are read
+// from <home>/config.json:
+//
+//	{
+//	  "store": "json" | "bolt",
+//	  "runtimeDir": "/path/for/pid/ready/lock/socket/files"
+//	}
+//
+// ORCHARD_STORE and ORCHARD_RUNTIME_DIR override the corresponding settings.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is the settings file name under the orchard home directory.
+const FileName = "config.json"
+
+// Store backends.
+const (
+	StoreJSON = "json" // one config.json per VM directory
+	StoreBolt = "bolt" // embedded database at state/orchard.db
+)
+
+// Environment variables read by Resolve and set by Environ.
+const (
+	EnvHome       = "ORCHARD_HOME"
+	EnvRuntimeDir = "ORCHARD_RUNTIME_DIR"
+	EnvStore      = "ORCHARD_STORE"
+)
+
+// Config holds the resolved settings.
+type Config struct {
+	// Home holds durable data (disks, records, journals).
+	Home string `json:"home,omitempty"`
+	// RuntimeDir holds ephemeral per-VM runtime files. It defaults to Home.
+	RuntimeDir string `json:"runtimeDir,omitempty"`
+	// Store selects the VM store backend.
+	Store string `json:"store,omitempty"`
+}
+
+// DefaultHome returns ~/.orchard, or a directory under the temp dir if the user
+// has no home directory.
+func DefaultHome() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "orchard")
+	}
+	return filepath.Join(home, ".orchard")
+}
+
+// Resolve determines the settings in effect. home is the value of the --home flag
+// and may be empty.
+func Resolve(home string) (Config, error) {
+	def, err := read(DefaultHome())
+	if err != nil {
+		return Config{}, err
+	}
+	switch {
+	case home != "":
+	case os.Getenv(EnvHome) != "":
+		home = os.Getenv(EnvHome)
+	case def.Home != "":
+		home = def.Home
+	default:
+		home = DefaultHome()
+	}
+	if home, err = absPath(home); err != nil {
+		return Config{}, err
+	}
+
+	c := def
+	if home != DefaultHome() {
+		if c, err = read(home); err != nil {
+			return Config{}, err
+		}
+	}
+	c.Home = home
+	if v := os.Getenv(EnvRuntimeDir); v != "" {
+		c.RuntimeDir = v
+	}
+	if c.RuntimeDir == "" {
+		c.RuntimeDir = c.Home
+	}
+	if c.RuntimeDir, err = absPath(c.RuntimeDir); err != nil {
+		return Config{}, err
+	}
+	if v := os.Getenv(EnvStore); v != "" {
+		c.Store = v
+	}
+	if c.Store == "" {
+		c.Store = StoreJSON
+	}
+	switch c.Store {
+	case StoreJSON, StoreBolt:
+	default:
+		return Config{}, fmt.Errorf("unknown store backend %q (want %q or %q)", c.Store, StoreJSON, StoreBolt)
+	}
+	return c, nil
+}
+
+// Environ returns the environment that makes a child orchard process (such as a
+// shim) resolve the same settings.
+func (c Config) Environ() []string {
+	return []string{EnvHome + "=" + c.Home, EnvRuntimeDir + "=" + c.RuntimeDir, EnvStore + "=" + c.Store}
+}
+
+// read loads <home>/config.json. A missing file yields empty settings.
+func read(home string) (Config, error) {
+	var c Config
+	p := filepath.Join(home, FileName)
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %w", p, err)
+	}
+	return c, nil
+}
+
+// absPath expands a leading ~/ and makes p absolute.
+func absPath(p string) (string, error) {
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		p = filepath.Join(home, rest)
+	}
+	return filepath.Abs(p)
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, dir, body string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolvePrecedence(t *testing.T) {
+	user := t.TempDir()
+	t.Setenv("HOME", user)
+	t.Setenv(EnvHome, "")
+	t.Setenv(EnvRuntimeDir, "")
+	t.Setenv(EnvStore, "")
+	ssd := filepath.Join(t.TempDir(), "ssd")
+	writeConfig(t, filepath.Join(user, ".orchard"), `{"home": "`+ssd+`", "store": "bolt"}`)
+	writeConfig(t, ssd, `{"runtimeDir": "/tmp/orchard-run"}`)
+
+	// The default config redirects the home; the redirected home's config applies.
+	c, err := Resolve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Home != ssd || c.RuntimeDir != "/tmp/orchard-run" || c.Store != StoreJSON {
+		t.Fatalf("config redirect: %+v", c)
+	}
+
+	// The environment beats the config file.
+	env := t.TempDir()
+	t.Setenv(EnvHome, env)
+	if c, _ = Resolve(""); c.Home != env || c.RuntimeDir != env {
+		t.Fatalf("ORCHARD_HOME: %+v", c)
+	}
+
+	// The flag beats the environment.
+	flag := t.TempDir()
+	t.Setenv(EnvStore, StoreBolt)
+	if c, _ = Resolve(flag); c.Home != flag || c.Store != StoreBolt {
+		t.Fatalf("--home: %+v", c)
+	}
+
+	t.Setenv(EnvStore, "sqlite")
+	if _, err := Resolve(flag); err == nil {
+		t.Fatal("expected unknown store backend to be rejected")
+	}
+}
+
+func TestEnvironRoundTrips(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	want := Config{Home: t.TempDir(), RuntimeDir: t.TempDir(), Store: StoreBolt}
+	for _, kv := range want.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		t.Setenv(k, v)
+	}
+	got, err := Resolve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
@@ -19,6 +19,10 @@ type CIDATABuilder interface {
 type CloudInit struct {
 	fs      afero.Fs
 	builder CIDATABuilder
+	// KeepHostKeys tells cloud-init not to regenerate SSH host keys when it sees a
+	// new instance-id, so a reseeded VM keeps its identity in known_hosts. It must
+	// stay off for new VMs, whose disks carry the shared base image's keys.
+	KeepHostKeys bool
 }
 
 func NewCloudInit() *CloudInit { return &CloudInit{fs: afero.NewOsFs(), builder: hdiutil.Builder{}} }
@@ -37,7 +41,7 @@ func (c *CloudInit) Generate(ctx context.Context, vm VM, sshAuthorizedKey string
 	}
 	defer af.RemoveAll(workDir)
 
-	userData := buildUserData(vm.Hostname, sshAuthorizedKey)
+	userData := buildUserData(vm.Hostname, sshAuthorizedKey, c.KeepHostKeys)
 	metaData := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", vm.Name, vm.Hostname)
 
 	if err := af.WriteFile(filepath.Join(workDir, "user-data"), []byte(userData), 0o644); err != nil {
@@ -57,12 +61,15 @@ func (c *CloudInit) Generate(ctx context.Context, vm VM, sshAuthorizedKey string
 	return nil
 }
 
-func buildUserData(hostname, sshKey string) string {
+func buildUserData(hostname, sshKey string, keepHostKeys bool) string {
 	b := &strings.Builder{}
 	b.WriteString("#cloud-config\n")
 	b.WriteString("preserve_hostname: false\n")
 	b.WriteString(fmt.Sprintf("hostname: %s\n", hostname))
 	b.WriteString("ssh_pwauth: false\n")
+	if keepHostKeys {
+		b.WriteString("ssh_deletekeys: false\n")
+	}
 	b.WriteString("users:\n")
 	b.WriteString("  - name: fedora\n")
 	b.WriteString("    sudo: ALL=(ALL) NOPASSWD:ALL\n")
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// EventType names a VM lifecycle event.
+type EventType string
+
+const (
+	EventCreated       EventType = "created"
+	EventStarting      EventType = "starting"
+	EventReady         EventType = "ready"
+	EventPaused        EventType = "paused"
+	EventResumed       EventType = "resumed"
+	EventStopping      EventType = "stopping"
+	EventStopped       EventType = "stopped"
+	EventCrashed       EventType = "crashed"
+	EventRestarting    EventType = "restarting"
+	EventDeleted       EventType = "deleted"
+	EventConfigChanged EventType = "config-changed"
+)
+
+// Actors that record events.
+const (
+	ActorCLI        = "cli"
+	ActorShim       = "shim"
+	ActorSupervisor = "supervisor"
+)
+
+// Event is one entry in a VM's lifecycle journal.
+type Event struct {
+	Time    time.Time `json:"time"`
+	VM      string    `json:"vm"`
+	VMID    string    `json:"vmId,omitempty"`
+	Type    EventType `json:"type"`
+	PID     int       `json:"pid,omitempty"`
+	Actor   string    `json:"actor"`
+	Message string    `json:"message,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// EventJournal is an append-only per-VM event history. Journals outlive the VM
+// records they describe, so a deleted VM's history can still be read.
+type EventJournal interface {
+	// Append records e. A zero Time is stamped by the journal's clock.
+	Append(ctx context.Context, e Event) error
+	// Read returns the events for vmName, or for every VM if vmName is empty, at
+	// or after since, oldest first.
+	Read(ctx context.Context, vmName string, since time.Time) ([]Event, error)
+	// Follow streams the events Read would return, then those appended later,
+	// until ctx is done. No event is streamed twice or missed in between.
+	Follow(ctx context.Context, vmName string, since time.Time) (<-chan Event, error)
+}
@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// ErrVMNotFound is returned when no VM matches a name or ID.
+var ErrVMNotFound = errors.New("vm not found")
+
+// NewID returns a new VM ID. IDs are ULIDs: unique, immutable and lexically sortable
+// by creation time.
+func NewID(t time.Time) string {
+	return ulid.MustNew(ulid.Timestamp(t), rand.Reader).String()
+}
+
+// AmbiguousIDError is returned when an ID prefix matches more than one VM.
+type AmbiguousIDError struct {
+	Prefix     string
+	Candidates []string // "ID (name)" for each match
+}
+
+func (e *AmbiguousIDError) Error() string {
+	return fmt.Sprintf("id prefix %q is ambiguous; matches: %s", e.Prefix, strings.Join(e.Candidates, ", "))
+}
+
+// MatchID finds the VM whose ID equals or starts with prefix (case-insensitive).
+// An empty prefix matches nothing, rather than every VM.
+func MatchID(vms []VM, prefix string) (*VM, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("%w: empty name or id", ErrVMNotFound)
+	}
+	p := strings.ToUpper(prefix)
+	var matches []VM
+	for _, vm := range vms {
+		if vm.ID == "" {
+			continue
+		}
+		if vm.ID == p {
+			return &vm, nil
+		}
+		if strings.HasPrefix(vm.ID, p) {
+			matches = append(matches, vm)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrVMNotFound, prefix)
+	case 1:
+		return &matches[0], nil
+	}
+	e := &AmbiguousIDError{Prefix: prefix}
+	for _, vm := range matches {
+		e.Candidates = append(e.Candidates, fmt.Sprintf("%s (%s)", vm.ID, vm.Name))
+	}
+	return nil, e
+}
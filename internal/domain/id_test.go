@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewIDSortsByTime(t *testing.T) {
+	t.Parallel()
+	a := NewID(time.Unix(1000, 0))
+	b := NewID(time.Unix(2000, 0))
+	if len(a) != 26 || a >= b {
+		t.Fatalf("expected sortable 26-char IDs, got %s then %s", a, b)
+	}
+}
+
+func TestMatchID(t *testing.T) {
+	t.Parallel()
+	vms := []VM{
+		{ID: "01HZX0AAAAAAAAAAAAAAAAAAAA", Name: "one"},
+		{ID: "01HZX0BBBBBBBBBBBBBBBBBBBB", Name: "two"},
+		{ID: "01J000CCCCCCCCCCCCCCCCCCCC", Name: "three"},
+		{Name: "legacy"},
+	}
+	if vm, err := MatchID(vms, "01HZX0BBBBBBBBBBBBBBBBBBBB"); err != nil || vm.Name != "two" {
+		t.Fatalf("full id: got %v, %v", vm, err)
+	}
+	if vm, err := MatchID(vms, "01j"); err != nil || vm.Name != "three" {
+		t.Fatalf("lowercase prefix: got %v, %v", vm, err)
+	}
+	var amb *AmbiguousIDError
+	if _, err := MatchID(vms, "01HZX0"); !errors.As(err, &amb) || len(amb.Candidates) != 2 {
+		t.Fatalf("expected ambiguity with 2 candidates, got %v", err)
+	}
+	if _, err := MatchID(vms, "ZZ"); !errors.Is(err, ErrVMNotFound) {
+		t.Fatalf("expected ErrVMNotFound, got %v", err)
+	}
+	if vm, err := MatchID(vms[:1], ""); !errors.Is(err, ErrVMNotFound) {
+		t.Fatalf("expected an empty prefix to match nothing, got %v, %v", vm, err)
+	}
+}
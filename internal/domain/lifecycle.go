@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// VMState is a state in the VM lifecycle. Stopped, starting, running, paused and
+// crashed are observed from the runtime state; the others are only ever held for
+// the duration of an operation.
+type VMState string
+
+// VM lifecycle states.
+const (
+	StateCreating VMState = "creating" // the record and artifacts are being prepared
+	StateStopped  VMState = "stopped"  // no shim is running
+	StateStarting VMState = "starting" // the shim is up but has not marked the VM ready
+	StateRunning  VMState = "running"  // the shim is up and the VM is ready
+	StatePaused   VMState = "paused"   // the shim is up and the VM is paused
+	StateStopping VMState = "stopping" // the shim has been asked to shut the VM down
+	StateCrashed  VMState = "crashed"  // the shim died without clearing its runtime files
+	StateDeleting VMState = "deleting" // the record and artifacts are being removed
+)
+
+// States lists every lifecycle state.
+var States = []VMState{StateCreating, StateStopped, StateStarting, StateRunning, StatePaused, StateStopping, StateCrashed, StateDeleting}
+
+// transitions is the table of legal lifecycle transitions.
+var transitions = map[VMState][]VMState{
+	StateCreating: {StateStopped, StateDeleting},
+	StateStopped:  {StateStarting, StateDeleting},
+	StateStarting: {StateRunning, StateStopping, StateCrashed},
+	StateRunning:  {StatePaused, StateStopping, StateCrashed},
+	StatePaused:   {StateRunning, StateStopping, StateCrashed},
+	StateStopping: {StateStopped, StateCrashed},
+	StateCrashed:  {StateStarting, StateStopped, StateDeleting},
+	StateDeleting: nil,
+}
+
+// offline lists the states in which no shim holds a VM, so its artifacts may be
+// changed or read by rename, export and repair.
+var offline = []VMState{StateStopped, StateCrashed}
+
+var (
+	// ErrAlreadyRunning is returned when starting a VM whose shim is already up.
+	ErrAlreadyRunning = errors.New("vm is already running")
+	// ErrNotRunning is returned when stopping a VM that has no live shim.
+	ErrNotRunning = errors.New("vm is not running")
+	// ErrRunning is returned by operations that require the VM to be stopped.
+	ErrRunning = errors.New("vm is running")
+	// ErrPaused is returned when pausing a VM that is already paused.
+	ErrPaused = errors.New("vm is paused")
+	// ErrNotPaused is returned when resuming a VM that is not paused.
+	ErrNotPaused = errors.New("vm is not paused")
+	// ErrInvalidTransition is returned for any other illegal transition, such as
+	// starting a VM that is still stopping.
+	ErrInvalidTransition = errors.New("invalid vm state transition")
+)
+
+// TransitionError reports an illegal lifecycle transition. It matches one of
+// ErrAlreadyRunning, ErrNotRunning, ErrRunning, ErrPaused, ErrNotPaused or
+// ErrInvalidTransition with errors.Is.
+type TransitionError struct {
+	VM       string
+	From, To VMState
+	Err      error
+}
+
+func (e *TransitionError) Error() string {
+	switch e.Err {
+	case ErrAlreadyRunning:
+		return fmt.Sprintf("vm %s is already %s", e.VM, e.From)
+	case ErrNotRunning:
+		return fmt.Sprintf("vm %s is not running (%s)", e.VM, e.From)
+	case ErrRunning:
+		return fmt.Sprintf("vm %s is %s", e.VM, e.From)
+	case ErrPaused:
+		return fmt.Sprintf("vm %s is already paused", e.VM)
+	case ErrNotPaused:
+		return fmt.Sprintf("vm %s is not paused (%s)", e.VM, e.From)
+	}
+	return fmt.Sprintf("vm %s is %s; cannot move to %s", e.VM, e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error { return e.Err }
+
+// CanTransition reports whether a VM may move from one state to another.
+func CanTransition(from, to VMState) bool { return slices.Contains(transitions[from], to) }
+
+// CheckTransition returns nil if vm may move from one state to another and a
+// *TransitionError otherwise.
+func CheckTransition(vm string, from, to VMState) error {
+	if CanTransition(from, to) {
+		return nil
+	}
+	err := ErrInvalidTransition
+	switch {
+	case to == StateStarting && (from == StateStarting || from == StateRunning || from == StatePaused):
+		err = ErrAlreadyRunning
+	case (to == StateStopping || to == StatePaused || to == StateRunning) && (from == StateStopped || from == StateCrashed || from == StateCreating):
+		err = ErrNotRunning
+	case to == StateDeleting && (from == StateStarting || from == StateRunning || from == StatePaused):
+		err = ErrRunning
+	case to == StatePaused && from == StatePaused:
+		err = ErrPaused
+	case to == StateRunning && from == StateRunning:
+		err = ErrNotPaused
+	}
+	return &TransitionError{VM: vm, From: from, To: to, Err: err}
+}
+
+// CheckOffline returns nil if a VM in state from may be renamed, exported or
+// repaired, and a *TransitionError matching ErrRunning otherwise, including while
+// it is starting, paused or waiting to be restarted.
+func CheckOffline(vm string, from VMState) error {
+	if slices.Contains(offline, from) {
+		return nil
+	}
+	return &TransitionError{VM: vm, From: from, Err: ErrRunning}
+}
@@ -0,0 +1,112 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckTransition(t *testing.T) {
+	t.Parallel()
+	// Every (from, to) pair not listed here must be rejected with ErrInvalidTransition.
+	want := map[[2]VMState]error{
+		{StateCreating, StateStopped}:  nil,
+		{StateCreating, StateDeleting}: nil,
+		{StateCreating, StateStopping}: ErrNotRunning,
+		{StateCreating, StatePaused}:   ErrNotRunning,
+		{StateCreating, StateRunning}:  ErrNotRunning,
+
+		{StateStopped, StateStarting}: nil,
+		{StateStopped, StateDeleting}: nil,
+		{StateStopped, StateStopping}: ErrNotRunning,
+		{StateStopped, StatePaused}:   ErrNotRunning,
+		{StateStopped, StateRunning}:  ErrNotRunning,
+
+		{StateStarting, StateRunning}:  nil,
+		{StateStarting, StateStopping}: nil,
+		{StateStarting, StateCrashed}:  nil,
+		{StateStarting, StateStarting}: ErrAlreadyRunning,
+		{StateStarting, StateDeleting}: ErrRunning,
+
+		{StateRunning, StatePaused}:   nil,
+		{StateRunning, StateStopping}: nil,
+		{StateRunning, StateCrashed}:  nil,
+		{StateRunning, StateStarting}: ErrAlreadyRunning,
+		{StateRunning, StateDeleting}: ErrRunning,
+		{StateRunning, StateRunning}:  ErrNotPaused,
+
+		{StatePaused, StateRunning}:  nil,
+		{StatePaused, StateStopping}: nil,
+		{StatePaused, StateCrashed}:  nil,
+		{StatePaused, StateStarting}: ErrAlreadyRunning,
+		{StatePaused, StateDeleting}: ErrRunning,
+		{StatePaused, StatePaused}:   ErrPaused,
+
+		{StateStopping, StateStopped}: nil,
+		{StateStopping, StateCrashed}: nil,
+
+		{StateCrashed, StateStarting}: nil,
+		{StateCrashed, StateStopped}:  nil,
+		{StateCrashed, StateDeleting}: nil,
+		{StateCrashed, StateStopping}: ErrNotRunning,
+		{StateCrashed, StatePaused}:   ErrNotRunning,
+		{StateCrashed, StateRunning}:  ErrNotRunning,
+	}
+	for _, from := range States {
+		for _, to := range States {
+			expect, listed := want[[2]VMState{from, to}]
+			if !listed {
+				expect = ErrInvalidTransition
+			}
+			err := CheckTransition("web", from, to)
+			if CanTransition(from, to) != (err == nil) {
+				t.Errorf("%s→%s: CanTransition disagrees with CheckTransition (%v)", from, to, err)
+			}
+			if expect == nil {
+				if err != nil {
+					t.Errorf("%s→%s: expected legal, got %v", from, to, err)
+				}
+				continue
+			}
+			var te *TransitionError
+			if !errors.As(err, &te) || !errors.Is(err, expect) || te.From != from || te.To != to {
+				t.Errorf("%s→%s: expected %v, got %v", from, to, expect, err)
+			}
+		}
+	}
+}
+
+func TestTransitionErrorMessages(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		from, to VMState
+		want     string
+	}{
+		{StateRunning, StateStarting, "vm web is already running"},
+		{StateStopped, StateStopping, "vm web is not running (stopped)"},
+		{StateRunning, StateDeleting, "vm web is running"},
+		{StateStopping, StateStarting, "vm web is stopping; cannot move to starting"},
+		{StatePaused, StatePaused, "vm web is already paused"},
+		{StateRunning, StateRunning, "vm web is not paused (running)"},
+	}
+	for _, c := range cases {
+		if got := CheckTransition("web", c.from, c.to).Error(); got != c.want {
+			t.Errorf("%s→%s: got %q, want %q", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestCheckOffline(t *testing.T) {
+	t.Parallel()
+	for _, from := range States {
+		err := CheckOffline("web", from)
+		if want := from == StateStopped || from == StateCrashed; want != (err == nil) {
+			t.Errorf("%s: offline = %v, want %v", from, err == nil, want)
+		}
+		if err != nil && !errors.Is(err, ErrRunning) {
+			t.Errorf("%s: expected ErrRunning, got %v", from, err)
+		}
+	}
+	if got := CheckOffline("web", StatePaused).Error(); got != "vm web is paused" {
+		t.Errorf("got %q", got)
+	}
+}
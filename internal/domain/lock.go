@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LockMode selects how a VM's runtime lock is held.
+type LockMode int
+
+const (
+	// LockShared is held by operations that read a VM's artifacts, such as export.
+	// Any number of shared holders may coexist, but none alongside an exclusive one.
+	LockShared LockMode = iota
+	// LockExclusive is held by a VM's shim for its whole life and by lifecycle
+	// operations that change the VM, such as rename and delete.
+	LockExclusive
+)
+
+func (m LockMode) String() string {
+	if m == LockExclusive {
+		return "exclusive"
+	}
+	return "shared"
+}
+
+// LockOwner describes the process holding a VM's exclusive lock.
+type LockOwner struct {
+	PID        int       `json:"pid"`
+	Hostname   string    `json:"hostname"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+}
+
+// ErrLocked is matched by a *LockedError.
+var ErrLocked = errors.New("vm is locked")
+
+// LockedError is returned when a VM's runtime lock is held by another process.
+type LockedError struct {
+	VM    string
+	Mode  LockMode   // the mode that was requested
+	Owner *LockOwner // the exclusive holder; nil if only shared holders are known
+}
+
+func (e *LockedError) Error() string {
+	if o := e.Owner; o != nil {
+		return fmt.Sprintf("vm %s is locked by pid %d on %s since %s", e.VM, o.PID, o.Hostname, o.AcquiredAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("vm %s is locked by another process", e.VM)
+}
+
+func (e *LockedError) Is(target error) bool { return target == ErrLocked }
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrVMExists is returned when a VM name is already taken.
+var ErrVMExists = errors.New("vm already exists")
+
+// MaxNameLength is the longest name that still fits in a single DNS label.
+const MaxNameLength = 63
+
+// ValidateName checks that name is usable as a VM name. Names double as the guest
+// hostname and its mDNS name (NAME.local), so they must be valid RFC 1123 DNS labels:
+// lowercase letters, digits and hyphens, not starting or ending with a hyphen.
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid vm name: must not be empty")
+	}
+	if len(name) > MaxNameLength {
+		return fmt.Errorf("invalid vm name %q: longer than %d characters", name, MaxNameLength)
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-':
+			if i == 0 || i == len(name)-1 {
+				return fmt.Errorf("invalid vm name %q: must not start or end with a hyphen", name)
+			}
+		default:
+			return fmt.Errorf("invalid vm name %q: only lowercase letters, digits and hyphens are allowed", name)
+		}
+	}
+	return nil
+}
+
+// RebasePath rewrites p from under oldDir to under newDir. Paths outside oldDir
+// are returned unchanged.
+func RebasePath(p, oldDir, newDir string) string {
+	rel, err := filepath.Rel(oldDir, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return p
+	}
+	return filepath.Join(newDir, rel)
+}
+
+// RebaseArtifacts rewrites vm's artifact paths from under oldDir to under newDir.
+func RebaseArtifacts(vm *VM, oldDir, newDir string) {
+	vm.DiskPath = RebasePath(vm.DiskPath, oldDir, newDir)
+	vm.EFIVarsPath = RebasePath(vm.EFIVarsPath, oldDir, newDir)
+	vm.SeedISOPath = RebasePath(vm.SeedISOPath, oldDir, newDir)
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		ok   bool
+	}{
+		{"vm-001", true},
+		{"api-dev", true},
+		{"a", true},
+		{"9lives", true},
+		{strings.Repeat("a", 63), true},
+		{"", false},
+		{strings.Repeat("a", 64), false},
+		{"-api", false},
+		{"api-", false},
+		{"Api", false},
+		{"api_dev", false},
+		{"api.dev", false},
+		{"api dev", false},
+		{"../etc", false},
+	}
+	for _, c := range cases {
+		err := ValidateName(c.name)
+		if (err == nil) != c.ok {
+			t.Errorf("ValidateName(%q) = %v, want ok=%v", c.name, err, c.ok)
+		}
+	}
+}
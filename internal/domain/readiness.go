@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ReadinessStage is a milestone in bringing a VM up. Stages are reached in order.
+type ReadinessStage string
+
+// Readiness stages, in the order they are reached.
+const (
+	StageShimUp        ReadinessStage = "shim-up"         // the shim holds the lock and has recorded its pid
+	StageVMStarted     ReadinessStage = "vm-started"      // the provider started the VM
+	StageGuestNetwork  ReadinessStage = "guest-network"   // the guest's hostname resolves to an address
+	StageSSHReachable  ReadinessStage = "ssh-reachable"   // the guest's ssh server answers
+	StageCloudInitDone ReadinessStage = "cloud-init-done" // cloud-init reported that it finished
+)
+
+// Stages lists every readiness stage in order.
+var Stages = []ReadinessStage{StageShimUp, StageVMStarted, StageGuestNetwork, StageSSHReachable, StageCloudInitDone}
+
+// GuestStages are the stages that can only be observed from the guest.
+var GuestStages = Stages[2:]
+
+// ParseStage parses the name of a readiness stage.
+func ParseStage(s string) (ReadinessStage, error) {
+	for _, st := range Stages {
+		if string(st) == s {
+			return st, nil
+		}
+	}
+	names := make([]string, len(Stages))
+	for i, st := range Stages {
+		names[i] = string(st)
+	}
+	return "", fmt.Errorf("unknown readiness stage %q (want one of %s)", s, strings.Join(names, ", "))
+}
+
+func (s ReadinessStage) index() int {
+	for i, st := range Stages {
+		if st == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Readiness records when the shim observed each stage.
+type Readiness map[ReadinessStage]time.Time
+
+// Stage returns the furthest stage reached, or "" if none.
+func (r Readiness) Stage() ReadinessStage {
+	var last ReadinessStage
+	for _, st := range Stages {
+		if _, ok := r[st]; ok {
+			last = st
+		}
+	}
+	return last
+}
+
+// Reached reports whether stage, or any later stage, has been reached.
+func (r Readiness) Reached(stage ReadinessStage) bool {
+	return r.Stage().index() >= stage.index()
+}
+
+// GuestProber observes the guest-side readiness stages of a running VM.
+type GuestProber interface {
+	// Probe reports whether vm has reached stage, which is one of GuestStages.
+	Probe(ctx context.Context, vm VM, stage ReadinessStage) (bool, error)
+	// Address returns the guest's current network address.
+	Address(ctx context.Context, vm VM) (string, error)
+}
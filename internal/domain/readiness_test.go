@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadinessStage(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	cases := []struct {
+		marked []ReadinessStage
+		stage  ReadinessStage
+		wants  map[ReadinessStage]bool
+	}{
+		{nil, "", map[ReadinessStage]bool{StageShimUp: false}},
+		{[]ReadinessStage{StageShimUp}, StageShimUp, map[ReadinessStage]bool{StageShimUp: true, StageVMStarted: false}},
+		{[]ReadinessStage{StageShimUp, StageVMStarted, StageGuestNetwork}, StageGuestNetwork,
+			map[ReadinessStage]bool{StageVMStarted: true, StageGuestNetwork: true, StageSSHReachable: false}},
+		// A later stage implies the earlier ones even if they were not recorded.
+		{[]ReadinessStage{StageSSHReachable}, StageSSHReachable, map[ReadinessStage]bool{StageVMStarted: true, StageCloudInitDone: false}},
+	}
+	for _, c := range cases {
+		r := Readiness{}
+		for _, st := range c.marked {
+			r[st] = now
+		}
+		if got := r.Stage(); got != c.stage {
+			t.Errorf("%v: Stage() = %q, want %q", c.marked, got, c.stage)
+		}
+		for st, want := range c.wants {
+			if got := r.Reached(st); got != want {
+				t.Errorf("%v: Reached(%s) = %v, want %v", c.marked, st, got, want)
+			}
+		}
+	}
+}
+
+func TestParseStage(t *testing.T) {
+	t.Parallel()
+	for _, st := range Stages {
+		if got, err := ParseStage(string(st)); err != nil || got != st {
+			t.Errorf("ParseStage(%q) = %q, %v", st, got, err)
+		}
+	}
+	if _, err := ParseStage("booted"); err == nil {
+		t.Error("expected unknown stage to be rejected")
+	}
+}
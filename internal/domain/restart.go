@@ -0,0 +1,102 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// RestartMode selects which shim exits a VM is restarted after.
+type RestartMode string
+
+const (
+	RestartNo        RestartMode = "no"         // never restart
+	RestartOnFailure RestartMode = "on-failure" // restart after the VM or its shim failed
+	RestartAlways    RestartMode = "always"     // restart after any exit that was not requested
+)
+
+// ParseRestartMode validates a restart mode given by name. Empty means RestartNo.
+func ParseRestartMode(s string) (RestartMode, error) {
+	switch m := RestartMode(s); m {
+	case "":
+		return RestartNo, nil
+	case RestartNo, RestartOnFailure, RestartAlways:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown restart policy %q: want %s, %s or %s", s, RestartNo, RestartOnFailure, RestartAlways)
+}
+
+// RestartPolicy decides whether a VM is started again after its shim exits. A stop
+// the user asked for is never undone.
+type RestartPolicy struct {
+	Mode RestartMode `json:"mode,omitempty"`
+	// MaxRetries bounds consecutive restarts; zero means no bound.
+	MaxRetries int `json:"maxRetries,omitempty"`
+}
+
+// Validate checks the mode and retry bound.
+func (p RestartPolicy) Validate() error {
+	if _, err := ParseRestartMode(string(p.Mode)); err != nil {
+		return err
+	}
+	if p.MaxRetries < 0 {
+		return fmt.Errorf("max restarts must not be negative, got %d", p.MaxRetries)
+	}
+	return nil
+}
+
+// Enabled reports whether the policy ever restarts the VM, which needs a supervisor.
+func (p RestartPolicy) Enabled() bool { return p.Mode == RestartOnFailure || p.Mode == RestartAlways }
+
+// Wants reports whether the policy restarts the VM after exit, disregarding
+// MaxRetries.
+func (p RestartPolicy) Wants(exit ShimExit) bool {
+	switch p.Mode {
+	case RestartOnFailure:
+		return exit.State == StateCrashed
+	case RestartAlways:
+		return !exit.Requested
+	}
+	return false
+}
+
+func (p RestartPolicy) String() string {
+	if p.Mode == "" {
+		return string(RestartNo)
+	}
+	if p.Enabled() && p.MaxRetries > 0 {
+		return fmt.Sprintf("%s (max %d)", p.Mode, p.MaxRetries)
+	}
+	return string(p.Mode)
+}
+
+// Restart backoff: the delay before a restart doubles with each consecutive one,
+// from RestartBackoff up to MaxRestartBackoff. A VM that stayed up for
+// RestartResetAfter is considered healthy again and starts over.
+const (
+	RestartBackoff    = time.Second
+	MaxRestartBackoff = 5 * time.Minute
+	RestartResetAfter = 10 * time.Minute
+)
+
+// RestartDelay returns the delay before the n-th consecutive restart, counting from 1.
+func RestartDelay(n int) time.Duration {
+	d := RestartBackoff
+	for i := 1; i < n && d < MaxRestartBackoff; i++ {
+		d *= 2
+	}
+	return min(d, MaxRestartBackoff)
+}
+
+// SupervisorState is what a VM's supervisor records about the restarts it makes.
+type SupervisorState struct {
+	PID    int           `json:"pid"`
+	Policy RestartPolicy `json:"policy"`
+	// Restarts counts the shims started after the first.
+	Restarts int `json:"restarts"`
+	// NextRestart is set while the supervisor waits to restart the VM.
+	NextRestart time.Time `json:"nextRestart,omitzero"`
+	// GaveUp says why the supervisor stopped restarting the VM, if it did.
+	GaveUp string `json:"gaveUp,omitempty"`
+	// Alive is set when the state is read, if the supervisor is still running.
+	Alive bool `json:"-"`
+}
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ShimProtocolVersion is the version of the control protocol spoken on a shim's
+// control socket. Clients and shims of different versions do not talk to each
+// other; clients fall back to the runtime files and signals instead.
+const ShimProtocolVersion = 1
+
+var (
+	// ErrNoControl is returned when a VM's shim cannot be reached over its control
+	// socket, e.g. because it predates the socket or is shutting down.
+	ErrNoControl = errors.New("shim control socket is unavailable")
+	// ErrNotSupported is returned for operations the VM's provider cannot perform.
+	ErrNotSupported = errors.New("operation is not supported by the vm provider")
+)
+
+// DefaultStopTimeout is how long a guest gets to shut down before it is powered
+// off, when StopOptions do not say.
+const DefaultStopTimeout = 30 * time.Second
+
+// StopOptions control how a shim stops its VM.
+type StopOptions struct {
+	// Force powers the VM off without asking the guest to shut down.
+	Force bool `json:"force,omitempty"`
+	// Timeout is how long the guest gets to shut down before it is powered off.
+	// Zero means DefaultStopTimeout.
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// ShimInfo is what a running shim reports about itself and its VM.
+type ShimInfo struct {
+	Protocol  int            `json:"protocol"`
+	PID       int            `json:"pid"`
+	VM        string         `json:"vm"`
+	VMID      string         `json:"vmId,omitempty"`
+	StartedAt time.Time      `json:"startedAt"`
+	Stage     ReadinessStage `json:"stage,omitempty"`
+	Readiness Readiness      `json:"readiness,omitempty"`
+	Paused    bool           `json:"paused,omitempty"`
+	// Stopping is set once the shim has been asked to stop.
+	Stopping bool `json:"stopping,omitempty"`
+}
+
+// GuestInfo describes the guest as the shim observes it. Address is empty until
+// the guest is on the network.
+type GuestInfo struct {
+	Hostname string         `json:"hostname"`
+	Address  string         `json:"address,omitempty"`
+	Stage    ReadinessStage `json:"stage,omitempty"`
+}
+
+// ShimExit records why a VM's shim exited.
+type ShimExit struct {
+	Time time.Time `json:"time"`
+	PID  int       `json:"pid"`
+	// State is the state the shim left the VM in: stopped, or crashed if the VM
+	// failed.
+	State  VMState `json:"state"`
+	Reason string  `json:"reason"`
+	// Code is the shim's exit status.
+	Code int `json:"code"`
+	// Requested is set if the shim was asked to stop, rather than the VM
+	// stopping by itself.
+	Requested bool `json:"requested,omitempty"`
+}
+
+// ShimControl is a connection to a running shim's control socket.
+type ShimControl interface {
+	Status(ctx context.Context) (ShimInfo, error)
+	// Stop asks the shim to stop the VM and exit. It returns once the request is
+	// accepted, not once the shim has exited. A forced stop sent while a graceful
+	// one is under way powers the VM off without waiting any longer.
+	Stop(ctx context.Context, opts StopOptions) error
+	// Pause and Resume fail with ErrNotSupported if the provider cannot pause.
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+	Readiness(ctx context.Context) (Readiness, error)
+	GuestInfo(ctx context.Context) (GuestInfo, error)
+	Close() error
+}
+
+// VMPauser is implemented by providers that can pause and resume a running VM.
+// Shims of VMs on other providers refuse to pause with ErrNotSupported.
+type VMPauser interface {
+	PauseVM(ctx context.Context, vm VM) error
+	ResumeVM(ctx context.Context, vm VM) error
+}
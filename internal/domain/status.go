@@ -0,0 +1,24 @@
+package domain
+
+import "time"
+
+// VMStatus is the state of a VM as observed at a point in time. It is derived from
+// the runtime state and the shim, never stored as configuration.
+type VMStatus struct {
+	State       VMState        `json:"state"`
+	PID         int            `json:"pid,omitempty"` // the shim's pid while it is alive
+	ConsoleSock string         `json:"consoleSock,omitempty"`
+	Stage       ReadinessStage `json:"stage,omitempty"`     // the furthest readiness stage reached
+	Readiness   Readiness      `json:"readiness,omitempty"` // when each stage was reached
+	ObservedAt  time.Time      `json:"observedAt"`
+	Reason      string         `json:"reason,omitempty"` // why the VM is in State, if known
+	// LastExit is the last time a shim of the VM exited.
+	LastExit *ShimExit `json:"lastExit,omitempty"`
+	// Restarts counts the times the VM's restart policy started it again.
+	Restarts int `json:"restarts,omitempty"`
+}
+
+// Active reports whether a shim owns the VM.
+func (s VMStatus) Active() bool {
+	return s.State == StateStarting || s.State == StateRunning || s.State == StatePaused || s.State == StateStopping
+}
@@ -1,12 +1,38 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
-// VM represents a virtual machine's desired and runtime state.
+// ErrConflict is returned when saving a VM record that was modified since it was loaded.
+var ErrConflict = errors.New("vm record was modified concurrently")
+
+// VM is a stored VM record: its identity, the desired configuration in VMSpec and
+// bookkeeping owned by the store. Whether the VM is running is not part of the
+// record; it is observed live from the runtime state (see VMStatus).
 type VM struct {
-	ID            string `json:"id"`
-	Name          string `json:"name"`
-	CreatedAt     int64  `json:"createdAt"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"createdAt"`
+	VMSpec
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Revision is bumped by the store on every save; a save carrying an older
+	// revision than the stored record fails with ErrConflict.
+	Revision int64 `json:"revision"`
+
+	// LastObserved caches the most recent observed status so that it can be shown
+	// when it cannot be observed live. It is never authoritative, and is kept in
+	// the runtime directory rather than the record, so observing a VM never
+	// revises its record.
+	LastObserved *VMStatus `json:"-"`
+}
+
+// VMSpec is the desired configuration of a VM.
+type VMSpec struct {
 	CPUs          int    `json:"cpus"`
 	MemoryMiB     int    `json:"memoryMiB"`
 	DiskPath      string `json:"diskPath"`
@@ -17,35 +43,135 @@ type VM struct {
 	Hostname      string `json:"hostname"`
 	BaseImageRef  string `json:"baseImageRef"`
 	EnableRosetta bool   `json:"enableRosetta"` // Enable Rosetta for x86 binary translation in ARM VM
-
-	// Runtime
-	PID         int    `json:"pid"`
-	ConsoleSock string `json:"consoleSock"`
-	Status      string `json:"status"`
+	// RestartPolicy says whether the VM is started again after it stops by itself.
+	RestartPolicy RestartPolicy `json:"restartPolicy,omitzero"`
 }
 
 // VMStore persists VM metadata and provides name allocation.
 type VMStore interface {
+	// NextName allocates and reserves an unused generated name.
 	NextName(ctx context.Context) (string, error)
-	Save(ctx context.Context, vm VM) error
+	// Reserve atomically claims name for a new VM, failing with ErrVMExists if taken.
+	Reserve(ctx context.Context, name string) error
+	// Save persists vm if its Revision matches the stored record and updates
+	// vm.Revision to the newly written revision.
+	Save(ctx context.Context, vm *VM) error
 	Load(ctx context.Context, nameOrID string) (*VM, error)
 	Delete(ctx context.Context, nameOrID string) error
-	List(ctx context.Context) ([]VM, error)
+	// List returns the readable VMs and, separately, the VMs whose records could
+	// not be loaded, so that they are reported rather than silently skipped.
+	List(ctx context.Context) ([]VM, []BrokenVM, error)
+	// Recover replaces the unreadable or missing record of vm.Name with vm,
+	// keeping a copy of any broken record. It refuses to replace a readable one.
+	Recover(ctx context.Context, vm *VM) error
+	// Rename moves vm's record and directory to newName. vm must carry the stored
+	// Revision; on success it holds the renamed record, with artifact paths under
+	// the old directory rewritten to the new one.
+	Rename(ctx context.Context, vm *VM, newName string) error
+	// Migrate upgrades stored records to the current schema version and reports
+	// what changed. With dryRun it only reports what would change.
+	Migrate(ctx context.Context, dryRun bool) ([]SchemaMigration, error)
+}
+
+// VMChange is a committed change to a stored VM record, as delivered by a VMWatcher.
+type VMChange struct {
+	Type     string `json:"type"` // VMChangeSaved or VMChangeDeleted
+	Name     string `json:"name"`
+	Revision int64  `json:"revision"`
+	VM       *VM    `json:"vm,omitempty"` // the record as of the change; nil for deletions
+}
+
+// Kinds of VMChange.
+const (
+	VMChangeSaved   = "saved"
+	VMChangeDeleted = "deleted"
+)
+
+// VMWatcher is implemented by stores that can stream committed record changes.
+// The channel is closed when ctx is done.
+type VMWatcher interface {
+	Watch(ctx context.Context) (<-chan VMChange, error)
+}
+
+// LabelIndex is implemented by stores that index VMs by label, so equality
+// selectors need not scan every record.
+type LabelIndex interface {
+	ListByLabel(ctx context.Context, key, value string) ([]VM, error)
+}
+
+// RecordImporter is implemented by stores that can adopt records from another
+// store verbatim, keeping their IDs and revisions.
+type RecordImporter interface {
+	// ImportRecords stores each VM not already present and returns the names imported.
+	ImportRecords(ctx context.Context, vms []VM) ([]string, error)
+}
+
+// Kinds of BrokenVM.
+const (
+	BrokenUnreadable = "unreadable-record" // a record exists but cannot be decoded
+	BrokenMissing    = "missing-record"    // a VM directory holds a disk but no record
+)
+
+// BrokenVM is a VM the store could not load.
+type BrokenVM struct {
+	Name  string `json:"name"`
+	Dir   string `json:"dir"`
+	Kind  string `json:"kind"`
+	Error string `json:"error"`
+	// Recovered holds the fields that could still be decoded, if any.
+	Recovered *VM `json:"recovered,omitempty"`
+}
+
+// SchemaMigration describes the upgrade of one stored VM record.
+type SchemaMigration struct {
+	VM     string   `json:"vm"`
+	From   int      `json:"from"`
+	To     int      `json:"to"`
+	Steps  []string `json:"steps"`
+	Backup string   `json:"backup,omitempty"` // copy of the original record, once migrated
 }
 
 // VirtualizationProvider abstracts vfkit usage.
 type VirtualizationProvider interface {
 	StartVM(ctx context.Context, vm VM) (pid int, err error)
+	// RequestStopVM asks the guest to shut down, like pressing its power button,
+	// and returns without waiting for it to do so.
+	RequestStopVM(ctx context.Context, vm VM) error
+	// StopVM powers the VM off at once.
 	StopVM(ctx context.Context, vm VM) error
 	IsRunning(ctx context.Context, vm VM) (bool, error)
+	// StateChanges streams the states a started VM moves through, including ones
+	// the guest or the hypervisor cause, such as a guest powering itself off. The
+	// channel is closed once ctx is done.
+	StateChanges(ctx context.Context, vm VM) (<-chan ProviderState, error)
 }
 
+// ProviderState is the state of a VM as its provider reports it.
+type ProviderState string
+
+const (
+	ProviderStarting ProviderState = "starting"
+	ProviderRunning  ProviderState = "running"
+	ProviderPaused   ProviderState = "paused"
+	ProviderStopping ProviderState = "stopping"
+	ProviderStopped  ProviderState = "stopped"
+	ProviderError    ProviderState = "error"
+)
+
 // ShimProcessManager abstracts re-exec shim lifecycle.
 type ShimProcessManager interface {
 	StartDetached(ctx context.Context, vm VM) (pid int, err error)
-	Stop(ctx context.Context, pid int) error
-	WaitReadyAndPID(ctx context.Context, vmName string) (pid int, err error)
+	// Stop signals the shim with pid to stop its VM, shutting the guest down, or
+	// kills it, powering the VM off with it, if force is set. It is for shims that
+	// cannot be reached over their control socket.
+	Stop(ctx context.Context, pid int, force bool) error
+	// WaitStage blocks until the VM's shim reports stage, returning the shim's pid.
+	// ctx bounds the wait.
+	WaitStage(ctx context.Context, vmName string, stage ReadinessStage) (pid int, err error)
 	GetPID(ctx context.Context, vmName string) (pid int, err error)
+	// Control connects to the VM's shim over its control socket, failing with
+	// ErrNoControl if the shim is not listening.
+	Control(ctx context.Context, vmName string) (ShimControl, error)
 }
 
 // VMArtifacts prepares per-VM artifacts on the host filesystem.
@@ -53,15 +179,74 @@ type VMArtifacts interface {
 	// Prepare ensures per-VM directory exists, clones/copies base image to disk.img,
 	// creates nvram.bin placeholder, and sets SeedISOPath.
 	Prepare(ctx context.Context, vm *VM) error
+	// Dir returns the per-VM directory that holds the VM's artifacts.
+	Dir(name string) string
+	// Usage reports the host storage consumed by the VM's artifacts.
+	Usage(ctx context.Context, vm VM) (ArtifactUsage, error)
+	// HomeUsage reports the bytes allocated under the whole orchard home directory.
+	HomeUsage(ctx context.Context) (int64, error)
+}
+
+// ArtifactUsage describes how much host storage a VM's artifacts consume.
+type ArtifactUsage struct {
+	DiskLogicalBytes   int64 `json:"diskLogicalBytes"`   // apparent size of the disk image
+	DiskAllocatedBytes int64 `json:"diskAllocatedBytes"` // blocks actually allocated on the host
+	DiskSharedBytes    int64 `json:"diskSharedBytes"`    // allocated blocks shared with the base image clone source
+	SerialLogBytes     int64 `json:"serialLogBytes"`
+	ShimLogBytes       int64 `json:"shimLogBytes"`
 }
 
 // RuntimeState abstracts ephemeral runtime coordination for a VM on the host.
 type RuntimeState interface {
-	AcquireLock(ctx context.Context, vmName string) (release func() error, err error)
+	// AcquireLock takes the VM's runtime lock without waiting, failing with a
+	// *LockedError if a conflicting holder exists. The lock is released by the
+	// returned func or when the holding process dies.
+	AcquireLock(ctx context.Context, vmName string, mode LockMode) (release func() error, err error)
+	// LockOwner reports the exclusive holder of the VM's lock, or nil if there is none.
+	LockOwner(ctx context.Context, vmName string) (*LockOwner, error)
 	WritePID(ctx context.Context, vmName string, pid int) error
 	ReadPID(ctx context.Context, vmName string) (int, error)
-	MarkReady(ctx context.Context, vmName string) error
+	// LivePID returns the recorded pid only if that process is alive and is the one
+	// that recorded it, not an unrelated process that reused the pid. Runtime files
+	// naming a reused pid are removed.
+	LivePID(ctx context.Context, vmName string) (int, error)
+	// MarkStage records that the VM reached a readiness stage.
+	MarkStage(ctx context.Context, vmName string, stage ReadinessStage) error
+	// Readiness reports the stages reached so far by the current shim.
+	Readiness(ctx context.Context, vmName string) (Readiness, error)
 	Clear(ctx context.Context, vmName string) error
 	CleanupIfStale(ctx context.Context, vmName string) error
-	WaitReadyAndPID(ctx context.Context, vmName string) (int, error)
+	// Remove deletes all of the VM's runtime files, including the records kept
+	// after its shim exits, for a VM that is being deleted.
+	Remove(ctx context.Context, vmName string) error
+	// Rename moves the VM's runtime files to newName, for a VM that was renamed.
+	Rename(ctx context.Context, oldName, newName string) error
+	// WaitStage blocks until stage is marked, returning the recorded pid. It fails
+	// early if the shim that recorded the pid has died. ctx bounds the wait.
+	WaitStage(ctx context.Context, vmName string, stage ReadinessStage) (int, error)
+	// Changes notifies of changes to the VM's runtime files (pid written or
+	// cleared, stages marked) until ctx is done. The channel is nil if
+	// notification is unavailable. Callers should still recheck every returned
+	// interval, which also catches a shim that died without touching its files.
+	Changes(ctx context.Context, vmName string) (<-chan struct{}, time.Duration)
+	// ControlSocket returns the path of the unix socket the VM's shim listens on.
+	ControlSocket(vmName string) string
+	// WriteExit records why the VM's shim is exiting. Unlike the other runtime
+	// files, the record is kept after the shim is gone, until the next one exits.
+	WriteExit(ctx context.Context, vmName string, exit ShimExit) error
+	// LastExit returns the record of the last shim exit, or nil if there is none.
+	LastExit(ctx context.Context, vmName string) (*ShimExit, error)
+	// SaveObserved caches the last observed status of the VM.
+	SaveObserved(ctx context.Context, vmName string, st VMStatus) error
+	// LastObserved returns the cached status, or nil if there is none.
+	LastObserved(ctx context.Context, vmName string) (*VMStatus, error)
+	// SetSupervisor records the state of the VM's supervisor, or removes the record
+	// if st is nil.
+	SetSupervisor(ctx context.Context, vmName string, st *SupervisorState) error
+	// Supervisor returns the last recorded supervisor state, or nil if there is
+	// none, with Alive set if the supervisor is still running.
+	Supervisor(ctx context.Context, vmName string) (*SupervisorState, error)
+	// StalePaths reports runtime files left behind by a shim that is no longer alive,
+	// without removing them.
+	StalePaths(ctx context.Context, vmName string) ([]string, error)
 }
@@ -0,0 +1,201 @@
+// Package fs stores VM event journals as JSON lines under <base>/events, one file
+// per VM name. Journals are kept outside the VM directories so they survive delete.
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/spf13/afero"
+)
+
+const ext = ".jsonl"
+
+type Journal struct {
+	baseDir string
+	fs      afero.Fs
+	// Clock stamps events appended without a time.
+	Clock domain.Clock
+	// PollInterval is how often Follow checks for new lines.
+	PollInterval time.Duration
+}
+
+func New(baseDir string) *Journal { return NewWithFS(baseDir, afero.NewOsFs()) }
+
+func NewWithFS(baseDir string, fsys afero.Fs) *Journal {
+	return &Journal{baseDir: baseDir, fs: fsys, Clock: domain.RealClock{}, PollInterval: 250 * time.Millisecond}
+}
+
+func (j *Journal) dir() string { return filepath.Join(j.baseDir, "events") }
+
+func (j *Journal) path(vmName string) string { return filepath.Join(j.dir(), vmName+ext) }
+
+// Append writes e as a single line with O_APPEND, so concurrent writers (the CLI
+// and a shim) never interleave within an event.
+func (j *Journal) Append(ctx context.Context, e domain.Event) error {
+	if e.Time.IsZero() {
+		e.Time = j.Clock.Now()
+	}
+	e.Time = e.Time.UTC()
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := j.fs.MkdirAll(j.dir(), 0o755); err != nil {
+		return err
+	}
+	f, err := j.fs.OpenFile(j.path(e.VM), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (j *Journal) Read(ctx context.Context, vmName string, since time.Time) ([]domain.Event, error) {
+	files, err := j.files(vmName)
+	if err != nil {
+		return nil, err
+	}
+	var out []domain.Event
+	for _, p := range files {
+		b, err := afero.ReadFile(j.fs, p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		evs, _ := parse(b)
+		for _, e := range evs {
+			if !e.Time.Before(since) {
+				out = append(out, e)
+			}
+		}
+	}
+	sort.SliceStable(out, func(a, b int) bool { return out[a].Time.Before(out[b].Time) })
+	return out, nil
+}
+
+func (j *Journal) Follow(ctx context.Context, vmName string, since time.Time) (<-chan domain.Event, error) {
+	// The history is read through the same offsets that are polled afterwards, so
+	// lines appended while it is read are streamed exactly once.
+	offsets := map[string]int64{}
+	files, err := j.files(vmName)
+	if err != nil {
+		return nil, err
+	}
+	var history []domain.Event
+	for _, p := range files {
+		for _, e := range j.readFrom(p, offsets) {
+			if !e.Time.Before(since) {
+				history = append(history, e)
+			}
+		}
+	}
+	sort.SliceStable(history, func(a, b int) bool { return history[a].Time.Before(history[b].Time) })
+	ch := make(chan domain.Event)
+	go func() {
+		defer close(ch)
+		for _, e := range history {
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+		t := time.NewTicker(j.PollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			files, _ := j.files(vmName)
+			for _, p := range files {
+				evs := j.readFrom(p, offsets)
+				for _, e := range evs {
+					select {
+					case ch <- e:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// readFrom returns the complete lines appended to p since offsets[p] and advances
+// the offset past them. A partially written last line is left for the next poll.
+func (j *Journal) readFrom(p string, offsets map[string]int64) []domain.Event {
+	f, err := j.fs.Open(p)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if _, err := f.Seek(offsets[p], io.SeekStart); err != nil {
+		return nil
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil
+	}
+	evs, n := parse(b)
+	offsets[p] += int64(n)
+	return evs
+}
+
+// parse decodes complete JSON lines from b and returns them with the number of
+// bytes consumed. Malformed lines are skipped.
+func parse(b []byte) ([]domain.Event, int) {
+	var out []domain.Event
+	n := bytes.LastIndexByte(b, '\n') + 1
+	sc := bufio.NewScanner(bytes.NewReader(b[:n]))
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		var e domain.Event
+		if json.Unmarshal(sc.Bytes(), &e) == nil {
+			out = append(out, e)
+		}
+	}
+	return out, n
+}
+
+// files lists the journal for vmName, or every journal if vmName is empty.
+func (j *Journal) files(vmName string) ([]string, error) {
+	if vmName != "" {
+		return []string{j.path(vmName)}, nil
+	}
+	entries, err := afero.ReadDir(j.fs, j.dir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ext) {
+			out = append(out, filepath.Join(j.dir(), e.Name()))
+		}
+	}
+	return out, nil
+}
+
+var _ domain.EventJournal = (*Journal)(nil)
@@ -0,0 +1,84 @@
+package fs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/spf13/afero"
+)
+
+type fixedClock struct{ t time.Time }
+
+func (c fixedClock) Now() time.Time { return c.t }
+
+func TestAppendAndReadSince(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	j := NewWithFS("/root", afero.NewMemMapFs())
+	t0 := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	j.Clock = fixedClock{t0}
+	_ = j.Append(ctx, domain.Event{VM: "web", Type: domain.EventCreated, Actor: domain.ActorCLI})
+	j.Clock = fixedClock{t0.Add(time.Minute)}
+	_ = j.Append(ctx, domain.Event{VM: "db", Type: domain.EventCreated, Actor: domain.ActorCLI})
+	j.Clock = fixedClock{t0.Add(2 * time.Minute)}
+	_ = j.Append(ctx, domain.Event{VM: "web", Type: domain.EventCrashed, PID: 42, Actor: domain.ActorShim, Error: "boom"})
+
+	web, err := j.Read(ctx, "web", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(web) != 2 || web[0].Type != domain.EventCreated || web[1].Error != "boom" || web[1].PID != 42 {
+		t.Fatalf("web journal = %+v", web)
+	}
+	if !web[0].Time.Equal(t0) {
+		t.Fatalf("expected clock timestamp %v, got %v", t0, web[0].Time)
+	}
+
+	all, _ := j.Read(ctx, "", t0.Add(30*time.Second))
+	if len(all) != 2 || all[0].VM != "db" || all[1].VM != "web" {
+		t.Fatalf("merged journal since t0+30s = %+v", all)
+	}
+	if none, _ := j.Read(ctx, "missing", time.Time{}); len(none) != 0 {
+		t.Fatalf("expected no events for unknown VM, got %+v", none)
+	}
+}
+
+func TestFollowStreamsHistoryThenNewEventsOnce(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	j := NewWithFS("/root", afero.NewMemMapFs())
+	j.PollInterval = time.Millisecond
+	t0 := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	j.Clock = fixedClock{t0}
+	_ = j.Append(ctx, domain.Event{VM: "web", Type: domain.EventCreated})
+	j.Clock = fixedClock{t0.Add(time.Minute)}
+	_ = j.Append(ctx, domain.Event{VM: "web", Type: domain.EventStarting})
+
+	ch, err := j.Follow(ctx, "", t0.Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = j.Append(ctx, domain.Event{VM: "db", Type: domain.EventCreated})
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case e := <-ch:
+			got = append(got, e.VM+" "+string(e.Type))
+		case <-ctx.Done():
+			t.Fatalf("timed out; saw %v", got)
+		}
+	}
+	if got[0] != "web starting" || got[1] != "db created" {
+		t.Fatalf("followed %v, want the history since then the new event", got)
+	}
+	select {
+	case e := <-ch:
+		t.Fatalf("streamed an extra event %+v", e)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
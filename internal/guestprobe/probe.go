@@ -0,0 +1,145 @@
+// Package guestprobe observes the guest-side readiness stages of a VM from the
+// host: name resolution over mDNS, the ssh server banner, and cloud-init's
+// completion message on the serial console.
+package guestprobe
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+// cloudInitDone matches the line cloud-init writes to the console when its final
+// stage completes, e.g. "Cloud-init v. 24.1 finished at ...".
+var cloudInitDone = regexp.MustCompile(`Cloud-init v\. \S+ finished at`)
+
+// Prober implements domain.GuestProber for a single boot of a VM: serial console
+// output logged before its first probe, i.e. by earlier boots, is ignored.
+type Prober struct {
+	Resolver    *net.Resolver
+	DialTimeout time.Duration
+	SSHPort     int
+
+	mu      sync.Mutex
+	offsets map[string]int64 // serial log size at the first probe, by VM ID
+}
+
+func New() *Prober {
+	return &Prober{Resolver: net.DefaultResolver, DialTimeout: 2 * time.Second, SSHPort: 22}
+}
+
+// SerialLog returns the path the provider logs vm's serial console to.
+func SerialLog(vm domain.VM) string { return filepath.Join(filepath.Dir(vm.DiskPath), "serial.log") }
+
+func (p *Prober) Probe(ctx context.Context, vm domain.VM, stage domain.ReadinessStage) (bool, error) {
+	offset, err := p.offset(vm)
+	if err != nil {
+		return false, err
+	}
+	switch stage {
+	case domain.StageGuestNetwork:
+		_, err := p.Address(ctx, vm)
+		return err == nil, nil
+	case domain.StageSSHReachable:
+		addr, err := p.Address(ctx, vm)
+		if err != nil {
+			return false, nil
+		}
+		return p.sshBanner(ctx, addr), nil
+	case domain.StageCloudInitDone:
+		return p.cloudInitDone(vm, offset)
+	}
+	return false, fmt.Errorf("stage %s is not observed from the guest", stage)
+}
+
+// offset returns the size the serial log had when vm was first probed.
+func (p *Prober) offset(vm domain.VM) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if off, ok := p.offsets[vm.ID]; ok {
+		return off, nil
+	}
+	var off int64
+	st, err := os.Stat(SerialLog(vm))
+	switch {
+	case err == nil:
+		off = st.Size()
+	case !errors.Is(err, os.ErrNotExist):
+		return 0, err
+	}
+	if p.offsets == nil {
+		p.offsets = map[string]int64{}
+	}
+	p.offsets[vm.ID] = off
+	return off, nil
+}
+
+// Address resolves the guest's HOSTNAME.local, preferring IPv4.
+func (p *Prober) Address(ctx context.Context, vm domain.VM) (string, error) {
+	host := vm.Hostname
+	if host == "" {
+		host = vm.Name
+	}
+	addrs, err := p.Resolver.LookupIPAddr(ctx, host+".local")
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no address for %s.local", host)
+	}
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			return a.IP.String(), nil
+		}
+	}
+	return addrs[0].IP.String(), nil
+}
+
+// sshBanner reports whether an ssh server answers with its identification string.
+func (p *Prober) sshBanner(ctx context.Context, addr string) bool {
+	d := net.Dialer{Timeout: p.DialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(addr, strconv.Itoa(p.SSHPort)))
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(p.DialTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	return err == nil && strings.HasPrefix(line, "SSH-")
+}
+
+func (p *Prober) cloudInitDone(vm domain.VM, offset int64) (bool, error) {
+	f, err := os.Open(SerialLog(vm))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if cloudInitDone.Match(sc.Bytes()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+var _ domain.GuestProber = (*Prober)(nil)
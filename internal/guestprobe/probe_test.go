@@ -0,0 +1,41 @@
+package guestprobe
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+func TestCloudInitDoneIgnoresEarlierBoots(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	vm := domain.VM{ID: "01J0000000000000000000000V", Name: "web", VMSpec: domain.VMSpec{DiskPath: filepath.Join(dir, "disk.img")}}
+	log := SerialLog(vm)
+	done := "[   25.4] cloud-init[812]: Cloud-init v. 24.1.3 finished at Mon, 01 Jan 2025 00:00:25 +0000. Datasource DataSourceNoCloud.  Up 25.41 seconds\n"
+	if err := os.WriteFile(log, []byte("previous boot\n"+done), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := New()
+	if ok, err := p.Probe(ctx, vm, domain.StageCloudInitDone); err != nil || ok {
+		t.Fatalf("finish message from an earlier boot counted: %v, %v", ok, err)
+	}
+	f, err := os.OpenFile(log, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("[    0.0] Linux version 6.8\n" + done)
+	f.Close()
+	if ok, err := p.Probe(ctx, vm, domain.StageCloudInitDone); err != nil || !ok {
+		t.Fatalf("finish message from this boot not seen: %v, %v", ok, err)
+	}
+}
+
+func TestProbeRejectsHostStages(t *testing.T) {
+	if _, err := New().Probe(context.Background(), domain.VM{}, domain.StageVMStarted); err == nil {
+		t.Fatal("expected an error probing a host stage")
+	}
+}
@@ -0,0 +1,89 @@
+// Package knownhosts edits OpenSSH known_hosts files.
+package knownhosts
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// DefaultPath returns the current user's known_hosts file.
+func DefaultPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// RenameHost rewrites every plain-text host pattern equal to oldHost (optionally
+// in the [host]:port form) to newHost, keeping the recorded keys. Hashed entries
+// cannot be matched without the key and are left alone. It returns the number of
+// lines changed; a missing file is not an error.
+func RenameHost(fsys afero.Fs, path, oldHost, newHost string) (int, error) {
+	b, err := afero.ReadFile(fsys, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	changed := 0
+	for i, line := range lines {
+		if out, ok := renameLine(string(line), oldHost, newHost); ok {
+			lines[i] = []byte(out)
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	st, err := fsys.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	tmp := path + ".orchard.tmp"
+	if err := afero.WriteFile(fsys, tmp, bytes.Join(lines, nil), st.Mode().Perm()); err != nil {
+		return 0, err
+	}
+	if err := fsys.Rename(tmp, path); err != nil {
+		_ = fsys.Remove(tmp)
+		return 0, err
+	}
+	return changed, nil
+}
+
+func renameLine(line, oldHost, newHost string) (string, bool) {
+	trimmed := strings.TrimLeft(line, " \t")
+	if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '|' {
+		return line, false
+	}
+	// An optional @cert-authority or @revoked marker precedes the host list.
+	fields := strings.SplitN(trimmed, " ", 3)
+	hostIdx := 0
+	if strings.HasPrefix(fields[0], "@") {
+		hostIdx = 1
+	}
+	if hostIdx >= len(fields) {
+		return line, false
+	}
+	patterns := strings.Split(fields[hostIdx], ",")
+	changed := false
+	for i, p := range patterns {
+		switch {
+		case p == oldHost:
+			patterns[i] = newHost
+			changed = true
+		case strings.HasPrefix(p, "["+oldHost+"]:"):
+			patterns[i] = "[" + newHost + "]" + strings.TrimPrefix(p, "["+oldHost+"]")
+			changed = true
+		}
+	}
+	if !changed {
+		return line, false
+	}
+	fields[hostIdx] = strings.Join(patterns, ",")
+	return line[:len(line)-len(trimmed)] + strings.Join(fields, " "), true
+}
@@ -0,0 +1,47 @@
+package knownhosts
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestRenameHost(t *testing.T) {
+	t.Parallel()
+	fsys := afero.NewMemMapFs()
+	in := `# comment mentioning web.local
+web.local ssh-ed25519 AAAAkey1
+db.local,web.local,192.168.64.5 ssh-ed25519 AAAAkey2
+[web.local]:2222 ssh-rsa AAAAkey3
+@cert-authority web.local ssh-ed25519 AAAAca
+|1|hashedsalt=|hashedhost= ssh-ed25519 AAAAkey4
+web.local.example ssh-ed25519 AAAAkey5
+`
+	want := `# comment mentioning web.local
+api.local ssh-ed25519 AAAAkey1
+db.local,api.local,192.168.64.5 ssh-ed25519 AAAAkey2
+[api.local]:2222 ssh-rsa AAAAkey3
+@cert-authority api.local ssh-ed25519 AAAAca
+|1|hashedsalt=|hashedhost= ssh-ed25519 AAAAkey4
+web.local.example ssh-ed25519 AAAAkey5
+`
+	_ = afero.WriteFile(fsys, "/home/u/.ssh/known_hosts", []byte(in), 0o600)
+	n, err := RenameHost(fsys, "/home/u/.ssh/known_hosts", "web.local", "api.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("changed %d lines, want 4", n)
+	}
+	got, _ := afero.ReadFile(fsys, "/home/u/.ssh/known_hosts")
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if st, _ := fsys.Stat("/home/u/.ssh/known_hosts"); st.Mode().Perm() != 0o600 {
+		t.Errorf("mode changed to %v", st.Mode().Perm())
+	}
+
+	if n, err := RenameHost(fsys, "/missing", "a", "b"); n != 0 || err != nil {
+		t.Errorf("missing file: %d, %v", n, err)
+	}
+}
@@ -0,0 +1,27 @@
+// Package procinfo identifies processes by more than their pid, so that a pid the
+// OS has since reused for an unrelated process is not mistaken for the original.
+package procinfo
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUnsupported is returned by Lookup on platforms it does not implement.
+var ErrUnsupported = errors.New("process identity not supported on this platform")
+
+// Process identifies one incarnation of a process. Two lookups of the same pid
+// describe the same process only if both fields match.
+type Process struct {
+	PID        int       `json:"pid"`
+	StartedAt  time.Time `json:"startedAt"`
+	Executable string    `json:"executable"`
+}
+
+// Same reports whether p and q are the same process.
+func (p Process) Same(q Process) bool {
+	return p.PID == q.PID && p.StartedAt.Equal(q.StartedAt) && p.Executable == q.Executable
+}
+
+// Lookup describes the live process pid. It fails if there is no such process.
+func Lookup(pid int) (Process, error) { return lookup(pid) }
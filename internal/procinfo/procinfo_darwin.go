@@ -0,0 +1,39 @@
+//go:build darwin
+
+package procinfo
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func lookup(pid int) (Process, error) {
+	kp, err := unix.SysctlKinfoProc("kern.proc.pid", pid)
+	if err != nil {
+		return Process{}, fmt.Errorf("process %d: %w", pid, err)
+	}
+	if int(kp.Proc.P_pid) != pid {
+		return Process{}, fmt.Errorf("process %d not found", pid)
+	}
+	st := kp.Proc.P_starttime
+	p := Process{PID: pid, StartedAt: time.Unix(st.Sec, int64(st.Usec)*int64(time.Microsecond))}
+	p.Executable, err = execPath(pid)
+	return p, err
+}
+
+// execPath reads the path the process was executed from out of kern.procargs2,
+// which holds a 32-bit argc followed by the NUL-terminated path.
+func execPath(pid int) (string, error) {
+	b, err := unix.SysctlRaw("kern.procargs2", pid)
+	if err != nil {
+		return "", fmt.Errorf("process %d arguments: %w", pid, err)
+	}
+	if len(b) < 4 {
+		return "", fmt.Errorf("process %d arguments: short read", pid)
+	}
+	path, _, _ := bytes.Cut(b[4:], []byte{0})
+	return string(path), nil
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package procinfo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of /proc/<pid>/stat times; it is 100 on every
+// Linux platform Go supports.
+const clockTicks = 100
+
+func lookup(pid int) (Process, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return Process{}, fmt.Errorf("process %d: %w", pid, err)
+	}
+	// The command name is parenthesized and may itself contain spaces or parens.
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return Process{}, fmt.Errorf("process %d: malformed stat", pid)
+	}
+	// Fields after the name start at field 3 (state); starttime is field 22.
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return Process{}, fmt.Errorf("process %d: malformed stat", pid)
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return Process{}, fmt.Errorf("process %d: starttime: %w", pid, err)
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return Process{}, err
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return Process{}, fmt.Errorf("process %d executable: %w", pid, err)
+	}
+	return Process{
+		PID:        pid,
+		StartedAt:  boot.Add(time.Duration(ticks) * time.Second / clockTicks),
+		Executable: strings.TrimSuffix(exe, " (deleted)"),
+	}, nil
+}
+
+// bootTime reads btime from /proc/stat.
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "btime "); ok {
+			sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("btime: %w", err)
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("btime not found in /proc/stat")
+}
@@ -0,0 +1,5 @@
+//go:build !darwin && !linux
+
+package procinfo
+
+func lookup(pid int) (Process, error) { return Process{}, ErrUnsupported }
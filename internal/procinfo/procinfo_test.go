@@ -0,0 +1,57 @@
+package procinfo
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLookupSelf(t *testing.T) {
+	p, err := Lookup(os.Getpid())
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe, _ := os.Executable()
+	exe, _ = filepath.EvalSymlinks(exe)
+	if got, _ := filepath.EvalSymlinks(p.Executable); got != exe {
+		t.Errorf("executable = %q, want %q", p.Executable, exe)
+	}
+	if p.StartedAt.After(time.Now()) || time.Since(p.StartedAt) > time.Hour {
+		t.Errorf("implausible start time %v", p.StartedAt)
+	}
+	again, err := Lookup(os.Getpid())
+	if err != nil || !again.Same(p) {
+		t.Fatalf("second lookup differs: %+v vs %+v (%v)", again, p, err)
+	}
+}
+
+func TestLookupDistinguishesProcesses(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	child, err := Lookup(cmd.Process.Pid)
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, _ := Lookup(os.Getpid())
+	if child.Same(self) || child.Executable == self.Executable {
+		t.Fatalf("child %+v indistinguishable from %+v", child, self)
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	if _, err := Lookup(cmd.Process.Pid); err == nil {
+		t.Fatal("expected lookup of an exited process to fail")
+	}
+}
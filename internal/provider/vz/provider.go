@@ -201,22 +201,135 @@ func (p *Provider) configureRosetta(vmConfig *vz.VirtualMachineConfiguration) er
 	return nil
 }
 
+// RequestStopVM asks the guest to shut down, as if its power button was pressed.
+func (p *Provider) RequestStopVM(ctx context.Context, vm domain.VM) error {
+	p.mu.Lock()
+	h := p.handles[vm.Name]
+	p.mu.Unlock()
+	if h == nil {
+		return nil
+	}
+	if !h.CanRequestStop() {
+		return fmt.Errorf("vm %s cannot be asked to stop while %s", vm.Name, h.State())
+	}
+	_, err := h.RequestStop()
+	return err
+}
+
+// PauseVM suspends a running VM's vCPUs; its memory stays allocated.
+func (p *Provider) PauseVM(ctx context.Context, vm domain.VM) error {
+	h, err := p.handle(vm)
+	if err != nil {
+		return err
+	}
+	if !h.CanPause() {
+		return fmt.Errorf("vm %s cannot be paused while %s", vm.Name, h.State())
+	}
+	return h.Pause()
+}
+
+// ResumeVM continues a VM paused with PauseVM.
+func (p *Provider) ResumeVM(ctx context.Context, vm domain.VM) error {
+	h, err := p.handle(vm)
+	if err != nil {
+		return err
+	}
+	if !h.CanResume() {
+		return fmt.Errorf("vm %s cannot be resumed while %s", vm.Name, h.State())
+	}
+	return h.Resume()
+}
+
+// handle returns the VM's handle, failing with domain.ErrNotRunning if this
+// provider did not start it.
+func (p *Provider) handle(vm domain.VM) (*vz.VirtualMachine, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.handles[vm.Name]
+	if h == nil {
+		return nil, fmt.Errorf("vm %s: %w", vm.Name, domain.ErrNotRunning)
+	}
+	return h, nil
+}
+
+// StopVM powers the VM off and releases it. A VM the guest already shut down is
+// only released.
 func (p *Provider) StopVM(ctx context.Context, vm domain.VM) error {
 	p.mu.Lock()
 	h := p.handles[vm.Name]
 	delete(p.handles, vm.Name)
 	p.mu.Unlock()
-	if h == nil {
+	if h == nil || !h.CanStop() {
 		return nil
 	}
 	return h.Stop()
 }
 
+// IsRunning reports whether the VM has been started and not yet stopped, by the
+// guest or otherwise.
 func (p *Provider) IsRunning(ctx context.Context, vm domain.VM) (bool, error) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	_, ok := p.handles[vm.Name]
-	return ok, nil
+	h := p.handles[vm.Name]
+	p.mu.Unlock()
+	if h == nil {
+		return false, nil
+	}
+	switch h.State() {
+	case vz.VirtualMachineStateStopped, vz.VirtualMachineStateError:
+		return false, nil
+	}
+	return true, nil
+}
+
+// StateChanges forwards the state changes Virtualization.framework reports for
+// the VM, which must have been started.
+func (p *Provider) StateChanges(ctx context.Context, vm domain.VM) (<-chan domain.ProviderState, error) {
+	p.mu.Lock()
+	h := p.handles[vm.Name]
+	p.mu.Unlock()
+	if h == nil {
+		return nil, fmt.Errorf("vm %s is not started", vm.Name)
+	}
+	src := h.StateChangedNotify()
+	ch := make(chan domain.ProviderState)
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case st, ok := <-src:
+				if !ok {
+					return
+				}
+				select {
+				case ch <- providerState(st):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func providerState(st vz.VirtualMachineState) domain.ProviderState {
+	switch st {
+	case vz.VirtualMachineStateStopped:
+		return domain.ProviderStopped
+	case vz.VirtualMachineStateRunning:
+		return domain.ProviderRunning
+	case vz.VirtualMachineStatePaused, vz.VirtualMachineStatePausing, vz.VirtualMachineStateSaving:
+		return domain.ProviderPaused
+	case vz.VirtualMachineStateStopping:
+		return domain.ProviderStopping
+	case vz.VirtualMachineStateError:
+		return domain.ProviderError
+	}
+	return domain.ProviderStarting // starting, resuming or restoring
 }
 
-var _ domain.VirtualizationProvider = (*Provider)(nil)
+var (
+	_ domain.VirtualizationProvider = (*Provider)(nil)
+	_ domain.VMPauser               = (*Provider)(nil)
+)
@@ -3,48 +3,77 @@ package fs
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"os"
 
 	"github.com/alechenninger/orchard/internal/domain"
-	fsstore "github.com/alechenninger/orchard/internal/vmstore/fs"
+	"github.com/alechenninger/orchard/internal/procinfo"
 	"github.com/spf13/afero"
 )
 
 type Service struct {
 	baseDir string
 	fs      afero.Fs
+	// Clock stamps readiness stages and lock owners.
+	Clock domain.Clock
+	// Notifier, if set, wakes waiters as soon as the runtime files change.
+	Notifier Notifier
+	// PollInterval is how often waiters reread the runtime files when no Notifier
+	// is set or it fails.
+	PollInterval time.Duration
+	// LivenessInterval is how often waiters relying on a Notifier check that the
+	// shim is still alive, since a shim that dies leaves no file change behind.
+	LivenessInterval time.Duration
 }
 
-func New(baseDir string) *Service { return &Service{baseDir: baseDir, fs: afero.NewOsFs()} }
-
-func NewDefault() *Service { return New(fsstore.DefaultBaseDir()) }
+// New returns a Service that keeps runtime files under baseDir/vms/<name>. baseDir is
+// the runtime directory, which may differ from the orchard home.
+func New(baseDir string) *Service {
+	s := NewWithFS(baseDir, afero.NewOsFs())
+	s.Notifier = OSNotifier{}
+	return s
+}
 
-func NewWithFS(baseDir string, fsys afero.Fs) *Service { return &Service{baseDir: baseDir, fs: fsys} }
+// NewWithFS returns a Service on fsys. Without a Notifier, waiters poll.
+func NewWithFS(baseDir string, fsys afero.Fs) *Service {
+	return &Service{baseDir: baseDir, fs: fsys, Clock: domain.RealClock{}, PollInterval: 100 * time.Millisecond, LivenessInterval: time.Second}
+}
 
 func (s *Service) vmDir(name string) string { return filepath.Join(s.baseDir, "vms", name) }
 
 func (s *Service) paths(name string) (pid, ready, lock string) {
 	d := s.vmDir(name)
-	return filepath.Join(d, "vm.pid"), filepath.Join(d, "vm.ready"), filepath.Join(d, "vm.lock.d")
+	return filepath.Join(d, "vm.pid"), filepath.Join(d, "vm.ready"), filepath.Join(d, "vm.lock")
 }
 
-func (s *Service) AcquireLock(ctx context.Context, vmName string) (func() error, error) {
-	_, _, lock := s.paths(vmName)
-	af := &afero.Afero{Fs: s.fs}
-	if err := af.MkdirAll(s.vmDir(vmName), 0o755); err != nil {
-		return nil, err
-	}
-	if err := s.fs.Mkdir(lock, 0o755); err != nil {
-		return nil, fmt.Errorf("lock in use: %w", err)
-	}
-	return func() error { return af.RemoveAll(lock) }, nil
+// ControlSocket returns vm.sock in the VM's runtime directory.
+func (s *Service) ControlSocket(name string) string { return filepath.Join(s.vmDir(name), "vm.sock") }
+
+// exitPath is vm.exit, the record of the last shim exit.
+func (s *Service) exitPath(name string) string { return filepath.Join(s.vmDir(name), "vm.exit") }
+
+// observedPath is vm.observed, the cached last observed status.
+func (s *Service) observedPath(name string) string {
+	return filepath.Join(s.vmDir(name), "vm.observed")
 }
 
+// supervisorPath is vm.supervisor, the state of the VM's supervisor.
+func (s *Service) supervisorPath(name string) string {
+	return filepath.Join(s.vmDir(name), "vm.supervisor")
+}
+
+// legacyLockDir is the directory older releases created as a lock. It is only
+// ever cleaned up.
+func (s *Service) legacyLockDir(name string) string { return filepath.Join(s.vmDir(name), "vm.lock.d") }
+
 func (s *Service) WritePID(ctx context.Context, vmName string, pid int) error {
 	p, _, _ := s.paths(vmName)
 	af := &afero.Afero{Fs: s.fs}
@@ -61,6 +90,15 @@ func (s *Service) WritePID(ctx context.Context, vmName string, pid int) error {
 		f.Close()
 		return err
 	}
+	// The pid alone is not enough to recognize the process later: the OS may give
+	// it to an unrelated process once this one exits.
+	if proc, err := lookupProcess(pid); err == nil {
+		b, _ := json.Marshal(proc)
+		if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+			f.Close()
+			return err
+		}
+	}
 	if err := w.Flush(); err != nil {
 		f.Close()
 		return err
@@ -96,14 +134,28 @@ func (s *Service) ReadPID(ctx context.Context, vmName string) (int, error) {
 	return pid, nil
 }
 
-func (s *Service) MarkReady(ctx context.Context, vmName string) error {
+// MarkStage adds stage to vm.ready, a JSON object mapping each stage reached to
+// the time it was reached. The file is replaced atomically.
+func (s *Service) MarkStage(ctx context.Context, vmName string, stage domain.ReadinessStage) error {
 	_, r, _ := s.paths(vmName)
 	af := &afero.Afero{Fs: s.fs}
 	if err := af.MkdirAll(filepath.Dir(r), 0o755); err != nil {
 		return err
 	}
+	rd, err := s.Readiness(ctx, vmName)
+	if err != nil {
+		return err
+	}
+	if _, ok := rd[stage]; ok {
+		return nil
+	}
+	rd[stage] = s.Clock.Now().UTC()
+	b, err := json.Marshal(rd)
+	if err != nil {
+		return err
+	}
 	tmp := r + ".tmp"
-	if err := af.WriteFile(tmp, []byte(time.Now().Format(time.RFC3339Nano)), 0o644); err != nil {
+	if err := af.WriteFile(tmp, b, 0o644); err != nil {
 		return err
 	}
 	if err := s.fs.Rename(tmp, r); err != nil {
@@ -116,63 +168,340 @@ func (s *Service) MarkReady(ctx context.Context, vmName string) error {
 	return nil
 }
 
+// Readiness reads vm.ready. Older releases wrote a bare timestamp once the VM had
+// started, which is read as the vm-started stage.
+func (s *Service) Readiness(ctx context.Context, vmName string) (domain.Readiness, error) {
+	_, r, _ := s.paths(vmName)
+	b, err := afero.ReadFile(s.fs, r)
+	if errors.Is(err, os.ErrNotExist) {
+		return domain.Readiness{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rd := domain.Readiness{}
+	if err := json.Unmarshal(b, &rd); err != nil {
+		t, _ := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+		return domain.Readiness{domain.StageShimUp: t, domain.StageVMStarted: t}, nil
+	}
+	return rd, nil
+}
+
+// WriteExit replaces vm.exit atomically.
+func (s *Service) WriteExit(ctx context.Context, vmName string, exit domain.ShimExit) error {
+	return s.writeJSON(s.exitPath(vmName), exit)
+}
+
+func (s *Service) LastExit(ctx context.Context, vmName string) (*domain.ShimExit, error) {
+	var exit domain.ShimExit
+	if ok, err := s.readJSON(s.exitPath(vmName), &exit); !ok {
+		return nil, err
+	}
+	return &exit, nil
+}
+
+// SaveObserved replaces vm.observed atomically.
+func (s *Service) SaveObserved(ctx context.Context, vmName string, st domain.VMStatus) error {
+	return s.writeJSON(s.observedPath(vmName), st)
+}
+
+func (s *Service) LastObserved(ctx context.Context, vmName string) (*domain.VMStatus, error) {
+	var st domain.VMStatus
+	if ok, err := s.readJSON(s.observedPath(vmName), &st); !ok {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// writeJSON replaces the file at p with v encoded as JSON, atomically.
+func (s *Service) writeJSON(p string, v any) error {
+	af := &afero.Afero{Fs: s.fs}
+	if err := af.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := af.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return s.fs.Rename(tmp, p)
+}
+
+// readJSON decodes the file at p into v. It reports false, with a nil error, if
+// there is no such file.
+func (s *Service) readJSON(p string, v any) (bool, error) {
+	b, err := afero.ReadFile(s.fs, p)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return false, fmt.Errorf("%s: %w", p, err)
+	}
+	return true, nil
+}
+
+// supervisorRecord is the content of vm.supervisor: the state, and the identity
+// of the supervisor process so a reused pid is not taken for it.
+type supervisorRecord struct {
+	domain.SupervisorState
+	Process *procinfo.Process `json:"process,omitempty"`
+}
+
+// SetSupervisor replaces vm.supervisor atomically, or removes it if st is nil.
+func (s *Service) SetSupervisor(ctx context.Context, vmName string, st *domain.SupervisorState) error {
+	p := s.supervisorPath(vmName)
+	if st == nil {
+		if err := s.fs.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	rec := supervisorRecord{SupervisorState: *st}
+	if proc, err := lookupProcess(st.PID); err == nil {
+		rec.Process = &proc
+	}
+	return s.writeJSON(p, rec)
+}
+
+func (s *Service) Supervisor(ctx context.Context, vmName string) (*domain.SupervisorState, error) {
+	var rec supervisorRecord
+	if ok, err := s.readJSON(s.supervisorPath(vmName), &rec); !ok {
+		return nil, err
+	}
+	rec.Alive, _ = pidRecord{pid: rec.PID, proc: rec.Process}.check()
+	return &rec.SupervisorState, nil
+}
+
+// files lists every file the service may keep for a VM, including the ones Clear
+// leaves behind and temporary files of interrupted writes. The runtime directory
+// may also be the VM's directory in the orchard home, so only these are touched.
+func (s *Service) files(name string) []string {
+	p, r, l := s.paths(name)
+	var out []string
+	for _, f := range []string{p, r, l, s.ControlSocket(name), s.exitPath(name), s.supervisorPath(name), s.observedPath(name)} {
+		out = append(out, f, f+".tmp")
+	}
+	return out
+}
+
+// Remove deletes the VM's runtime files, then its runtime directory if nothing
+// else is left in it.
+func (s *Service) Remove(ctx context.Context, vmName string) error {
+	for _, f := range s.files(vmName) {
+		if err := s.fs.Remove(f); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := s.fs.RemoveAll(s.legacyLockDir(vmName)); err != nil {
+		return err
+	}
+	// Fails if the directory still holds the VM's other files, which are the store's.
+	_ = s.fs.Remove(s.vmDir(vmName))
+	return nil
+}
+
+// Rename moves the VM's runtime directory to newName, or only its runtime files if
+// the new directory already exists, as it does when the store moved a shared one.
+func (s *Service) Rename(ctx context.Context, oldName, newName string) error {
+	oldDir, newDir := s.vmDir(oldName), s.vmDir(newName)
+	if _, err := s.fs.Stat(oldDir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if _, err := s.fs.Stat(newDir); errors.Is(err, os.ErrNotExist) {
+		return s.fs.Rename(oldDir, newDir)
+	}
+	from, to := s.files(oldName), s.files(newName)
+	for i := range from {
+		if err := s.fs.Rename(from[i], to[i]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	_ = s.fs.RemoveAll(s.legacyLockDir(oldName))
+	_ = s.fs.Remove(oldDir)
+	return nil
+}
+
 func (s *Service) Clear(ctx context.Context, vmName string) error {
 	p, r, _ := s.paths(vmName)
+	_ = s.fs.Remove(s.ControlSocket(vmName))
 	_ = s.fs.Remove(p)
 	_ = s.fs.Remove(r)
 	return nil
 }
 
-func (s *Service) CleanupIfStale(ctx context.Context, vmName string) error {
-	p, r, l := s.paths(vmName)
-	f, err := s.fs.Open(p)
+// LivePID returns the pid in vm.pid if that process is still the one that wrote
+// it. If the pid now belongs to a different process, the runtime files are
+// removed, since their shim is gone.
+func (s *Service) LivePID(ctx context.Context, vmName string) (int, error) {
+	rec, err := s.readRecord(vmName)
 	if err != nil {
-		return nil
+		return 0, err
 	}
-	defer f.Close()
-	var pid int
-	if _, err := fmt.Fscan(bufio.NewReader(f), &pid); err != nil {
+	alive, reused := rec.check()
+	if reused {
+		s.removeStale(vmName)
+	}
+	if !alive {
+		return 0, os.ErrProcessDone
+	}
+	return rec.pid, nil
+}
+
+func (s *Service) CleanupIfStale(ctx context.Context, vmName string) error {
+	rec, err := s.readRecord(vmName)
+	if err != nil {
 		return nil
 	}
-	if err := syscallKill(pid, 0); err == nil {
+	if alive, _ := rec.check(); alive {
 		return nil
 	}
+	s.removeStale(vmName)
+	return nil
+}
+
+func (s *Service) removeStale(vmName string) {
+	p, r, _ := s.paths(vmName)
+	_ = s.fs.Remove(s.ControlSocket(vmName))
 	_ = s.fs.Remove(p)
 	_ = s.fs.Remove(r)
-	_ = s.fs.RemoveAll(l)
-	return nil
+	_ = s.fs.RemoveAll(s.legacyLockDir(vmName))
 }
 
-func (s *Service) WaitReadyAndPID(ctx context.Context, vmName string) (int, error) {
+func (s *Service) StalePaths(ctx context.Context, vmName string) ([]string, error) {
 	p, r, _ := s.paths(vmName)
-	deadline := time.Now().Add(15 * time.Second)
-	ticker := time.NewTicker(100 * time.Millisecond)
+	if rec, err := s.readRecord(vmName); err == nil {
+		if alive, _ := rec.check(); alive {
+			return nil, nil
+		}
+	}
+	// The lock file is never stale: the kernel drops the lock with its holder, and
+	// removing the file could let two processes lock different inodes.
+	var stale []string
+	for _, path := range []string{p, r, s.ControlSocket(vmName), s.legacyLockDir(vmName)} {
+		if _, err := s.fs.Stat(path); err == nil {
+			stale = append(stale, path)
+		}
+	}
+	return stale, nil
+}
+
+// pidRecord is the content of vm.pid: the pid on the first line, which is all
+// older releases wrote or read, then the identity of the process if it could be
+// determined.
+type pidRecord struct {
+	pid  int
+	proc *procinfo.Process
+}
+
+func (s *Service) readRecord(vmName string) (pidRecord, error) {
+	p, _, _ := s.paths(vmName)
+	b, err := afero.ReadFile(s.fs, p)
+	if err != nil {
+		return pidRecord{}, err
+	}
+	first, rest, _ := strings.Cut(string(b), "\n")
+	pid, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return pidRecord{}, fmt.Errorf("%s: %w", p, err)
+	}
+	rec := pidRecord{pid: pid}
+	if rest = strings.TrimSpace(rest); rest != "" {
+		var proc procinfo.Process
+		if err := json.Unmarshal([]byte(rest), &proc); err == nil {
+			rec.proc = &proc
+		}
+	}
+	return rec, nil
+}
+
+// check reports whether the recorded process is alive, and whether its pid has
+// been reused by a different process. Records without an identity fall back to
+// trusting any live process with the pid.
+func (r pidRecord) check() (alive, reused bool) {
+	if syscallKill(r.pid, 0) != nil {
+		return false, false
+	}
+	if r.proc == nil {
+		return true, false
+	}
+	cur, err := lookupProcess(r.pid)
+	if errors.Is(err, procinfo.ErrUnsupported) {
+		return true, false
+	}
+	if err != nil {
+		return false, false // exited since the signal check
+	}
+	if !cur.Same(*r.proc) {
+		return false, true
+	}
+	return true, false
+}
+
+// WaitStage rereads the runtime files whenever they change. Without notifications
+// it falls back to polling.
+func (s *Service) WaitStage(ctx context.Context, vmName string, stage domain.ReadinessStage) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	changes, tick := s.Changes(ctx, vmName)
+	ticker := time.NewTicker(tick)
 	defer ticker.Stop()
 	for {
-		if _, err := s.fs.Stat(r); err == nil {
-			f, err := s.fs.Open(p)
+		rec, recErr := s.readRecord(vmName)
+		if recErr == nil {
+			if alive, _ := rec.check(); !alive {
+				return 0, fmt.Errorf("shim for %s (pid %d) exited before the VM reached %s", vmName, rec.pid, stage)
+			}
+			rd, err := s.Readiness(ctx, vmName)
 			if err != nil {
 				return 0, err
 			}
-			defer f.Close()
-			var pid int
-			if _, err := fmt.Fscan(bufio.NewReader(f), &pid); err != nil {
-				return 0, err
+			if rd.Reached(stage) {
+				return rec.pid, nil
 			}
-			return pid, nil
-		}
-		if time.Now().After(deadline) {
-			return 0, fmt.Errorf("timeout waiting for readiness of %s", vmName)
 		}
 		select {
 		case <-ctx.Done():
 			return 0, ctx.Err()
+		case _, ok := <-changes:
+			if !ok {
+				// The watch failed; poll from here on.
+				changes = nil
+				ticker.Reset(s.PollInterval)
+			}
 		case <-ticker.C:
 		}
 	}
 }
 
-// small indirection for testability
-var syscallKill = func(pid int, sig int) error { return syscall.Kill(pid, syscall.Signal(sig)) }
+// Changes watches the VM's runtime files until ctx is done. It returns the change
+// notifications, which are nil if they are unavailable, and how often the caller
+// should recheck anyway: the poll interval without notifications, or the
+// liveness interval with them.
+func (s *Service) Changes(ctx context.Context, vmName string) (<-chan struct{}, time.Duration) {
+	if s.Notifier == nil {
+		return nil, s.PollInterval
+	}
+	dir := s.vmDir(vmName)
+	if err := s.fs.MkdirAll(dir, 0o755); err != nil {
+		return nil, s.PollInterval
+	}
+	ch, err := s.Notifier.Watch(ctx, dir)
+	if err != nil {
+		return nil, s.PollInterval
+	}
+	return ch, s.LivenessInterval
+}
+
+// small indirections for testability
+var (
+	syscallKill   = func(pid int, sig int) error { return syscall.Kill(pid, syscall.Signal(sig)) }
+	lookupProcess = procinfo.Lookup
+)
 
 var _ domain.RuntimeState = (*Service)(nil)
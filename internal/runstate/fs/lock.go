@@ -0,0 +1,111 @@
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/spf13/afero"
+)
+
+// fder is implemented by files backed by an OS file descriptor.
+type fder interface{ Fd() uintptr }
+
+// AcquireLock takes a flock(2) lock on vm.lock. The exclusive holder writes its
+// pid, hostname and acquisition time into the file for diagnostics; the kernel
+// releases the lock if the holder dies, leaving at most a stale description that
+// LockOwner ignores.
+func (s *Service) AcquireLock(ctx context.Context, vmName string, mode domain.LockMode) (func() error, error) {
+	_, _, lock := s.paths(vmName)
+	if err := s.fs.MkdirAll(filepath.Dir(lock), 0o755); err != nil {
+		return nil, err
+	}
+	f, fd, err := s.openLock(lock)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if mode == domain.LockExclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(fd, how|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			owner, _ := s.LockOwner(ctx, vmName)
+			return nil, &domain.LockedError{VM: vmName, Mode: mode, Owner: owner}
+		}
+		return nil, fmt.Errorf("lock %s: %w", lock, err)
+	}
+	if mode == domain.LockExclusive {
+		host, _ := os.Hostname()
+		b, _ := json.Marshal(domain.LockOwner{PID: os.Getpid(), Hostname: host, AcquiredAt: s.Clock.Now().UTC()})
+		if err := writeOwner(f, b); err != nil {
+			_ = syscall.Flock(fd, syscall.LOCK_UN)
+			f.Close()
+			return nil, err
+		}
+	}
+	return func() error {
+		if mode == domain.LockExclusive {
+			_ = f.Truncate(0)
+		}
+		_ = syscall.Flock(fd, syscall.LOCK_UN)
+		return f.Close()
+	}, nil
+}
+
+// LockOwner reports the exclusive holder recorded in vm.lock. A description left
+// by a holder that died is ignored: if a shared lock can be taken, nobody holds
+// the exclusive one.
+func (s *Service) LockOwner(ctx context.Context, vmName string) (*domain.LockOwner, error) {
+	_, _, lock := s.paths(vmName)
+	if _, err := s.fs.Stat(lock); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	f, fd, err := s.openLock(lock)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := syscall.Flock(fd, syscall.LOCK_SH|syscall.LOCK_NB); err == nil {
+		_ = syscall.Flock(fd, syscall.LOCK_UN)
+		return nil, nil
+	}
+	b, err := afero.ReadAll(f)
+	if err != nil || len(b) == 0 {
+		return nil, err
+	}
+	var o domain.LockOwner
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, fmt.Errorf("%s: %w", lock, err)
+	}
+	return &o, nil
+}
+
+func (s *Service) openLock(path string) (afero.File, int, error) {
+	f, err := s.fs.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+	fd, ok := f.(fder)
+	if !ok {
+		f.Close()
+		return nil, 0, fmt.Errorf("lock %s: runtime locks need an OS filesystem", path)
+	}
+	return f, int(fd.Fd()), nil
+}
+
+func writeOwner(f afero.File, b []byte) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(b, 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,164 @@
+package fs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+// TestLockHelperProcess is not a test: it is re-executed by lockedByHelper to hold
+// a lock in another process until that process is killed.
+func TestLockHelperProcess(t *testing.T) {
+	dir := os.Getenv("ORCHARD_TEST_LOCK_DIR")
+	if dir == "" {
+		return
+	}
+	mode := domain.LockShared
+	if os.Getenv("ORCHARD_TEST_LOCK_MODE") == "exclusive" {
+		mode = domain.LockExclusive
+	}
+	if _, err := New(dir).AcquireLock(context.Background(), "web", mode); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	fmt.Println("locked")
+	time.Sleep(time.Hour)
+}
+
+// lockedByHelper starts a helper process holding the lock of vm "web" under dir
+// and waits until it has it.
+func lockedByHelper(t *testing.T, dir string, mode domain.LockMode) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+	cmd.Env = append(os.Environ(), "ORCHARD_TEST_LOCK_DIR="+dir, "ORCHARD_TEST_LOCK_MODE="+mode.String())
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	line, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "locked" {
+		t.Fatalf("helper did not take the lock: %q, %v", line, err)
+	}
+	return cmd
+}
+
+func kill(t *testing.T, cmd *exec.Cmd) {
+	t.Helper()
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	_ = cmd.Wait()
+}
+
+func TestExclusiveLockRecordsOwnerAndDiesWithIt(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := New(dir)
+	helper := lockedByHelper(t, dir, domain.LockExclusive)
+
+	for _, mode := range []domain.LockMode{domain.LockShared, domain.LockExclusive} {
+		_, err := s.AcquireLock(ctx, "web", mode)
+		var locked *domain.LockedError
+		if !errors.As(err, &locked) || !errors.Is(err, domain.ErrLocked) {
+			t.Fatalf("%s lock while helper holds it: expected LockedError, got %v", mode, err)
+		}
+		if o := locked.Owner; o == nil || o.PID != helper.Process.Pid || o.Hostname == "" || o.AcquiredAt.IsZero() {
+			t.Fatalf("owner not recorded: %+v", o)
+		}
+	}
+
+	kill(t, helper)
+	if o, err := s.LockOwner(ctx, "web"); err != nil || o != nil {
+		t.Fatalf("expected no owner after the holder died, got %+v, %v", o, err)
+	}
+	release, err := s.AcquireLock(ctx, "web", domain.LockExclusive)
+	if err != nil {
+		t.Fatalf("lock not released when its holder was killed: %v", err)
+	}
+	if o, _ := s.LockOwner(ctx, "web"); o == nil || o.PID != os.Getpid() {
+		t.Fatalf("expected this process as owner, got %+v", o)
+	}
+	if err := release(); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := s.LockOwner(ctx, "web"); o != nil {
+		t.Fatalf("owner still reported after release: %+v", o)
+	}
+}
+
+func TestSharedLocksExcludeOnlyExclusive(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := New(dir)
+	helper := lockedByHelper(t, dir, domain.LockShared)
+
+	release, err := s.AcquireLock(ctx, "web", domain.LockShared)
+	if err != nil {
+		t.Fatalf("second shared lock: %v", err)
+	}
+	defer release()
+	_, err = s.AcquireLock(ctx, "web", domain.LockExclusive)
+	var locked *domain.LockedError
+	if !errors.As(err, &locked) || locked.Owner != nil {
+		t.Fatalf("exclusive lock over shared holders: expected LockedError without owner, got %v", err)
+	}
+
+	kill(t, helper)
+	if err := release(); err != nil {
+		t.Fatal(err)
+	}
+	release, err = s.AcquireLock(ctx, "web", domain.LockExclusive)
+	if err != nil {
+		t.Fatalf("exclusive lock after shared holders went away: %v", err)
+	}
+	_ = release()
+}
+
+func TestCleanupIfStaleKeepsLockFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := New(dir)
+	helper := lockedByHelper(t, dir, domain.LockExclusive)
+	if err := s.WritePID(ctx, "web", helper.Process.Pid); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(s.legacyLockDir("web"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	kill(t, helper)
+
+	stale, err := s.StalePaths(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, lock := s.paths("web")
+	for _, p := range stale {
+		if p == lock {
+			t.Fatalf("lock file reported as stale: %v", stale)
+		}
+	}
+	if len(stale) != 2 {
+		t.Fatalf("expected the pid file and legacy lock dir to be stale, got %v", stale)
+	}
+	if err := s.CleanupIfStale(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.legacyLockDir("web")); !os.IsNotExist(err) {
+		t.Fatalf("legacy lock dir not removed: %v", err)
+	}
+}
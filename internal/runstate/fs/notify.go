@@ -0,0 +1,29 @@
+package fs
+
+import (
+	"context"
+	"errors"
+)
+
+// Notifier reports changes to the entries of a directory. The runtime files are
+// always replaced by rename, so a change to the directory covers every update.
+type Notifier interface {
+	// Watch returns a channel that receives a value after dir changes. Bursts of
+	// changes may be coalesced into one value. The channel is closed once ctx is
+	// done or watching fails.
+	Watch(ctx context.Context, dir string) (<-chan struct{}, error)
+}
+
+// errNotifyUnsupported is returned by OSNotifier where no watching facility is implemented.
+var errNotifyUnsupported = errors.New("file change notification not supported on this platform")
+
+// OSNotifier watches directories with inotify on Linux and kqueue on macOS.
+type OSNotifier struct{}
+
+// signal performs a non-blocking send, coalescing with a pending notification.
+func signal(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,75 @@
+//go:build darwin
+
+package fs
+
+import (
+	"context"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func (OSNotifier) Watch(ctx context.Context, dir string) (<-chan struct{}, error) {
+	dfd, err := unix.Open(dir, unix.O_EVTONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+	kq, err := unix.Kqueue()
+	if err != nil {
+		unix.Close(dfd)
+		return nil, os.NewSyscallError("kqueue", err)
+	}
+	// A pipe registered in the same kqueue wakes the blocked Kevent on cancellation.
+	var wake [2]int
+	if err := unix.Pipe(wake[:]); err != nil {
+		unix.Close(dfd)
+		unix.Close(kq)
+		return nil, os.NewSyscallError("pipe", err)
+	}
+	var changes [2]unix.Kevent_t
+	unix.SetKevent(&changes[0], dfd, unix.EVFILT_VNODE, unix.EV_ADD|unix.EV_CLEAR)
+	changes[0].Fflags = unix.NOTE_WRITE | unix.NOTE_DELETE | unix.NOTE_RENAME
+	unix.SetKevent(&changes[1], wake[0], unix.EVFILT_READ, unix.EV_ADD)
+	if _, err := unix.Kevent(kq, changes[:], nil, nil); err != nil {
+		for _, fd := range []int{dfd, kq, wake[0], wake[1]} {
+			unix.Close(fd)
+		}
+		return nil, os.NewSyscallError("kevent", err)
+	}
+	ch := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(ctx)
+	woke := make(chan struct{})
+	go func() {
+		defer close(woke)
+		<-ctx.Done()
+		_, _ = unix.Write(wake[1], []byte{0})
+	}()
+	go func() {
+		defer close(ch)
+		// Close the descriptors only once the waker is done with the pipe.
+		defer func() {
+			cancel()
+			<-woke
+			for _, fd := range []int{dfd, kq, wake[0], wake[1]} {
+				unix.Close(fd)
+			}
+		}()
+		events := make([]unix.Kevent_t, 4)
+		for {
+			n, err := unix.Kevent(kq, nil, events, nil)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			for _, ev := range events[:n] {
+				if int(ev.Ident) == wake[0] {
+					return
+				}
+			}
+			signal(ch)
+		}
+	}()
+	return ch, nil
+}
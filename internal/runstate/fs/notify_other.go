@@ -0,0 +1,9 @@
+//go:build !darwin && !linux
+
+package fs
+
+import "context"
+
+func (OSNotifier) Watch(ctx context.Context, dir string) (<-chan struct{}, error) {
+	return nil, errNotifyUnsupported
+}
@@ -0,0 +1,123 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/spf13/afero"
+)
+
+// fakeNotifier hands out a channel the test signals or closes by hand.
+type fakeNotifier struct {
+	ch      chan struct{}
+	watched chan string
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{ch: make(chan struct{}, 1), watched: make(chan string, 1)}
+}
+
+func (f *fakeNotifier) Watch(ctx context.Context, dir string) (<-chan struct{}, error) {
+	f.watched <- dir
+	return f.ch, nil
+}
+
+// waitInBackground runs WaitStage and returns a channel with its error.
+func waitInBackground(s *Service, stage domain.ReadinessStage) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.WaitStage(context.Background(), "web", stage)
+		done <- err
+	}()
+	return done
+}
+
+func TestWaitStageWakesOnNotification(t *testing.T) {
+	ctx := context.Background()
+	s := NewWithFS("/run", afero.NewMemMapFs())
+	n := newFakeNotifier()
+	s.Notifier, s.PollInterval, s.LivenessInterval = n, time.Hour, time.Hour
+	if err := s.WritePID(ctx, "web", os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := waitInBackground(s, domain.StageVMStarted)
+	if dir := <-n.watched; dir != s.vmDir("web") {
+		t.Fatalf("watched %s, want %s", dir, s.vmDir("web"))
+	}
+	if err := s.MarkStage(ctx, "web", domain.StageVMStarted); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("WaitStage returned without a notification: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	n.ch <- struct{}{}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitStage did not wake on notification")
+	}
+}
+
+func TestWaitStageFallsBackToPolling(t *testing.T) {
+	ctx := context.Background()
+	s := NewWithFS("/run", afero.NewMemMapFs())
+	n := newFakeNotifier()
+	s.Notifier, s.PollInterval, s.LivenessInterval = n, 10*time.Millisecond, time.Hour
+	if err := s.WritePID(ctx, "web", os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := waitInBackground(s, domain.StageVMStarted)
+	<-n.watched
+	close(n.ch) // the watch failed
+	if err := s.MarkStage(ctx, "web", domain.StageVMStarted); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitStage did not fall back to polling")
+	}
+}
+
+func TestOSNotifierReportsRenames(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := OSNotifier{}.Watch(ctx, dir)
+	if errors.Is(err, errNotifyUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(dir, "vm.ready.tmp")
+	if err := os.WriteFile(tmp, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "vm.ready")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification for a change in the watched directory")
+	}
+	cancel()
+	for range ch {
+		// drain until the watcher shuts down and closes the channel
+	}
+}
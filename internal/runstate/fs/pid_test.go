@@ -0,0 +1,111 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/procinfo"
+)
+
+func TestLivePIDVerifiesProcessIdentity(t *testing.T) {
+	ctx := context.Background()
+	s := New(t.TempDir())
+	self, err := procinfo.Lookup(os.Getpid())
+	if errors.Is(err, procinfo.ErrUnsupported) {
+		t.Skip(err)
+	}
+	pidPath, readyPath, _ := s.paths("web")
+
+	if err := s.WritePID(ctx, "web", os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := s.LivePID(ctx, "web"); err != nil || pid != os.Getpid() {
+		t.Fatalf("LivePID of this process = %d, %v", pid, err)
+	}
+	if rec, _ := s.readRecord("web"); rec.proc == nil || !rec.proc.Same(self) {
+		t.Fatalf("identity not recorded: %+v", rec.proc)
+	}
+
+	// A record written by an older release carries no identity and is trusted.
+	if err := os.WriteFile(pidPath, fmt.Appendf(nil, "%d\n", os.Getpid()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := s.LivePID(ctx, "web"); err != nil || pid != os.Getpid() {
+		t.Fatalf("LivePID of legacy record = %d, %v", pid, err)
+	}
+
+	// The same pid started at another time is a different process.
+	reused := fmt.Sprintf("%d\n{\"pid\":%d,\"startedAt\":%q,\"executable\":%q}\n",
+		os.Getpid(), os.Getpid(), self.StartedAt.Add(-time.Hour).Format(time.RFC3339Nano), self.Executable)
+	if err := os.WriteFile(pidPath, []byte(reused), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkStage(ctx, "web", domain.StageVMStarted); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LivePID(ctx, "web"); !errors.Is(err, os.ErrProcessDone) {
+		t.Fatalf("expected reused pid to be rejected, got %v", err)
+	}
+	for _, p := range []string{pidPath, readyPath} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s not cleaned up after pid reuse: %v", p, err)
+		}
+	}
+}
+
+func TestLivePIDKeepsRecordOfDeadProcess(t *testing.T) {
+	ctx := context.Background()
+	s := New(t.TempDir())
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	if err := s.WritePID(ctx, "web", cmd.Process.Pid); err != nil {
+		t.Fatal(err)
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+
+	if _, err := s.LivePID(ctx, "web"); !errors.Is(err, os.ErrProcessDone) {
+		t.Fatalf("expected dead process, got %v", err)
+	}
+	// A dead shim's files are evidence of a crash and are left for status to report.
+	if pid, err := s.ReadPID(ctx, "web"); err != nil || pid != cmd.Process.Pid {
+		t.Fatalf("pid record of a dead process removed: %d, %v", pid, err)
+	}
+}
+
+func TestSupervisorStateTracksLiveness(t *testing.T) {
+	ctx := context.Background()
+	s := New(t.TempDir())
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer func() { _ = cmd.Process.Kill(); _ = cmd.Wait() }()
+	want := domain.SupervisorState{PID: cmd.Process.Pid, Restarts: 3, GaveUp: "restart limit of 3 reached"}
+	if err := s.SetSupervisor(ctx, "web", &want); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := s.Supervisor(ctx, "web"); err != nil || st == nil || !st.Alive || st.Restarts != 3 || st.GaveUp != want.GaveUp {
+		t.Fatalf("Supervisor of a live process = %+v, %v", st, err)
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	// The state of a supervisor that exited is kept for status to report.
+	if st, err := s.Supervisor(ctx, "web"); err != nil || st == nil || st.Alive || st.Restarts != 3 {
+		t.Fatalf("Supervisor of a dead process = %+v, %v", st, err)
+	}
+	if err := s.SetSupervisor(ctx, "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := s.Supervisor(ctx, "web"); err != nil || st != nil {
+		t.Fatalf("Supervisor after removal = %+v, %v", st, err)
+	}
+}
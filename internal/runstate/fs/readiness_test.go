@@ -0,0 +1,76 @@
+package fs
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+type fixedClock struct{ t time.Time }
+
+func (c fixedClock) Now() time.Time { return c.t }
+
+func TestMarkStageAndWait(t *testing.T) {
+	ctx := context.Background()
+	s := New(t.TempDir())
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Clock = fixedClock{t0}
+	if err := s.WritePID(ctx, "web", os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range []domain.ReadinessStage{domain.StageShimUp, domain.StageVMStarted} {
+		if err := s.MarkStage(ctx, "web", st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rd, err := s.Readiness(ctx, "web")
+	if err != nil || rd.Stage() != domain.StageVMStarted || !rd[domain.StageShimUp].Equal(t0) {
+		t.Fatalf("Readiness = %v, %v", rd, err)
+	}
+	if pid, err := s.WaitStage(ctx, "web", domain.StageShimUp); err != nil || pid != os.Getpid() {
+		t.Fatalf("WaitStage for a reached stage = %d, %v", pid, err)
+	}
+	wctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := s.WaitStage(wctx, "web", domain.StageSSHReachable); err != context.DeadlineExceeded {
+		t.Fatalf("WaitStage for an unreached stage: expected deadline, got %v", err)
+	}
+}
+
+func TestWaitStageFailsWhenShimDies(t *testing.T) {
+	ctx := context.Background()
+	s := New(t.TempDir())
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	if err := s.WritePID(ctx, "web", cmd.Process.Pid); err != nil {
+		t.Fatal(err)
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := s.WaitStage(wctx, "web", domain.StageVMStarted); err == nil || !strings.Contains(err.Error(), "exited") {
+		t.Fatalf("expected WaitStage to fail fast, got %v", err)
+	}
+}
+
+func TestReadinessReadsLegacyMarker(t *testing.T) {
+	ctx := context.Background()
+	s := New(t.TempDir())
+	_, ready, _ := s.paths("web")
+	_ = os.MkdirAll(s.vmDir("web"), 0o755)
+	if err := os.WriteFile(ready, []byte("2025-01-01T00:00:00Z"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rd, err := s.Readiness(ctx, "web")
+	if err != nil || rd.Stage() != domain.StageVMStarted {
+		t.Fatalf("legacy marker read as %v, %v", rd, err)
+	}
+}
@@ -0,0 +1,100 @@
+package selector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const maxNameLength = 63
+
+// ValidateKey checks a label key: an optional DNS-subdomain prefix and a slash,
+// followed by a name of at most 63 alphanumerics, '-', '_' or '.', beginning and
+// ending with an alphanumeric.
+func ValidateKey(key string) error {
+	name := key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		prefix := key[:i]
+		name = key[i+1:]
+		if prefix == "" || len(prefix) > 253 {
+			return fmt.Errorf("invalid label key %q: prefix must be 1-253 characters", key)
+		}
+		for _, part := range strings.Split(prefix, ".") {
+			if !isName(part, false) {
+				return fmt.Errorf("invalid label key %q: prefix must be a DNS subdomain", key)
+			}
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("invalid label key %q: name must not be empty", key)
+	}
+	if !isName(name, true) {
+		return fmt.Errorf("invalid label key %q: name must be at most %d alphanumerics, '-', '_' or '.', starting and ending with an alphanumeric", key, maxNameLength)
+	}
+	return nil
+}
+
+// ValidateValue checks a label value: empty, or at most 63 alphanumerics, '-', '_'
+// or '.', beginning and ending with an alphanumeric.
+func ValidateValue(value string) error {
+	if value == "" || isName(value, true) {
+		return nil
+	}
+	return fmt.Errorf("invalid label value %q: must be at most %d alphanumerics, '-', '_' or '.', starting and ending with an alphanumeric", value, maxNameLength)
+}
+
+// ParseLabels parses "key=value" assignments into a label set.
+func ParseLabels(assignments []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, a := range assignments {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", a)
+		}
+		if err := ValidateKey(k); err != nil {
+			return nil, err
+		}
+		if err := ValidateValue(v); err != nil {
+			return nil, err
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// isName reports whether s is a label-style name; symbols allows '_' and '.' in
+// addition to '-' (DNS labels only allow '-').
+func isName(s string, symbols bool) bool {
+	if s == "" || len(s) > maxNameLength {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		alnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if alnum {
+			continue
+		}
+		if i == 0 || i == len(s)-1 {
+			return false
+		}
+		if c == '-' || symbols && (c == '_' || c == '.') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// FormatLabels renders labels as sorted k=v pairs separated by commas.
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + labels[k]
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,217 @@
+// Package selector implements Kubernetes-style label selectors for VMs.
+//
+// A selector is a comma-separated list of requirements, all of which must match:
+//
+//	key=value, key==value   label present with the given value
+//	key!=value              label absent or with a different value
+//	key in (v1,v2)          label present with one of the values
+//	key notin (v1,v2)       label absent or with none of the values
+//	key                     label present
+//	!key                    label absent
+package selector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Operator is the comparison a Requirement applies.
+type Operator string
+
+const (
+	Equals       Operator = "="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+// Requirement is a single condition on one label key.
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Matches reports whether labels satisfy the requirement.
+func (r Requirement) Matches(labels map[string]string) bool {
+	v, ok := labels[r.Key]
+	switch r.Operator {
+	case Equals:
+		return ok && v == r.Values[0]
+	case NotEquals:
+		return !ok || v != r.Values[0]
+	case In:
+		return ok && contains(r.Values, v)
+	case NotIn:
+		return !ok || !contains(r.Values, v)
+	case Exists:
+		return ok
+	case DoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+func (r Requirement) String() string {
+	switch r.Operator {
+	case Exists:
+		return r.Key
+	case DoesNotExist:
+		return "!" + r.Key
+	case In, NotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ","))
+	}
+	return r.Key + string(r.Operator) + r.Values[0]
+}
+
+// Selector matches label sets against all of its requirements.
+// The zero value has no requirements and matches everything.
+type Selector struct {
+	Requirements []Requirement
+}
+
+// FirstEquality returns the key and value of the first key=value requirement, which
+// an indexed store can use to narrow its candidates before Matches is applied.
+func (s Selector) FirstEquality() (key, value string, ok bool) {
+	for _, r := range s.Requirements {
+		if r.Operator == Equals {
+			return r.Key, r.Values[0], true
+		}
+	}
+	return "", "", false
+}
+
+// Matches reports whether labels satisfy every requirement.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s.Requirements {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty reports whether the selector has no requirements.
+func (s Selector) Empty() bool { return len(s.Requirements) == 0 }
+
+func (s Selector) String() string {
+	parts := make([]string, len(s.Requirements))
+	for i, r := range s.Requirements {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parse parses a selector expression. An empty string yields an empty selector.
+func Parse(expr string) (Selector, error) {
+	var sel Selector
+	for _, term := range splitTerms(expr) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			return Selector{}, fmt.Errorf("invalid selector %q: empty requirement", expr)
+		}
+		r, err := parseRequirement(term)
+		if err != nil {
+			return Selector{}, fmt.Errorf("invalid selector %q: %w", expr, err)
+		}
+		sel.Requirements = append(sel.Requirements, r)
+	}
+	return sel, nil
+}
+
+// splitTerms splits on commas that are not inside a parenthesized value set.
+func splitTerms(expr string) []string {
+	if strings.TrimSpace(expr) == "" {
+		return nil
+	}
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range expr {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, expr[start:])
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	if strings.HasPrefix(term, "!") {
+		key := strings.TrimSpace(term[1:])
+		if err := ValidateKey(key); err != nil {
+			return Requirement{}, err
+		}
+		return Requirement{Key: key, Operator: DoesNotExist}, nil
+	}
+	if i := strings.Index(term, "!="); i >= 0 {
+		return binary(term[:i], NotEquals, term[i+2:])
+	}
+	if i := strings.Index(term, "=="); i >= 0 {
+		return binary(term[:i], Equals, term[i+2:])
+	}
+	if i := strings.Index(term, "="); i >= 0 {
+		return binary(term[:i], Equals, term[i+1:])
+	}
+	if fields := strings.Fields(term); len(fields) >= 2 {
+		op := Operator(fields[1])
+		if op != In && op != NotIn {
+			return Requirement{}, fmt.Errorf("unknown operator %q in %q", fields[1], term)
+		}
+		key := fields[0]
+		if err := ValidateKey(key); err != nil {
+			return Requirement{}, err
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(term[len(key):]), fields[1]))
+		if !strings.HasPrefix(rest, "(") || !strings.HasSuffix(rest, ")") {
+			return Requirement{}, fmt.Errorf("expected parenthesized values after %q in %q", op, term)
+		}
+		var values []string
+		for _, v := range strings.Split(rest[1:len(rest)-1], ",") {
+			v = strings.TrimSpace(v)
+			if err := ValidateValue(v); err != nil {
+				return Requirement{}, err
+			}
+			values = append(values, v)
+		}
+		if len(values) == 0 || (len(values) == 1 && values[0] == "") {
+			return Requirement{}, fmt.Errorf("empty value set in %q", term)
+		}
+		sort.Strings(values)
+		return Requirement{Key: key, Operator: op, Values: values}, nil
+	}
+	key := strings.TrimSpace(term)
+	if err := ValidateKey(key); err != nil {
+		return Requirement{}, err
+	}
+	return Requirement{Key: key, Operator: Exists}, nil
+}
+
+func binary(key string, op Operator, value string) (Requirement, error) {
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if err := ValidateKey(key); err != nil {
+		return Requirement{}, err
+	}
+	if err := ValidateValue(value); err != nil {
+		return Requirement{}, err
+	}
+	return Requirement{Key: key, Operator: op, Values: []string{value}}, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
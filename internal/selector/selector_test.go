@@ -0,0 +1,101 @@
+package selector
+
+import "testing"
+
+func TestParseAndMatch(t *testing.T) {
+	t.Parallel()
+	labels := map[string]string{"team": "payments", "env": "test", "example.com/tier": "db", "empty": ""}
+	cases := []struct {
+		expr  string
+		match bool
+	}{
+		{"", true},
+		{"team=payments", true},
+		{"team==payments", true},
+		{"team=search", false},
+		{"team!=search", true},
+		{"team!=payments", false},
+		{"owner!=alice", true},
+		{"env in (test,prod)", true},
+		{"env in (prod)", false},
+		{"owner in (alice)", false},
+		{"env notin (prod, staging)", true},
+		{"env notin (test)", false},
+		{"owner notin (alice)", true},
+		{"team", true},
+		{"owner", false},
+		{"!owner", true},
+		{"!team", false},
+		{"empty=", true},
+		{"empty", true},
+		{"example.com/tier=db", true},
+		{"team=payments,env=test", true},
+		{"team=payments, env in (prod,test), !owner", true},
+		{"team=payments,env=prod", false},
+		{" team = payments ", true},
+	}
+	for _, c := range cases {
+		sel, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.expr, err)
+			continue
+		}
+		if got := sel.Matches(labels); got != c.match {
+			t.Errorf("%q.Matches = %v, want %v", c.expr, got, c.match)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+	for _, expr := range []string{
+		",",
+		"team=payments,",
+		"=payments",
+		"team=pay ments",
+		"-team=payments",
+		"team=-payments",
+		"env in test",
+		"env in ()",
+		"env in (test",
+		"env within (test)",
+		"!",
+		"!team=payments",
+		"/team=payments",
+		"example.com/=x",
+		"team=" + string(make([]byte, 64)),
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q): expected error", expr)
+		}
+	}
+}
+
+func TestStringRoundTrips(t *testing.T) {
+	t.Parallel()
+	for _, expr := range []string{"team=payments", "team!=payments", "env in (prod,test)", "env notin (prod)", "team", "!team", "a=b,!c"} {
+		sel, err := Parse(expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", expr, err)
+		}
+		if sel.String() != expr {
+			t.Errorf("String() = %q, want %q", sel.String(), expr)
+		}
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	t.Parallel()
+	got, err := ParseLabels([]string{"team=payments", "env=test", "team=search"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["team"] != "search" || got["env"] != "test" {
+		t.Fatalf("unexpected labels: %v", got)
+	}
+	for _, bad := range []string{"team", "=x", "team=a b", "team/=x"} {
+		if _, err := ParseLabels([]string{bad}); err == nil {
+			t.Errorf("ParseLabels(%q): expected error", bad)
+		}
+	}
+}
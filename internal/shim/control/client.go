@@ -0,0 +1,93 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+// Client is a connection to a shim's control socket.
+type Client struct {
+	rpc *rpc.Client
+}
+
+// Dial connects to the control socket at path. A missing socket or one nobody
+// listens on fails with domain.ErrNoControl.
+func Dial(ctx context.Context, path string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrNoControl, err)
+	}
+	return &Client{rpc: jsonrpc.NewClient(conn)}, nil
+}
+
+func (c *Client) Close() error { return c.rpc.Close() }
+
+func (c *Client) Status(ctx context.Context) (domain.ShimInfo, error) {
+	var info domain.ShimInfo
+	err := c.call(ctx, "Status", Empty{}, &info)
+	return info, err
+}
+
+func (c *Client) Stop(ctx context.Context, opts domain.StopOptions) error {
+	return c.call(ctx, "Stop", opts, &Empty{})
+}
+
+func (c *Client) Pause(ctx context.Context) error { return c.call(ctx, "Pause", Empty{}, &Empty{}) }
+
+func (c *Client) Resume(ctx context.Context) error { return c.call(ctx, "Resume", Empty{}, &Empty{}) }
+
+func (c *Client) Readiness(ctx context.Context) (domain.Readiness, error) {
+	rd := domain.Readiness{}
+	err := c.call(ctx, "Readiness", Empty{}, &rd)
+	return rd, err
+}
+
+func (c *Client) GuestInfo(ctx context.Context) (domain.GuestInfo, error) {
+	var info domain.GuestInfo
+	err := c.call(ctx, "GuestInfo", Empty{}, &info)
+	return info, err
+}
+
+// call invokes method of the current protocol version, giving up when ctx is done.
+func (c *Client) call(ctx context.Context, method string, args, reply any) error {
+	call := c.rpc.Go(serviceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-call.Done:
+		return remoteError(call.Error)
+	}
+}
+
+// remoteError restores the domain sentinels a shim's errors wrap, which net/rpc
+// flattens into strings, and reports a shim that closed the connection or speaks
+// another protocol version as domain.ErrNoControl.
+func remoteError(err error) error {
+	var se rpc.ServerError
+	if !errors.As(err, &se) {
+		if errors.Is(err, rpc.ErrShutdown) {
+			return fmt.Errorf("%w: %v", domain.ErrNoControl, err)
+		}
+		return err
+	}
+	msg := string(se)
+	if strings.HasPrefix(msg, "rpc: can't find service") {
+		return fmt.Errorf("%w: shim does not speak protocol version %d", domain.ErrNoControl, domain.ShimProtocolVersion)
+	}
+	for _, sentinel := range []error{domain.ErrNotSupported, domain.ErrNotRunning} {
+		if prefix, ok := strings.CutSuffix(msg, sentinel.Error()); ok {
+			return fmt.Errorf("%s%w", prefix, sentinel)
+		}
+	}
+	return errors.New(msg)
+}
+
+var _ domain.ShimControl = (*Client)(nil)
@@ -0,0 +1,130 @@
+// Package control implements the protocol a shim serves on its control socket.
+//
+// The protocol is JSON-RPC 1.0 as implemented by net/rpc/jsonrpc, one request
+// object per call on a unix socket. Methods are registered under a service named
+// for the protocol version, e.g. "ShimV1.Status", so a client never misreads the
+// replies of a shim speaking another version: it gets an unknown-service error.
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+// serviceName is the service the current protocol version is registered as.
+var serviceName = fmt.Sprintf("ShimV%d", domain.ShimProtocolVersion)
+
+// Handler performs the requests a shim receives over its control socket.
+type Handler interface {
+	Status(ctx context.Context) (domain.ShimInfo, error)
+	Stop(ctx context.Context, opts domain.StopOptions) error
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+	Readiness(ctx context.Context) (domain.Readiness, error)
+	GuestInfo(ctx context.Context) (domain.GuestInfo, error)
+}
+
+// Empty is the argument or reply of methods that take or return nothing.
+type Empty struct{}
+
+// service adapts a Handler to the method signatures net/rpc requires.
+type service struct {
+	ctx context.Context
+	h   Handler
+}
+
+func (s *service) Status(_ Empty, reply *domain.ShimInfo) (err error) {
+	*reply, err = s.h.Status(s.ctx)
+	return err
+}
+
+func (s *service) Stop(opts domain.StopOptions, _ *Empty) error { return s.h.Stop(s.ctx, opts) }
+
+func (s *service) Pause(_ Empty, _ *Empty) error { return s.h.Pause(s.ctx) }
+
+func (s *service) Resume(_ Empty, _ *Empty) error { return s.h.Resume(s.ctx) }
+
+func (s *service) Readiness(_ Empty, reply *domain.Readiness) (err error) {
+	*reply, err = s.h.Readiness(s.ctx)
+	return err
+}
+
+func (s *service) GuestInfo(_ Empty, reply *domain.GuestInfo) (err error) {
+	*reply, err = s.h.GuestInfo(s.ctx)
+	return err
+}
+
+// Listen creates the control socket at path, replacing any left behind by a shim
+// that died. The caller must hold the VM's exclusive lock, so no live shim can be
+// listening there.
+func Listen(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// Serve answers requests on l with h until ctx is done. It then closes l and stops
+// reading from open connections, and returns once replies to the requests already
+// read, such as the stop that ended the shim, have been sent.
+func Serve(ctx context.Context, l net.Listener, h Handler) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(serviceName, &service{ctx: ctx, h: h}); err != nil {
+		return err
+	}
+	var (
+		mu    sync.Mutex
+		conns = map[net.Conn]struct{}{}
+		wg    sync.WaitGroup
+	)
+	shutdown := func() {
+		l.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for c := range conns {
+			_ = c.SetReadDeadline(time.Now())
+		}
+	}
+	stop := context.AfterFunc(ctx, shutdown)
+	defer func() {
+		stop()
+		shutdown()
+		wg.Wait()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		mu.Lock()
+		if ctx.Err() != nil {
+			mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		conns[conn] = struct{}{}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// ServeCodec closes conn after sending the replies still pending when a
+			// read fails.
+			srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+		}()
+	}
+}
@@ -8,31 +8,40 @@ import (
 	"syscall"
 
 	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/shim/control"
+	"github.com/alechenninger/orchard/internal/shimlog"
 )
 
 type Manager struct {
 	store domain.VMStore
 	run   domain.RuntimeState
+	// Env is added to the shim's environment, e.g. to pass on the orchard home.
+	Env []string
 }
 
 func New(store domain.VMStore, run domain.RuntimeState) *Manager {
 	return &Manager{store: store, run: run}
 }
 
-// StartDetached re-execs this binary with the hidden _shim subcommand.
+// StartDetached re-execs this binary with the hidden _shim subcommand, or with
+// _supervise for a VM whose restart policy needs a supervisor to run its shim.
 func (m *Manager) StartDetached(ctx context.Context, vm domain.VM) (int, error) {
 	// Clean up any stale runtime files from a previous crashed shim
 	_ = m.run.CleanupIfStale(ctx, vm.Name)
 
-	exe, err := os.Executable()
+	sub := "_shim"
+	if vm.RestartPolicy.Enabled() {
+		sub = "_supervise"
+	} else if err := m.run.SetSupervisor(ctx, vm.Name, nil); err != nil {
+		// Restarts counted by an earlier supervisor no longer apply.
+		return 0, err
+	}
+	cmd, err := m.command(vm, sub)
 	if err != nil {
 		return 0, err
 	}
-	// Use exec.Command (not CommandContext) so child lifetime is not tied to parent ctx
-	cmd := exec.Command(exe, "_shim", "--vm", vm.Name)
+	defer closeOutput(cmd)
 	// Detach from parent's process group
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	if err := cmd.Start(); err != nil {
 		return 0, err
@@ -40,13 +49,72 @@ func (m *Manager) StartDetached(ctx context.Context, vm domain.VM) (int, error)
 	return cmd.Process.Pid, nil
 }
 
-func (m *Manager) Stop(ctx context.Context, pid int) error {
+// LaunchShim starts a shim for vm as a child of this process, as a supervisor does.
+func (m *Manager) LaunchShim(ctx context.Context, vm domain.VM) (ShimProcess, error) {
+	cmd, err := m.command(vm, "_shim")
+	if err != nil {
+		return nil, err
+	}
+	defer closeOutput(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmdProcess{cmd}, nil
+}
+
+// command prepares this binary to run the hidden subcommand sub for vm.
+func (m *Manager) command(vm domain.VM, sub string) (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	// Use exec.Command (not CommandContext) so child lifetime is not tied to parent ctx
+	cmd := exec.Command(exe, sub, "--vm", vm.Name)
+	cmd.Env = append(os.Environ(), m.Env...)
+	// The shim outlives this process, so its output goes to its log rather than
+	// to our terminal. It takes the log over once it is up; this catches anything
+	// it prints before then.
+	logf, err := os.OpenFile(shimlog.Path(vm), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = logf
+	cmd.Stderr = logf
+	return cmd, nil
+}
+
+// closeOutput closes this process's copy of the log a command was started with.
+func closeOutput(cmd *exec.Cmd) {
+	if f, ok := cmd.Stdout.(*os.File); ok {
+		_ = f.Close()
+	}
+}
+
+// cmdProcess is a shim started with LaunchShim.
+type cmdProcess struct{ cmd *exec.Cmd }
+
+func (p cmdProcess) Pid() int { return p.cmd.Process.Pid }
+
+func (p cmdProcess) Wait() int {
+	_ = p.cmd.Wait()
+	return p.cmd.ProcessState.ExitCode()
+}
+
+func (p cmdProcess) Terminate() error { return p.cmd.Process.Signal(syscall.SIGTERM) }
+
+func (m *Manager) Stop(ctx context.Context, pid int, force bool) error {
 	p, err := os.FindProcess(pid)
 	if err != nil {
 		return err
 	}
-	// Best-effort SIGTERM
-	if err := p.Signal(syscall.SIGTERM); err != nil {
+	// A shim may not handle any other signal, such as one from an older release,
+	// so a forced stop kills it, and the VM it runs with it.
+	sig := syscall.SIGTERM
+	if force {
+		sig = syscall.SIGKILL
+	}
+	// Best-effort signal
+	if err := p.Signal(sig); err != nil {
 		// Tolerate already-finished or missing process
 		if errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH) {
 			return nil
@@ -58,18 +126,19 @@ func (m *Manager) Stop(ctx context.Context, pid int) error {
 
 var _ domain.ShimProcessManager = (*Manager)(nil)
 
-func (m *Manager) WaitReadyAndPID(ctx context.Context, vmName string) (int, error) {
-	return m.run.WaitReadyAndPID(ctx, vmName)
+func (m *Manager) WaitStage(ctx context.Context, vmName string, stage domain.ReadinessStage) (int, error) {
+	return m.run.WaitStage(ctx, vmName, stage)
 }
 
 func (m *Manager) GetPID(ctx context.Context, vmName string) (int, error) {
-	pid, err := m.run.ReadPID(ctx, vmName)
+	return m.run.LivePID(ctx, vmName)
+}
+
+// Control connects to the shim's control socket.
+func (m *Manager) Control(ctx context.Context, vmName string) (domain.ShimControl, error) {
+	c, err := control.Dial(ctx, m.run.ControlSocket(vmName))
 	if err != nil {
-		return 0, err
-	}
-	// Verify process is alive
-	if err := syscall.Kill(pid, 0); err != nil {
-		return 0, os.ErrProcessDone
+		return nil, err
 	}
-	return pid, nil
+	return c, nil
 }
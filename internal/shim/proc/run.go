@@ -2,17 +2,29 @@ package proc
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"runtime"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/shim/control"
 )
 
+// probeInterval is how often the shim probes for the next guest readiness stage.
+var probeInterval = time.Second
+
 // RunChild is invoked in the _shim process to own the VM lifecycle.
-func RunChild(ctx context.Context, store domain.VMStore, run domain.RuntimeState, provider domain.VirtualizationProvider, name string) error {
+// Lifecycle transitions it observes are appended to events, stamped by clock, and
+// readiness stages are marked in run as they are reached; guest stages are only
+// observed if probe is non-nil. The shim serves control requests on run's control socket for the
+// VM, and stops when asked to there, on SIGTERM or SIGINT, when ctx is done, or
+// when the VM stops by itself. Why it stopped is recorded with run.WriteExit.
+func RunChild(ctx context.Context, store domain.VMStore, run domain.RuntimeState, provider domain.VirtualizationProvider, probe domain.GuestProber, events domain.EventJournal, clock domain.Clock, name string) error {
 	// Virtualization.framework APIs require running on the main thread
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
@@ -21,7 +33,7 @@ func RunChild(ctx context.Context, store domain.VMStore, run domain.RuntimeState
 		return err
 	}
 	// Acquire lock
-	release, err := run.AcquireLock(ctx, vm.Name)
+	release, err := run.AcquireLock(ctx, vm.Name, domain.LockExclusive)
 	if err != nil {
 		return err
 	}
@@ -31,16 +43,66 @@ func RunChild(ctx context.Context, store domain.VMStore, run domain.RuntimeState
 	if err := run.WritePID(ctx, vm.Name, os.Getpid()); err != nil {
 		return err
 	}
+	c := &child{vm: *vm, run: run, provider: provider, probe: probe, startedAt: clock.Now().UTC(), stopReq: make(chan domain.StopOptions, 1), forced: make(chan struct{})}
+	// Serve control requests from the start, so the VM can be stopped while it boots.
+	sctx, stopServing := context.WithCancel(ctx)
+	served := make(chan struct{})
+	if l, err := control.Listen(run.ControlSocket(vm.Name)); err != nil {
+		slog.Warn("control socket unavailable; the shim only stops on a signal", "vm", vm.Name, "error", err)
+		close(served)
+	} else {
+		go func() {
+			defer close(served)
+			if err := control.Serve(sctx, l, c); err != nil {
+				slog.Warn("control socket failed", "vm", vm.Name, "error", err)
+			}
+		}()
+	}
+	defer func() {
+		stopServing()
+		<-served
+	}()
+
+	if err := run.MarkStage(ctx, vm.Name, domain.StageShimUp); err != nil {
+		return err
+	}
+	record := func(typ domain.EventType, msg string, cause error) {
+		e := domain.Event{Time: clock.Now(), VM: vm.Name, VMID: vm.ID, Type: typ, PID: os.Getpid(), Actor: domain.ActorShim, Message: msg}
+		if cause != nil {
+			e.Error = cause.Error()
+		}
+		_ = events.Append(ctx, e)
+	}
+	exit := func(state domain.VMState, reason string, code int, requested bool) {
+		e := domain.ShimExit{Time: clock.Now().UTC(), PID: os.Getpid(), State: state, Reason: reason, Code: code, Requested: requested}
+		if err := run.WriteExit(ctx, vm.Name, e); err != nil {
+			slog.Warn("failed to record shim exit", "vm", vm.Name, "error", err)
+		}
+	}
 	// Start the VM via provider
 	if _, err := provider.StartVM(ctx, *vm); err != nil {
 		// Do not mark ready; ensure we exit with error so parent fails fast
+		record(domain.EventCrashed, "provider failed to start the VM", err)
+		exit(domain.StateCrashed, "provider failed to start the VM: "+err.Error(), ExitFailed, false)
 		return err
 	}
+	states, err := provider.StateChanges(sctx, *vm)
+	if err != nil {
+		slog.Warn("vm state changes unavailable; a guest shutdown will go unnoticed", "vm", vm.Name, "error", err)
+	}
 
 	// Mark ready only after successful provider start
-	if err := run.MarkReady(ctx, vm.Name); err != nil {
+	if err := run.MarkStage(ctx, vm.Name, domain.StageVMStarted); err != nil {
 		return err
 	}
+	record(domain.EventReady, string(domain.StageVMStarted), nil)
+
+	pctx, stopProbing := context.WithCancel(ctx)
+	probed := make(chan struct{})
+	go func() {
+		defer close(probed)
+		probeGuest(pctx, run, probe, *vm, func(stage domain.ReadinessStage) { record(domain.EventReady, string(stage), nil) })
+	}()
 
 	// Handle signals for graceful shutdown
 	sigs := make(chan os.Signal, 2)
@@ -49,20 +111,299 @@ func RunChild(ctx context.Context, store domain.VMStore, run domain.RuntimeState
 
 	slog.Info("shim child running", "vm", vm.Name, "pid", os.Getpid())
 
-	// Placeholder for provider start; block until signal
-	select {
-	case <-ctx.Done():
-		// context canceled
-	case <-sigs:
-		// got signal
+	var (
+		opts domain.StopOptions
+		// ended is the state the VM stopped in by itself, if it did.
+		ended  domain.ProviderState
+		reason = "shim context canceled"
+	)
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			// context canceled; there is nobody left to wait for the guest
+			opts.Force = true
+			break wait
+		case sig := <-sigs:
+			reason = "received " + sig.String()
+			break wait
+		case opts = <-c.stopReq:
+			reason = "stop requested over the control socket"
+			break wait
+		case st, ok := <-states:
+			if !ok {
+				states = nil
+				continue
+			}
+			slog.Info("vm state changed", "vm", vm.Name, "state", st)
+			switch st {
+			case domain.ProviderStopped, domain.ProviderError:
+				ended = st
+				break wait
+			case domain.ProviderPaused, domain.ProviderRunning:
+				// Also catches the VM being paused or resumed other than through us.
+				c.notePaused(st == domain.ProviderPaused)
+			}
+		}
 	}
+	c.setStopping()
+
+	// Stop probing before the runtime files are cleared, so no stage outlives them.
+	stopProbing()
+	<-probed
 
-	// Stop VM
-	_ = provider.StopVM(ctx, *vm)
+	var result error
+	switch ended {
+	case domain.ProviderStopped:
+		reason = "guest shut down"
+		// Release the provider's handle on the powered-off VM.
+		err = provider.StopVM(ctx, *vm)
+		record(domain.EventStopped, reason, err)
+		exit(domain.StateStopped, reason, ExitStopped, false)
+	case domain.ProviderError:
+		reason = "vm stopped with an error"
+		err = provider.StopVM(ctx, *vm)
+		record(domain.EventCrashed, reason, err)
+		exit(domain.StateCrashed, reason, ExitVMError, false)
+		result = &ExitError{Code: ExitVMError, Reason: reason}
+	default:
+		how, err := c.shutdown(ctx, opts)
+		record(domain.EventStopped, reason+"; "+how, err)
+		// The shim was told to stop, so a restart policy leaves it stopped.
+		exit(domain.StateStopped, reason+"; "+how, ExitStopped, true)
+	}
 
-	// Cleanup readiness and pid on exit
+	// Stop answering before the runtime files are cleared, so nobody connects to a
+	// shim that is about to exit.
+	stopServing()
+	<-served
 	_ = run.Clear(ctx, vm.Name)
+	return result
+}
+
+// Shim exit statuses.
+const (
+	ExitStopped = 0 // the VM was stopped, on request or by its guest
+	ExitFailed  = 1 // the shim could not run the VM
+	ExitVMError = 3 // the VM stopped with an error
+)
+
+// ExitError is returned by RunChild when the VM ended in a way the shim's exit
+// status should report.
+type ExitError struct {
+	Code   int
+	Reason string
+}
+
+func (e *ExitError) Error() string { return e.Reason }
+
+// child answers control requests for the VM a shim runs.
+type child struct {
+	vm        domain.VM
+	run       domain.RuntimeState
+	provider  domain.VirtualizationProvider
+	probe     domain.GuestProber
+	startedAt time.Time
+	// stopReq receives the first stop request; forced is closed by the first
+	// forced one, which cuts short a graceful stop under way.
+	stopReq chan domain.StopOptions
+	forced  chan struct{}
+	force   sync.Once
+
+	mu       sync.Mutex
+	paused   bool
+	stopping bool
+}
+
+func (c *child) setStopping() {
+	c.mu.Lock()
+	c.stopping = true
+	c.mu.Unlock()
+}
+
+func (c *child) Status(ctx context.Context) (domain.ShimInfo, error) {
+	rd, err := c.run.Readiness(ctx, c.vm.Name)
+	if err != nil {
+		return domain.ShimInfo{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return domain.ShimInfo{
+		Protocol:  domain.ShimProtocolVersion,
+		PID:       os.Getpid(),
+		VM:        c.vm.Name,
+		VMID:      c.vm.ID,
+		StartedAt: c.startedAt,
+		Stage:     rd.Stage(),
+		Readiness: rd,
+		Paused:    c.paused,
+		Stopping:  c.stopping,
+	}, nil
+}
+
+func (c *child) Stop(ctx context.Context, opts domain.StopOptions) error {
+	c.mu.Lock()
+	c.stopping = true
+	c.mu.Unlock()
+	if opts.Force {
+		c.force.Do(func() { close(c.forced) })
+	}
+	select {
+	case c.stopReq <- opts:
+	default:
+	}
 	return nil
 }
 
+// powerOffPollInterval is how often a graceful stop checks whether the guest has
+// powered off.
+var powerOffPollInterval = 100 * time.Millisecond
+
+// shutdown stops the VM. Unless opts.Force is set, the guest is asked to shut
+// down and given opts.Timeout to power off before it is powered off anyway. It
+// describes how the VM stopped.
+func (c *child) shutdown(ctx context.Context, opts domain.StopOptions) (string, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = domain.DefaultStopTimeout
+	}
+	if opts.Force {
+		return "forced power-off", c.provider.StopVM(ctx, c.vm)
+	}
+	// A paused guest cannot act on a shutdown request.
+	if err := c.resumeForShutdown(ctx); err != nil {
+		slog.Warn("failed to resume the paused vm for shutdown; powering off", "vm", c.vm.Name, "error", err)
+		return "powered off after the paused vm could not be resumed", c.provider.StopVM(ctx, c.vm)
+	}
+	if err := c.provider.RequestStopVM(ctx, c.vm); err != nil {
+		slog.Warn("guest shutdown request failed; powering off", "vm", c.vm.Name, "error", err)
+		return "powered off after the shutdown request failed", c.provider.StopVM(ctx, c.vm)
+	}
+	t := time.NewTicker(powerOffPollInterval)
+	defer t.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		running, err := c.provider.IsRunning(ctx, c.vm)
+		if err == nil && !running {
+			// Release the provider's handle on the powered-off VM.
+			return "guest shut down", c.provider.StopVM(ctx, c.vm)
+		}
+		select {
+		case <-deadline.C:
+			slog.Warn("guest did not shut down in time; powering off", "vm", c.vm.Name, "timeout", timeout)
+			return fmt.Sprintf("powered off after the guest did not shut down within %s", timeout), c.provider.StopVM(ctx, c.vm)
+		case <-c.forced:
+			return "forced power-off during guest shutdown", c.provider.StopVM(ctx, c.vm)
+		case <-ctx.Done():
+			return "powered off as the shim was canceled", c.provider.StopVM(ctx, c.vm)
+		case <-t.C:
+		}
+	}
+}
+
+func (c *child) Pause(ctx context.Context) error { return c.setPaused(ctx, true) }
+
+func (c *child) Resume(ctx context.Context) error { return c.setPaused(ctx, false) }
+
+func (c *child) setPaused(ctx context.Context, pause bool) error {
+	p, ok := c.provider.(domain.VMPauser)
+	if !ok {
+		return fmt.Errorf("vm %s: %w", c.vm.Name, domain.ErrNotSupported)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopping {
+		return fmt.Errorf("vm %s is stopping", c.vm.Name)
+	}
+	if c.paused == pause {
+		return nil
+	}
+	var err error
+	if pause {
+		err = p.PauseVM(ctx, c.vm)
+	} else {
+		err = p.ResumeVM(ctx, c.vm)
+	}
+	if err != nil {
+		return err
+	}
+	c.paused = pause
+	return nil
+}
+
+// notePaused records a pause or resume the provider reported.
+func (c *child) notePaused(paused bool) {
+	c.mu.Lock()
+	c.paused = paused
+	c.mu.Unlock()
+}
+
+// resumeForShutdown resumes the VM if it is paused.
+func (c *child) resumeForShutdown(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.provider.(domain.VMPauser)
+	if !c.paused || !ok {
+		return nil
+	}
+	if err := p.ResumeVM(ctx, c.vm); err != nil {
+		return err
+	}
+	c.paused = false
+	return nil
+}
+
+func (c *child) Readiness(ctx context.Context) (domain.Readiness, error) {
+	return c.run.Readiness(ctx, c.vm.Name)
+}
+
+func (c *child) GuestInfo(ctx context.Context) (domain.GuestInfo, error) {
+	rd, err := c.run.Readiness(ctx, c.vm.Name)
+	if err != nil {
+		return domain.GuestInfo{}, err
+	}
+	info := domain.GuestInfo{Hostname: c.vm.Hostname, Stage: rd.Stage()}
+	if info.Hostname == "" {
+		info.Hostname = c.vm.Name
+	}
+	if c.probe != nil && rd.Reached(domain.StageGuestNetwork) {
+		if addr, err := c.probe.Address(ctx, c.vm); err == nil {
+			info.Address = addr
+		}
+	}
+	return info, nil
+}
+
+var _ control.Handler = (*child)(nil)
+
+// probeGuest marks each guest readiness stage in turn as probe observes it.
+func probeGuest(ctx context.Context, run domain.RuntimeState, probe domain.GuestProber, vm domain.VM, reached func(domain.ReadinessStage)) {
+	if probe == nil {
+		return
+	}
+	t := time.NewTicker(probeInterval)
+	defer t.Stop()
+	for _, stage := range domain.GuestStages {
+		for {
+			ok, err := probe.Probe(ctx, vm, stage)
+			if err != nil {
+				slog.Warn("readiness probe failed", "vm", vm.Name, "stage", stage, "error", err)
+			}
+			if ok {
+				if err := run.MarkStage(ctx, vm.Name, stage); err != nil {
+					slog.Warn("failed to mark readiness stage", "vm", vm.Name, "stage", stage, "error", err)
+				}
+				reached(stage)
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}
+}
+
 // note: base dir selection is performed by the caller constructing the store
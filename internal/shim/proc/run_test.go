@@ -0,0 +1,408 @@
+package proc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	eventsfs "github.com/alechenninger/orchard/internal/events/fs"
+	runfs "github.com/alechenninger/orchard/internal/runstate/fs"
+	"github.com/alechenninger/orchard/internal/shim/control"
+	vmfs "github.com/alechenninger/orchard/internal/vmstore/fs"
+)
+
+// fakeProvider records the VMs it runs without virtualizing anything. Its guest
+// powers off when asked to, unless it ignores shutdown requests. States sent on
+// states are reported as the VM's state changes.
+type fakeProvider struct {
+	mu             sync.Mutex
+	running        bool
+	paused         bool
+	ignoreShutdown bool
+	requests       int
+	stops          int
+	states         chan domain.ProviderState
+}
+
+func (p *fakeProvider) StateChanges(ctx context.Context, vm domain.VM) (<-chan domain.ProviderState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.states == nil {
+		p.states = make(chan domain.ProviderState)
+	}
+	ch := make(chan domain.ProviderState)
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case st := <-p.states:
+				ch <- st
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (p *fakeProvider) RequestStopVM(ctx context.Context, vm domain.VM) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests++
+	if !p.ignoreShutdown {
+		p.running = false
+	}
+	return nil
+}
+
+func (p *fakeProvider) StartVM(ctx context.Context, vm domain.VM) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running = true
+	return os.Getpid(), nil
+}
+
+func (p *fakeProvider) StopVM(ctx context.Context, vm domain.VM) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running = false
+	p.stops++
+	return nil
+}
+
+func (p *fakeProvider) IsRunning(ctx context.Context, vm domain.VM) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running, nil
+}
+
+// pausingProvider can also pause. Like a real guest, a paused one cannot act on
+// a shutdown request.
+type pausingProvider struct{ fakeProvider }
+
+func (p *pausingProvider) RequestStopVM(ctx context.Context, vm domain.VM) error {
+	p.mu.Lock()
+	paused := p.paused
+	p.mu.Unlock()
+	if paused {
+		return errors.New("vm cannot be asked to stop while paused")
+	}
+	return p.fakeProvider.RequestStopVM(ctx, vm)
+}
+
+func (p *pausingProvider) PauseVM(ctx context.Context, vm domain.VM) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+	return nil
+}
+
+func (p *pausingProvider) ResumeVM(ctx context.Context, vm domain.VM) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+	return nil
+}
+
+// fakeProber reports the guest on the network at once, and nothing further.
+type fakeProber struct{}
+
+func (fakeProber) Probe(ctx context.Context, vm domain.VM, stage domain.ReadinessStage) (bool, error) {
+	return stage == domain.StageGuestNetwork, nil
+}
+
+func (fakeProber) Address(ctx context.Context, vm domain.VM) (string, error) {
+	return "192.0.2.10", nil
+}
+
+// testShim is a shim running in the background for a VM named web.
+type testShim struct {
+	run    *runfs.Service
+	ctl    *control.Client
+	events *eventsfs.Journal
+	clock  *stepClock
+	done   <-chan error
+}
+
+// startChild runs a shim for web and connects to its control socket once the
+// guest is on the network.
+func startChild(t *testing.T, provider domain.VirtualizationProvider) *testShim {
+	t.Helper()
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	store := vmfs.New(filepath.Join(dir, "home"))
+	vm := &domain.VM{Name: "web", ID: "01J0000000000000000000WEB0", VMSpec: domain.VMSpec{Hostname: "web"}}
+	if err := store.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	s := &testShim{run: runfs.New(filepath.Join(dir, "run")), events: eventsfs.New(dir),
+		clock: &stepClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}}
+	old, oldPoll := probeInterval, powerOffPollInterval
+	probeInterval, powerOffPollInterval = 10*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { probeInterval, powerOffPollInterval = old, oldPoll })
+
+	// Shims still running when the test ends are canceled, and must be gone before
+	// the temporary directory is removed.
+	done, exited := make(chan error, 1), make(chan struct{})
+	go func() {
+		defer close(exited)
+		done <- RunChild(ctx, store, s.run, provider, fakeProber{}, s.events, s.clock, "web")
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-exited
+	})
+	s.done = done
+	wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer wcancel()
+	if _, err := s.run.WaitStage(wctx, "web", domain.StageGuestNetwork); err != nil {
+		t.Fatal(err)
+	}
+	ctl, err := control.Dial(ctx, s.run.ControlSocket("web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ctl.Close() })
+	s.ctl = ctl
+	return s
+}
+
+// wait waits for the shim to exit and returns the reason it recorded for stopping.
+func (s *testShim) wait(t *testing.T) string {
+	t.Helper()
+	select {
+	case err := <-s.done:
+		if err != nil {
+			t.Fatalf("RunChild = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shim did not exit after stop")
+	}
+	evs, err := s.events.Read(context.Background(), "web", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range evs {
+		if !e.Time.Equal(s.clock.now) {
+			t.Errorf("event %s stamped %v, not by the shim's clock", e.Type, e.Time)
+		}
+		if e.Type == domain.EventStopped {
+			return e.Message
+		}
+	}
+	t.Fatalf("no stopped event in %+v", evs)
+	return ""
+}
+
+func TestControlStatusAndGuestInfo(t *testing.T) {
+	ctx := context.Background()
+	ctl := startChild(t, &fakeProvider{}).ctl
+
+	info, err := ctl.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Protocol != domain.ShimProtocolVersion || info.PID != os.Getpid() || info.VM != "web" || info.Stopping || info.Paused {
+		t.Fatalf("Status = %+v", info)
+	}
+	if !info.Readiness.Reached(domain.StageGuestNetwork) {
+		t.Fatalf("Status readiness = %v, want guest-network reached", info.Readiness)
+	}
+	rd, err := ctl.Readiness(ctx)
+	if err != nil || rd.Stage() != info.Stage {
+		t.Fatalf("Readiness = %v, %v; status reported %s", rd, err, info.Stage)
+	}
+	guest, err := ctl.GuestInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.Hostname != "web" || guest.Address != "192.0.2.10" {
+		t.Fatalf("GuestInfo = %+v", guest)
+	}
+}
+
+func TestControlStopEndsShim(t *testing.T) {
+	ctx := context.Background()
+	provider := &fakeProvider{}
+	s := startChild(t, provider)
+
+	if err := s.ctl.Stop(ctx, domain.StopOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if reason := s.wait(t); !strings.Contains(reason, "guest shut down") {
+		t.Fatalf("stopped event = %q, want the guest to have shut down", reason)
+	}
+	if running, _ := provider.IsRunning(ctx, domain.VM{}); running || provider.requests != 1 {
+		t.Fatalf("provider running=%v after %d shutdown requests", running, provider.requests)
+	}
+	if _, err := os.Stat(s.run.ControlSocket("web")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("control socket left behind: %v", err)
+	}
+	if _, err := s.run.ReadPID(ctx, "web"); err == nil {
+		t.Fatal("pid file left behind")
+	}
+	if _, err := control.Dial(ctx, s.run.ControlSocket("web")); !errors.Is(err, domain.ErrNoControl) {
+		t.Fatalf("Dial after exit = %v, want ErrNoControl", err)
+	}
+}
+
+func TestControlStopPowersOffUnresponsiveGuest(t *testing.T) {
+	ctx := context.Background()
+	provider := &fakeProvider{ignoreShutdown: true}
+	s := startChild(t, provider)
+
+	if err := s.ctl.Stop(ctx, domain.StopOptions{Timeout: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if reason := s.wait(t); !strings.Contains(reason, "did not shut down within 50ms") {
+		t.Fatalf("stopped event = %q, want a power-off after the timeout", reason)
+	}
+	if provider.requests != 1 || provider.stops != 1 {
+		t.Fatalf("provider got %d shutdown requests and %d stops", provider.requests, provider.stops)
+	}
+}
+
+func TestControlForcedStop(t *testing.T) {
+	ctx := context.Background()
+
+	provider := &fakeProvider{}
+	s := startChild(t, provider)
+	if err := s.ctl.Stop(ctx, domain.StopOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if reason := s.wait(t); !strings.Contains(reason, "forced power-off") {
+		t.Fatalf("stopped event = %q, want a forced power-off", reason)
+	}
+	if provider.requests != 0 || provider.stops != 1 {
+		t.Fatalf("forced stop sent %d shutdown requests and %d stops", provider.requests, provider.stops)
+	}
+
+	// A forced stop cuts short a graceful one that is waiting on the guest.
+	provider = &fakeProvider{ignoreShutdown: true}
+	s = startChild(t, provider)
+	if err := s.ctl.Stop(ctx, domain.StopOptions{Timeout: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ctl.Stop(ctx, domain.StopOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if reason := s.wait(t); !strings.Contains(reason, "forced power-off during guest shutdown") {
+		t.Fatalf("stopped event = %q, want a forced power-off", reason)
+	}
+}
+
+func TestGuestShutdownEndsShim(t *testing.T) {
+	ctx := context.Background()
+	provider := &fakeProvider{}
+	s := startChild(t, provider)
+
+	provider.states <- domain.ProviderStopping
+	provider.states <- domain.ProviderStopped
+	if reason := s.wait(t); reason != "guest shut down" {
+		t.Fatalf("stopped event = %q", reason)
+	}
+	exit, err := s.run.LastExit(ctx, "web")
+	if err != nil || exit == nil {
+		t.Fatalf("LastExit = %v, %v", exit, err)
+	}
+	if exit.State != domain.StateStopped || exit.Code != ExitStopped || exit.PID != os.Getpid() {
+		t.Fatalf("LastExit = %+v", exit)
+	}
+	if provider.requests != 0 {
+		t.Fatalf("shim asked a guest that shut itself down to shut down")
+	}
+	if _, err := s.run.ReadPID(ctx, "web"); err == nil {
+		t.Fatal("pid file left behind")
+	}
+}
+
+func TestVMErrorEndsShimWithStatus(t *testing.T) {
+	ctx := context.Background()
+	provider := &fakeProvider{}
+	s := startChild(t, provider)
+
+	provider.states <- domain.ProviderError
+	var err error
+	select {
+	case err = <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shim did not exit after the vm failed")
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != ExitVMError {
+		t.Fatalf("RunChild = %v, want exit status %d", err, ExitVMError)
+	}
+	exit, _ := s.run.LastExit(ctx, "web")
+	if exit == nil || exit.State != domain.StateCrashed || exit.Code != ExitVMError || exit.Reason == "" {
+		t.Fatalf("LastExit = %+v", exit)
+	}
+	evs, _ := s.events.Read(ctx, "web", time.Time{})
+	if last := evs[len(evs)-1]; last.Type != domain.EventCrashed || last.Message != exit.Reason {
+		t.Fatalf("last event = %+v, want crashed with %q", last, exit.Reason)
+	}
+}
+
+func TestControlPause(t *testing.T) {
+	ctx := context.Background()
+
+	ctl := startChild(t, &fakeProvider{}).ctl
+	if err := ctl.Pause(ctx); !errors.Is(err, domain.ErrNotSupported) {
+		t.Fatalf("Pause on a provider that cannot pause = %v, want ErrNotSupported", err)
+	}
+
+	provider := &pausingProvider{}
+	ctl = startChild(t, provider).ctl
+	if err := ctl.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := ctl.Status(ctx); !info.Paused || !provider.paused {
+		t.Fatalf("after Pause: status %+v, provider paused=%v", info, provider.paused)
+	}
+	if err := ctl.Resume(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := ctl.Status(ctx); info.Paused || provider.paused {
+		t.Fatalf("after Resume: status %+v, provider paused=%v", info, provider.paused)
+	}
+}
+
+func TestControlStopResumesPausedVM(t *testing.T) {
+	ctx := context.Background()
+	provider := &pausingProvider{}
+	s := startChild(t, provider)
+	if err := s.ctl.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ctl.Stop(ctx, domain.StopOptions{Timeout: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := s.wait(t); !strings.HasSuffix(msg, "; guest shut down") {
+		t.Fatalf("stopped event = %q, want the paused guest to be resumed and shut down", msg)
+	}
+	if provider.paused || provider.requests != 1 {
+		t.Fatalf("provider paused=%v after %d shutdown requests", provider.paused, provider.requests)
+	}
+}
+
+func TestControlRejectsOtherProtocolVersions(t *testing.T) {
+	s := startChild(t, &fakeProvider{})
+	conn, err := net.Dial("unix", s.run.ControlSocket("web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := jsonrpc.NewClient(conn)
+	defer c.Close()
+	var info domain.ShimInfo
+	err = c.Call("ShimV2.Status", control.Empty{}, &info)
+	if err == nil || !strings.Contains(err.Error(), "can't find service") {
+		t.Fatalf("call to another protocol version = %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package proc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+// ShimProcess is a shim launched by a Supervisor.
+type ShimProcess interface {
+	Pid() int
+	// Wait waits for the shim to exit and returns its exit status, or -1 if it
+	// was killed by a signal.
+	Wait() int
+	// Terminate asks the shim to stop the VM and exit.
+	Terminate() error
+}
+
+// Supervisor runs a VM's shim and starts it again when it exits, as the VM's
+// restart policy asks. It runs in the _supervise process, which StartDetached
+// launches in place of the shim for VMs with a restart policy.
+type Supervisor struct {
+	Run    domain.RuntimeState
+	Events domain.EventJournal
+	Clock  domain.Clock
+	// Launch starts a shim for the VM.
+	Launch func(ctx context.Context, vm domain.VM) (ShimProcess, error)
+
+	// sleep waits d unless ctx is done first; tests replace it.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Supervise runs vm's shim until its restart policy gives up on it, the shim is
+// stopped on request, or ctx is done. When ctx is done, a running shim is
+// terminated and waited for. The supervisor's state is kept in s.Run for status.
+func (s *Supervisor) Supervise(ctx context.Context, vm domain.VM) error {
+	sleep := s.sleep
+	if sleep == nil {
+		sleep = sleepCtx
+	}
+	b := &backoff{policy: vm.RestartPolicy, clock: s.Clock}
+	st := domain.SupervisorState{PID: os.Getpid(), Policy: vm.RestartPolicy}
+	save := func() {
+		if err := s.Run.SetSupervisor(ctx, vm.Name, &st); err != nil {
+			slog.Warn("failed to record supervisor state", "vm", vm.Name, "error", err)
+		}
+	}
+	record := func(typ domain.EventType, msg string) {
+		_ = s.Events.Append(ctx, domain.Event{Time: s.Clock.Now(), VM: vm.Name, VMID: vm.ID, Type: typ, PID: os.Getpid(), Actor: domain.ActorSupervisor, Message: msg})
+	}
+	save()
+	for {
+		p, err := s.Launch(ctx, vm)
+		if err != nil {
+			st.GaveUp = "could not launch the shim: " + err.Error()
+			st.NextRestart = time.Time{}
+			save()
+			record(domain.EventCrashed, st.GaveUp)
+			return err
+		}
+		b.started()
+		// Cleared only once the new shim is launched, so status never sees a gap.
+		if !st.NextRestart.IsZero() {
+			st.NextRestart = time.Time{}
+			save()
+		}
+		slog.Info("supervising shim", "vm", vm.Name, "pid", p.Pid(), "restarts", st.Restarts)
+
+		done := make(chan int, 1)
+		go func() { done <- p.Wait() }()
+		var code int
+		select {
+		case code = <-done:
+		case <-ctx.Done():
+			_ = p.Terminate()
+			<-done
+			return nil
+		}
+
+		exit := s.lastExit(ctx, vm, p.Pid(), code)
+		delay, why, ok := b.next(exit)
+		if !ok {
+			if why != "" {
+				st.GaveUp = why + "; last exit: " + exit.Reason
+				record(domain.EventCrashed, st.GaveUp)
+			}
+			save()
+			slog.Info("not restarting", "vm", vm.Name, "policy", vm.RestartPolicy.String(), "exit", exit.Reason, "gaveUp", why)
+			return nil
+		}
+		st.Restarts++
+		st.NextRestart = s.Clock.Now().Add(delay)
+		save()
+		record(domain.EventRestarting, fmt.Sprintf("restart %d in %s after: %s", st.Restarts, delay, exit.Reason))
+		slog.Info("restarting shim", "vm", vm.Name, "delay", delay, "restart", st.Restarts, "exit", exit.Reason)
+		if err := sleep(ctx, delay); err != nil {
+			// Stopped while waiting: the VM stays down, as the user asked.
+			st.NextRestart = time.Time{}
+			save()
+			stopped := domain.ShimExit{Time: s.Clock.Now().UTC(), PID: os.Getpid(), State: domain.StateStopped,
+				Reason: "stopped while waiting to restart after: " + exit.Reason, Requested: true}
+			if err := s.Run.WriteExit(ctx, vm.Name, stopped); err != nil {
+				slog.Warn("failed to record shim exit", "vm", vm.Name, "error", err)
+			}
+			record(domain.EventStopped, stopped.Reason)
+			return nil
+		}
+		// The next shim writes its own runtime files; clear what the last one left.
+		_ = s.Run.CleanupIfStale(ctx, vm.Name)
+	}
+}
+
+// lastExit returns the exit the shim with pid recorded, or, if it died without
+// recording one, an exit made up from its exit status.
+func (s *Supervisor) lastExit(ctx context.Context, vm domain.VM, pid, code int) domain.ShimExit {
+	if exit, err := s.Run.LastExit(ctx, vm.Name); err == nil && exit != nil && exit.PID == pid {
+		return *exit
+	}
+	exit := domain.ShimExit{Time: s.Clock.Now().UTC(), PID: pid, State: domain.StateCrashed, Code: code,
+		Reason: fmt.Sprintf("shim exited with status %d", code)}
+	if code < 0 {
+		exit.Reason = "shim was killed"
+	}
+	if err := s.Run.WriteExit(ctx, vm.Name, exit); err != nil {
+		slog.Warn("failed to record shim exit", "vm", vm.Name, "error", err)
+	}
+	return exit
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff decides whether and when a supervisor restarts a VM after its shim
+// exits.
+type backoff struct {
+	policy domain.RestartPolicy
+	clock  domain.Clock
+	// consecutive counts the restarts since the VM last stayed up for
+	// domain.RestartResetAfter.
+	consecutive int
+	startedAt   time.Time
+}
+
+// started notes that a shim was just launched.
+func (b *backoff) started() { b.startedAt = b.clock.Now() }
+
+// next returns how long to wait before restarting the VM after exit. If the
+// policy does not restart it, ok is false and why says why it gave up, or is
+// empty if the policy never restarts after such an exit.
+func (b *backoff) next(exit domain.ShimExit) (delay time.Duration, why string, ok bool) {
+	if !b.policy.Wants(exit) {
+		return 0, "", false
+	}
+	if b.clock.Now().Sub(b.startedAt) >= domain.RestartResetAfter {
+		b.consecutive = 0
+	}
+	if limit := b.policy.MaxRetries; limit > 0 && b.consecutive >= limit {
+		return 0, fmt.Sprintf("restart limit of %d reached", limit), false
+	}
+	b.consecutive++
+	return domain.RestartDelay(b.consecutive), "", true
+}
@@ -0,0 +1,212 @@
+package proc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	eventsfs "github.com/alechenninger/orchard/internal/events/fs"
+	runfs "github.com/alechenninger/orchard/internal/runstate/fs"
+	"github.com/spf13/afero"
+)
+
+// stepClock is a domain.Clock that only moves when told to.
+type stepClock struct{ now time.Time }
+
+func (c *stepClock) Now() time.Time          { return c.now }
+func (c *stepClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestBackoffDoublesUpToTheCap(t *testing.T) {
+	clock := &stepClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b := &backoff{policy: domain.RestartPolicy{Mode: domain.RestartAlways}, clock: clock}
+	crash := domain.ShimExit{State: domain.StateCrashed}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for i, w := range want {
+		b.started()
+		clock.advance(time.Minute)
+		if d, _, ok := b.next(crash); !ok || d != w {
+			t.Fatalf("restart %d: delay %s, %v; want %s", i+1, d, ok, w)
+		}
+	}
+	for range 10 {
+		b.started()
+		b.next(crash)
+	}
+	b.started()
+	if d, _, _ := b.next(crash); d != domain.MaxRestartBackoff {
+		t.Fatalf("delay after many restarts = %s, want the cap %s", d, domain.MaxRestartBackoff)
+	}
+
+	// A VM that stayed up long enough is healthy again and starts over.
+	b.started()
+	clock.advance(domain.RestartResetAfter)
+	if d, _, _ := b.next(crash); d != time.Second {
+		t.Fatalf("delay after a stable run = %s, want %s", d, time.Second)
+	}
+}
+
+func TestBackoffGivesUpAfterMaxRetries(t *testing.T) {
+	clock := &stepClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b := &backoff{policy: domain.RestartPolicy{Mode: domain.RestartOnFailure, MaxRetries: 2}, clock: clock}
+	crash := domain.ShimExit{State: domain.StateCrashed}
+	for i := range 2 {
+		b.started()
+		clock.advance(time.Second)
+		if _, _, ok := b.next(crash); !ok {
+			t.Fatalf("restart %d refused", i+1)
+		}
+	}
+	b.started()
+	if _, why, ok := b.next(crash); ok || why != "restart limit of 2 reached" {
+		t.Fatalf("third restart = %v, %q; want to give up", ok, why)
+	}
+}
+
+func TestBackoffFollowsPolicy(t *testing.T) {
+	crashed := domain.ShimExit{State: domain.StateCrashed}
+	shutdown := domain.ShimExit{State: domain.StateStopped}
+	requested := domain.ShimExit{State: domain.StateStopped, Requested: true}
+	for _, tc := range []struct {
+		mode domain.RestartMode
+		exit domain.ShimExit
+		want bool
+	}{
+		{domain.RestartNo, crashed, false},
+		{domain.RestartOnFailure, crashed, true},
+		{domain.RestartOnFailure, shutdown, false},
+		{domain.RestartAlways, shutdown, true},
+		{domain.RestartAlways, requested, false},
+	} {
+		b := &backoff{policy: domain.RestartPolicy{Mode: tc.mode}, clock: &stepClock{}}
+		b.started()
+		if _, why, ok := b.next(tc.exit); ok != tc.want || why != "" {
+			t.Errorf("%s after %+v: restart %v (%q), want %v", tc.mode, tc.exit, ok, why, tc.want)
+		}
+	}
+}
+
+// fakeShimProcess exits as soon as it is waited for, after running exit.
+type fakeShimProcess struct {
+	pid  int
+	code int
+	exit func()
+}
+
+func (p *fakeShimProcess) Pid() int { return p.pid }
+
+func (p *fakeShimProcess) Wait() int {
+	if p.exit != nil {
+		p.exit()
+	}
+	return p.code
+}
+
+func (p *fakeShimProcess) Terminate() error { return nil }
+
+// newSupervisor returns a supervisor of web whose shims exit as listed, recording
+// the exits given for them; a nil exit is a shim that died without recording one.
+// Its sleeps are recorded rather than waited out.
+func newSupervisor(t *testing.T, exits []*domain.ShimExit, codes []int) (*Supervisor, *[]time.Duration) {
+	t.Helper()
+	run := runfs.NewWithFS("/run", afero.NewMemMapFs())
+	clock := &stepClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var slept []time.Duration
+	launched := 0
+	s := &Supervisor{
+		Run:    run,
+		Events: eventsfs.NewWithFS("/home", afero.NewMemMapFs()),
+		Clock:  clock,
+		Launch: func(ctx context.Context, vm domain.VM) (ShimProcess, error) {
+			if launched == len(exits) {
+				t.Fatal("launched more shims than expected")
+			}
+			i := launched
+			launched++
+			pid := 100 + i
+			return &fakeShimProcess{pid: pid, code: codes[i], exit: func() {
+				if e := exits[i]; e != nil {
+					e.PID = pid
+					_ = run.WriteExit(ctx, vm.Name, *e)
+				}
+			}}, nil
+		},
+		sleep: func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			clock.advance(d)
+			return nil
+		},
+	}
+	return s, &slept
+}
+
+func TestSupervisorRestartsUntilStopped(t *testing.T) {
+	ctx := context.Background()
+	vm := domain.VM{Name: "web", VMSpec: domain.VMSpec{RestartPolicy: domain.RestartPolicy{Mode: domain.RestartOnFailure}}}
+	s, slept := newSupervisor(t, []*domain.ShimExit{
+		{State: domain.StateCrashed, Reason: "vm stopped with an error", Code: ExitVMError},
+		nil,
+		{State: domain.StateStopped, Reason: "stop requested over the control socket; guest shut down", Requested: true},
+	}, []int{ExitVMError, 2, 0})
+	if err := s.Supervise(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second}; len(*slept) != 2 || (*slept)[0] != want[0] || (*slept)[1] != want[1] {
+		t.Fatalf("backed off %v, want %v", *slept, want)
+	}
+	st, err := s.Run.Supervisor(ctx, "web")
+	if err != nil || st == nil {
+		t.Fatalf("supervisor state = %+v, %v", st, err)
+	}
+	if st.Restarts != 2 || st.GaveUp != "" || !st.NextRestart.IsZero() {
+		t.Fatalf("supervisor state = %+v, want 2 restarts and none pending", st)
+	}
+	evs, _ := s.Events.Read(ctx, "web", time.Time{})
+	var restarts []string
+	for _, e := range evs {
+		if e.Time.After(s.Clock.Now()) || e.Time.Year() != 2026 {
+			t.Errorf("event %s stamped %v, not by the supervisor's clock", e.Type, e.Time)
+		}
+		if e.Type == domain.EventRestarting {
+			restarts = append(restarts, e.Message)
+		}
+	}
+	if len(restarts) != 2 || !strings.HasSuffix(restarts[0], "after: vm stopped with an error") ||
+		!strings.HasSuffix(restarts[1], "after: shim exited with status 2") {
+		t.Fatalf("restart events = %q", restarts)
+	}
+}
+
+func TestSupervisorGivesUp(t *testing.T) {
+	ctx := context.Background()
+	vm := domain.VM{Name: "web", VMSpec: domain.VMSpec{RestartPolicy: domain.RestartPolicy{Mode: domain.RestartAlways, MaxRetries: 1}}}
+	s, _ := newSupervisor(t, []*domain.ShimExit{
+		{State: domain.StateStopped, Reason: "guest shut down"},
+		{State: domain.StateStopped, Reason: "guest shut down"},
+	}, []int{0, 0})
+	if err := s.Supervise(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := s.Run.Supervisor(ctx, "web")
+	if st == nil || st.Restarts != 1 || st.GaveUp != "restart limit of 1 reached; last exit: guest shut down" {
+		t.Fatalf("supervisor state = %+v", st)
+	}
+}
+
+func TestSupervisorStoppedWhileWaitingToRestart(t *testing.T) {
+	ctx := context.Background()
+	vm := domain.VM{Name: "web", VMSpec: domain.VMSpec{RestartPolicy: domain.RestartPolicy{Mode: domain.RestartOnFailure}}}
+	s, _ := newSupervisor(t, []*domain.ShimExit{{State: domain.StateCrashed, Reason: "vm stopped with an error"}}, []int{ExitVMError})
+	s.sleep = func(ctx context.Context, d time.Duration) error { return context.Canceled }
+	if err := s.Supervise(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	exit, _ := s.Run.LastExit(ctx, "web")
+	if exit == nil || exit.State != domain.StateStopped || !exit.Requested {
+		t.Fatalf("last exit = %+v, want a requested stop", exit)
+	}
+	if st, _ := s.Run.Supervisor(ctx, "web"); st == nil || !st.NextRestart.IsZero() {
+		t.Fatalf("supervisor state = %+v, want no pending restart", st)
+	}
+}
@@ -0,0 +1,200 @@
+package shimlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Entry is one line of a log. Lines that are not slog records, such as serial
+// console output, only have a Message; they take the time of the record before
+// them, if any.
+type Entry struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level,omitempty"`
+	Message string         `json:"msg"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+}
+
+// Read returns the entries of the log at path and its rotated backups, oldest
+// first, whose time is at or after since. A missing log reads as empty.
+func Read(fsys afero.Fs, path string, since time.Time) ([]Entry, error) {
+	var out []Entry
+	var last time.Time
+	for _, p := range Files(fsys, path) {
+		b, err := afero.ReadFile(fsys, p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var es []Entry
+		es, _, last = parse(b, last)
+		out = appendSince(out, es, since)
+	}
+	return out, nil
+}
+
+// appendSince appends the entries of es at or after since to out.
+func appendSince(out, es []Entry, since time.Time) []Entry {
+	for _, e := range es {
+		if since.IsZero() || !e.Time.Before(since) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Files lists the backups of the log at path, oldest first, then path.
+func Files(fsys afero.Fs, path string) []string {
+	var backups []string
+	for i := 1; ; i++ {
+		if _, err := fsys.Stat(backup(path, i)); err != nil {
+			break
+		}
+		backups = append([]string{backup(path, i)}, backups...)
+	}
+	return append(backups, path)
+}
+
+// Follow streams the entries Read would return, then those appended to the log
+// at path, checking every poll, until ctx is done. The log is followed from where
+// its history ended, so no entry is streamed twice. When the log is rotated it
+// continues from the start of the new one.
+func Follow(ctx context.Context, fsys afero.Fs, path string, since time.Time, poll time.Duration) (<-chan Entry, error) {
+	prev, err := fsys.Stat(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var history []Entry
+	var last time.Time
+	files := Files(fsys, path)
+	for _, p := range files[:len(files)-1] {
+		b, err := afero.ReadFile(fsys, p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var es []Entry
+		es, _, last = parse(b, last)
+		history = appendSince(history, es, since)
+	}
+	es, offset, last := readFrom(fsys, path, 0, last)
+	history = appendSince(history, es, since)
+	ch := make(chan Entry)
+	go func() {
+		defer close(ch)
+		for _, e := range history {
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+		t := time.NewTicker(poll)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			if st, err := fsys.Stat(path); err == nil {
+				if st.Size() < offset || replaced(prev, st) {
+					offset = 0
+				}
+				prev = st
+			}
+			var es []Entry
+			es, offset, last = readFrom(fsys, path, offset, last)
+			for _, e := range es {
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// replaced reports whether a log on the OS filesystem is a different file than
+// before, as after a rotation. Other filesystems only show rotation by shrinking.
+func replaced(prev, cur os.FileInfo) bool {
+	if _, ok := cur.Sys().(*syscall.Stat_t); !ok || prev == nil {
+		return false
+	}
+	return !os.SameFile(prev, cur)
+}
+
+// readFrom returns the complete lines written to p past offset and the offset
+// after them. A partially written last line is left for the next read.
+func readFrom(fsys afero.Fs, p string, offset int64, last time.Time) ([]Entry, int64, time.Time) {
+	f, err := fsys.Open(p)
+	if err != nil {
+		return nil, offset, last
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, last
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, offset, last
+	}
+	es, n, last := parse(b, last)
+	return es, offset + int64(n), last
+}
+
+// parse decodes the complete lines of b, returning the entries, the number of
+// bytes consumed and the time of the last entry that had one.
+func parse(b []byte, last time.Time) ([]Entry, int, time.Time) {
+	var out []Entry
+	n := bytes.LastIndexByte(b, '\n') + 1
+	sc := bufio.NewScanner(bytes.NewReader(b[:n]))
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		e := parseLine(sc.Bytes())
+		if e.Time.IsZero() {
+			e.Time = last
+		}
+		last = e.Time
+		out = append(out, e)
+	}
+	return out, n, last
+}
+
+func parseLine(line []byte) Entry {
+	var rec map[string]any
+	if json.Unmarshal(line, &rec) != nil {
+		return Entry{Message: string(line)}
+	}
+	msg, ok := rec["msg"].(string)
+	if !ok {
+		return Entry{Message: string(line)}
+	}
+	e := Entry{Message: msg}
+	if s, ok := rec["time"].(string); ok {
+		e.Time, _ = time.Parse(time.RFC3339Nano, s)
+	}
+	e.Level, _ = rec["level"].(string)
+	delete(rec, "time")
+	delete(rec, "level")
+	delete(rec, "msg")
+	if len(rec) > 0 {
+		e.Attrs = rec
+	}
+	return e
+}
@@ -0,0 +1,118 @@
+package shimlog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestWriterRotatesBySize(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	w, err := Open(fsys, "/vm/shim.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.MaxBytes, w.Backups = 100, 2
+	line := strings.Repeat("x", 39) + "\n" // two lines fit, a third rotates
+	for i := 0; i < 7; i++ {
+		if _, err := fmt.Fprint(w, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string]int64{"/vm/shim.log": 40, "/vm/shim.log.1": 80, "/vm/shim.log.2": 80} {
+		st, err := fsys.Stat(p)
+		if err != nil || st.Size() != want {
+			t.Fatalf("%s: size %v, %v; want %d", p, st, err, want)
+		}
+	}
+	if _, err := fsys.Stat("/vm/shim.log.3"); err == nil {
+		t.Fatal("kept more backups than asked for")
+	}
+}
+
+func TestReadStructuredAndPlainLines(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	w, err := Open(fsys, "/vm/shim.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.MaxBytes = 150
+	log := slog.New(slog.NewJSONHandler(w, nil))
+	log.Info("shim starting", "vm", "web")
+	fmt.Fprintln(w, "panic: something broke")
+	log.Warn("rosetta unavailable")
+	w.Close()
+
+	es, err := Read(fsys, "/vm/shim.log", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 3 {
+		t.Fatalf("read %d entries across the rotation, want 3: %+v", len(es), es)
+	}
+	if es[0].Message != "shim starting" || es[0].Level != "INFO" || es[0].Attrs["vm"] != "web" || es[0].Time.IsZero() {
+		t.Fatalf("structured entry = %+v", es[0])
+	}
+	if es[1].Message != "panic: something broke" || !es[1].Time.Equal(es[0].Time) {
+		t.Fatalf("plain entry = %+v, want the time of the entry before it", es[1])
+	}
+	if es[2].Level != "WARN" {
+		t.Fatalf("last entry = %+v", es[2])
+	}
+
+	es, _ = Read(fsys, "/vm/shim.log", es[2].Time)
+	if len(es) != 1 || es[0].Message != "rosetta unavailable" {
+		t.Fatalf("entries since the last one = %+v", es)
+	}
+}
+
+func TestFollowReadsHistoryThenContinuesAcrossRotation(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	w, err := Open(fsys, "/vm/shim.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.MaxBytes = 20
+	fmt.Fprintln(w, "before follow")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := Follow(ctx, fsys, "/vm/shim.log", time.Time{}, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() string {
+		select {
+		case e := <-ch:
+			return e.Message
+		case <-time.After(2 * time.Second):
+			t.Fatal("no entry followed")
+			return ""
+		}
+	}
+	fmt.Fprintln(w, "first")
+	if got := next(); got != "before follow" {
+		t.Fatalf("followed %q, want the history first", got)
+	}
+	if got := next(); got != "first" {
+		t.Fatalf("followed %q, want first", got)
+	}
+	fmt.Fprintln(w, "after rotation") // past MaxBytes
+	if got := next(); got != "after rotation" {
+		t.Fatalf("followed %q, want the line written after rotation", got)
+	}
+	select {
+	case e := <-ch:
+		t.Fatalf("followed an extra entry %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !linux
+
+package shimlog
+
+import "errors"
+
+func redirectStdio(fd uintptr) error {
+	return errors.New("capturing stdio is not supported on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build darwin || linux
+
+package shimlog
+
+import "golang.org/x/sys/unix"
+
+// redirectStdio makes fd the process's stdout and stderr.
+func redirectStdio(fd uintptr) error {
+	for _, to := range []int{1, 2} {
+		if err := unix.Dup2(int(fd), to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Package shimlog writes and reads the log a shim keeps in its VM's directory.
+//
+// The log holds one JSON object per line as written by slog's JSON handler, and
+// is rotated by size: shim.log is current, shim.log.1 the newest backup. Output
+// the shim does not log through slog, such as a panic or a warning printed by
+// Virtualization.framework, lands in the same file as plain lines.
+package shimlog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/spf13/afero"
+)
+
+// FileName is the name of the shim log in a VM's directory.
+const FileName = "shim.log"
+
+// Defaults for a Writer.
+const (
+	DefaultMaxBytes = 10 << 20
+	DefaultBackups  = 3
+)
+
+// Path returns the shim log of vm, next to its disk.
+func Path(vm domain.VM) string { return filepath.Join(filepath.Dir(vm.DiskPath), FileName) }
+
+// backup returns the path of the i-th newest rotated log.
+func backup(path string, i int) string { return path + "." + strconv.Itoa(i) }
+
+// Writer appends to a log file, rotating it once it would grow past MaxBytes.
+// It is safe for concurrent use.
+type Writer struct {
+	fs   afero.Fs
+	path string
+	// MaxBytes is the size past which the log is rotated.
+	MaxBytes int64
+	// Backups is how many rotated logs are kept.
+	Backups int
+
+	mu    sync.Mutex
+	f     afero.File
+	stdio bool
+}
+
+// Open opens the log at path for appending, creating it if needed.
+func Open(fsys afero.Fs, path string) (*Writer, error) {
+	w := &Writer{fs: fsys, path: path, MaxBytes: DefaultMaxBytes, Backups: DefaultBackups}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := w.fs.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w.f = f
+	return nil
+}
+
+// Write appends p, rotating first if p would take the log past MaxBytes. The
+// size is taken from the file, so output written to it directly through
+// captured stdio counts too.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.follow(); err != nil {
+		return 0, err
+	}
+	if st, err := w.f.Stat(); err == nil && st.Size() > 0 && st.Size()+int64(len(p)) > w.MaxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	return w.f.Write(p)
+}
+
+// follow reopens the log if another process, such as a VM's supervisor writing
+// next to its shim, rotated it away from under the open file.
+func (w *Writer) follow() error {
+	open, err := w.f.Stat()
+	if err != nil {
+		return nil
+	}
+	cur, err := w.fs.Stat(w.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil || !replaced(open, cur):
+		return nil
+	}
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	return w.reopen()
+}
+
+// rotate shifts the backups up by one, dropping the oldest, and starts a new log.
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	if w.Backups > 0 {
+		for i := w.Backups - 1; i >= 1; i-- {
+			_ = w.fs.Rename(backup(w.path, i), backup(w.path, i+1))
+		}
+		if err := w.fs.Rename(w.path, backup(w.path, 1)); err != nil {
+			return err
+		}
+	} else if err := w.fs.Remove(w.path); err != nil {
+		return err
+	}
+	return w.reopen()
+}
+
+// reopen opens the log at path again after the old file was closed.
+func (w *Writer) reopen() error {
+	if err := w.open(); err != nil {
+		return err
+	}
+	if w.stdio {
+		return w.redirect()
+	}
+	return nil
+}
+
+// CaptureStdio points the process's stdout and stderr at the log, and keeps them
+// pointed at it across rotations. It needs a log on the OS filesystem.
+func (w *Writer) CaptureStdio() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.redirect(); err != nil {
+		return err
+	}
+	w.stdio = true
+	return nil
+}
+
+func (w *Writer) redirect() error {
+	f, ok := w.f.(interface{ Fd() uintptr })
+	if !ok {
+		return fmt.Errorf("%s is not an OS file", w.path)
+	}
+	return redirectStdio(f.Fd())
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
@@ -0,0 +1,728 @@
+// Package bolt implements domain.VMStore on an embedded bbolt database.
+//
+// Every record lives in one file, <base>/state/orchard.db, so listing VMs is a
+// single read transaction instead of a directory walk, and name allocation,
+// saves and index maintenance commit atomically. The database is opened per
+// operation: bbolt holds an exclusive flock while a read-write handle is open,
+// and the CLI and every shim must be able to take turns.
+//
+// Buckets:
+//
+//	vms       name -> record (schema.Encode JSON)
+//	ids       ID -> name
+//	labels    key NUL value NUL name -> empty
+//	reserved  name -> reservation time, for names claimed before their first save
+//	changes   big-endian sequence -> VMChange (without the record), trimmed
+//	backups   name.vN -> record as stored before a schema migration
+//	meta      store-wide counters such as the next generated name
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/vmstore/schema"
+	bolt "go.etcd.io/bbolt"
+)
+
+// FileName is the database file name under <base>/state.
+const FileName = "orchard.db"
+
+var (
+	bucketVMs      = []byte("vms")
+	bucketIDs      = []byte("ids")
+	bucketLabels   = []byte("labels")
+	bucketReserved = []byte("reserved")
+	bucketChanges  = []byte("changes")
+	bucketBackups  = []byte("backups")
+	bucketMeta     = []byte("meta")
+
+	keyNextName = []byte("nextName")
+)
+
+// changeLogSize bounds the changes bucket; watchers that fall further behind
+// than this miss intermediate revisions but still see the latest one.
+const changeLogSize = 1024
+
+type Store struct {
+	baseDir string
+	path    string
+	// Timeout bounds how long an operation waits for another process to release
+	// the database.
+	Timeout time.Duration
+	// PollInterval is how often Watch checks for new changes.
+	PollInterval time.Duration
+}
+
+func New(baseDir string) *Store {
+	return &Store{
+		baseDir:      baseDir,
+		path:         filepath.Join(baseDir, "state", FileName),
+		Timeout:      10 * time.Second,
+		PollInterval: 250 * time.Millisecond,
+	}
+}
+
+func (s *Store) vmDir(name string) string {
+	return filepath.Join(s.baseDir, "vms", name)
+}
+
+func (s *Store) update(fn func(tx *bolt.Tx) error) error {
+	db, err := s.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(fn)
+}
+
+func (s *Store) view(fn func(tx *bolt.Tx) error) error {
+	db, err := s.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+// open opens the database, creating it and its buckets on first use. Read-only
+// handles take a shared lock, so concurrent readers do not serialize.
+func (s *Store) open(readOnly bool) (*bolt.DB, error) {
+	if readOnly {
+		if _, err := os.Stat(s.path); errors.Is(err, os.ErrNotExist) {
+			readOnly = false
+		}
+	}
+	if !readOnly {
+		if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(s.path, 0o644, &bolt.Options{Timeout: s.Timeout, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("opening vm database: %w", err)
+	}
+	if readOnly {
+		return db, nil
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketVMs, bucketIDs, bucketLabels, bucketReserved, bucketChanges, bucketBackups, bucketMeta} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (s *Store) NextName(ctx context.Context) (string, error) {
+	var name string
+	err := s.update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		next := 1
+		if b := meta.Get(keyNextName); b != nil {
+			next, _ = strconv.Atoi(string(b))
+		}
+		// Skip over names already claimed, e.g. chosen explicitly by a user.
+		for {
+			name = fmt.Sprintf("vm-%03d", next)
+			next++
+			err := s.reserve(tx, name)
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, domain.ErrVMExists) {
+				return err
+			}
+		}
+		return meta.Put(keyNextName, []byte(strconv.Itoa(next)))
+	})
+	return name, err
+}
+
+func (s *Store) Reserve(ctx context.Context, name string) error {
+	if err := domain.ValidateName(name); err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error { return s.reserve(tx, name) })
+}
+
+// reserve claims name within tx. A leftover artifact directory also makes the name
+// unavailable, so a new VM never adopts another VM's disk.
+func (s *Store) reserve(tx *bolt.Tx, name string) error {
+	if claimed(tx, name) {
+		return fmt.Errorf("vm %s: %w", name, domain.ErrVMExists)
+	}
+	if _, err := os.Stat(s.vmDir(name)); err == nil {
+		return fmt.Errorf("vm %s: %w", name, domain.ErrVMExists)
+	}
+	return tx.Bucket(bucketReserved).Put([]byte(name), []byte(time.Now().UTC().Format(time.RFC3339Nano)))
+}
+
+// claimed reports whether name has a record or an outstanding reservation.
+func claimed(tx *bolt.Tx, name string) bool {
+	return tx.Bucket(bucketVMs).Get([]byte(name)) != nil || tx.Bucket(bucketReserved).Get([]byte(name)) != nil
+}
+
+func (s *Store) Save(ctx context.Context, vm *domain.VM) error {
+	var rec domain.VM
+	err := s.update(func(tx *bolt.Tx) error {
+		vms := tx.Bucket(bucketVMs)
+		if raw := vms.Get([]byte(vm.Name)); raw != nil {
+			cur, err := schema.Decode(raw)
+			if err != nil {
+				return fmt.Errorf("vm %s: %w", vm.Name, err)
+			}
+			if cur.Revision != vm.Revision {
+				return fmt.Errorf("vm %s: %w (saving revision %d, stored revision %d)", vm.Name, domain.ErrConflict, vm.Revision, cur.Revision)
+			}
+			if _, err := backup(tx, vm.Name, raw); err != nil {
+				return err
+			}
+			if err := unindex(tx, *cur); err != nil {
+				return err
+			}
+		} else if vm.Revision != 0 {
+			return fmt.Errorf("vm %s: %w (record was deleted)", vm.Name, domain.ErrConflict)
+		}
+		rec = *vm
+		if rec.CreatedAt == 0 {
+			rec.CreatedAt = time.Now().UnixNano()
+		}
+		if rec.ID == "" {
+			rec.ID = domain.NewID(time.Unix(0, rec.CreatedAt))
+		}
+		rec.Revision++
+		if err := put(tx, rec); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketReserved).Delete([]byte(rec.Name)); err != nil {
+			return err
+		}
+		return appendChange(tx, domain.VMChange{Type: domain.VMChangeSaved, Name: rec.Name, Revision: rec.Revision})
+	})
+	if err != nil {
+		return err
+	}
+	*vm = rec
+	return nil
+}
+
+// put writes rec and its index entries.
+func put(tx *bolt.Tx, rec domain.VM) error {
+	b, err := schema.Encode(rec)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketVMs).Put([]byte(rec.Name), b); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketIDs).Put([]byte(rec.ID), []byte(rec.Name)); err != nil {
+		return err
+	}
+	for k, v := range rec.Labels {
+		if err := tx.Bucket(bucketLabels).Put(labelKey(k, v, rec.Name), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindex removes the index entries of a stored record.
+func unindex(tx *bolt.Tx, rec domain.VM) error {
+	if rec.ID != "" {
+		if err := tx.Bucket(bucketIDs).Delete([]byte(rec.ID)); err != nil {
+			return err
+		}
+	}
+	for k, v := range rec.Labels {
+		if err := tx.Bucket(bucketLabels).Delete(labelKey(k, v, rec.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func labelKey(key, value, name string) []byte {
+	return []byte(key + "\x00" + value + "\x00" + name)
+}
+
+// Load resolves nameOrID as a VM name first, then as a full ID or unambiguous ID prefix.
+func (s *Store) Load(ctx context.Context, nameOrID string) (*domain.VM, error) {
+	var (
+		raw  []byte
+		name string
+	)
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		name, err = resolve(tx, nameOrID)
+		if err != nil {
+			return err
+		}
+		raw = bytes.Clone(tx.Bucket(bucketVMs).Get([]byte(name)))
+		if raw == nil {
+			return fmt.Errorf("%w: %s", domain.ErrVMNotFound, nameOrID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if v, err := schema.Version(raw); err == nil && v < schema.Current {
+		// Persist the upgrade once so older records are only migrated on first load.
+		if _, err := s.migrate(name, false); err != nil {
+			return nil, err
+		}
+		return s.Load(ctx, name)
+	}
+	vm, err := schema.Decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("vm %s: %w", name, err)
+	}
+	return vm, nil
+}
+
+// resolve maps nameOrID to a stored name using the ID index for IDs and prefixes.
+// A claimed name never falls back to ID matching.
+func resolve(tx *bolt.Tx, nameOrID string) (string, error) {
+	if domain.ValidateName(nameOrID) == nil && claimed(tx, nameOrID) {
+		return nameOrID, nil
+	}
+	prefix := strings.ToUpper(nameOrID)
+	if prefix == "" {
+		return "", fmt.Errorf("%w: %s", domain.ErrVMNotFound, nameOrID)
+	}
+	ids := tx.Bucket(bucketIDs)
+	if name := ids.Get([]byte(prefix)); name != nil {
+		return string(name), nil
+	}
+	var matches []string
+	c := ids.Cursor()
+	for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+		matches = append(matches, string(v))
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", domain.ErrVMNotFound, nameOrID)
+	case 1:
+		return matches[0], nil
+	}
+	sort.Strings(matches)
+	return "", &domain.AmbiguousIDError{Prefix: nameOrID, Candidates: matches}
+}
+
+// Delete removes the record, its index entries and its artifact directory.
+func (s *Store) Delete(ctx context.Context, nameOrID string) error {
+	var name string
+	err := s.update(func(tx *bolt.Tx) error {
+		var err error
+		if name, err = resolve(tx, nameOrID); err != nil {
+			if !errors.Is(err, domain.ErrVMNotFound) || domain.ValidateName(nameOrID) != nil {
+				return err
+			}
+			// An artifact directory without a record, e.g. from a failed up.
+			if _, serr := os.Stat(s.vmDir(nameOrID)); serr != nil {
+				return err
+			}
+			name = nameOrID
+			return nil
+		}
+		vms := tx.Bucket(bucketVMs)
+		if raw := vms.Get([]byte(name)); raw != nil {
+			cur, err := schema.Decode(raw)
+			if err == nil {
+				if err := unindex(tx, *cur); err != nil {
+					return err
+				}
+			}
+			if err := vms.Delete([]byte(name)); err != nil {
+				return err
+			}
+			if err := appendChange(tx, domain.VMChange{Type: domain.VMChangeDeleted, Name: name}); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketReserved).Delete([]byte(name))
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(s.vmDir(name))
+}
+
+// Rename re-keys the record and moves its artifact directory in one transaction.
+// The directory is moved back if the transaction fails to commit.
+func (s *Store) Rename(ctx context.Context, vm *domain.VM, newName string) error {
+	if err := domain.ValidateName(newName); err != nil {
+		return err
+	}
+	oldDir, newDir := s.vmDir(vm.Name), s.vmDir(newName)
+	moved := false
+	var rec domain.VM
+	err := s.update(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(bucketVMs).Get([]byte(vm.Name))
+		if raw == nil {
+			return fmt.Errorf("%w: %s", domain.ErrVMNotFound, vm.Name)
+		}
+		cur, err := schema.Decode(raw)
+		if err != nil {
+			return fmt.Errorf("vm %s: %w", vm.Name, err)
+		}
+		if cur.Revision != vm.Revision {
+			return fmt.Errorf("vm %s: %w (renaming revision %d, stored revision %d)", vm.Name, domain.ErrConflict, vm.Revision, cur.Revision)
+		}
+		if claimed(tx, newName) {
+			return fmt.Errorf("vm %s: %w", newName, domain.ErrVMExists)
+		}
+		if _, err := os.Stat(newDir); err == nil {
+			return fmt.Errorf("vm %s: %w", newName, domain.ErrVMExists)
+		}
+		if err := unindex(tx, *cur); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketVMs).Delete([]byte(vm.Name)); err != nil {
+			return err
+		}
+		rec = *vm
+		rec.Name = newName
+		domain.RebaseArtifacts(&rec, oldDir, newDir)
+		rec.Revision++
+		if err := put(tx, rec); err != nil {
+			return err
+		}
+		if err := appendChange(tx, domain.VMChange{Type: domain.VMChangeDeleted, Name: vm.Name}); err != nil {
+			return err
+		}
+		if err := appendChange(tx, domain.VMChange{Type: domain.VMChangeSaved, Name: newName, Revision: rec.Revision}); err != nil {
+			return err
+		}
+		if _, err := os.Stat(oldDir); err == nil {
+			if err := os.Rename(oldDir, newDir); err != nil {
+				return err
+			}
+			moved = true
+		}
+		return nil
+	})
+	if err != nil {
+		if moved {
+			_ = os.Rename(newDir, oldDir)
+		}
+		return err
+	}
+	*vm = rec
+	return nil
+}
+
+func (s *Store) List(ctx context.Context) ([]domain.VM, []domain.BrokenVM, error) {
+	var (
+		vms    []domain.VM
+		broken []domain.BrokenVM
+	)
+	err := s.view(func(tx *bolt.Tx) error {
+		err := tx.Bucket(bucketVMs).ForEach(func(k, v []byte) error {
+			vm, err := schema.Decode(v)
+			if err == nil {
+				vms = append(vms, *vm)
+				return nil
+			}
+			b := domain.BrokenVM{Name: string(k), Dir: s.vmDir(string(k)), Kind: domain.BrokenUnreadable, Error: err.Error()}
+			if b.Recovered = schema.Salvage(v); b.Recovered != nil {
+				b.Recovered.Name = b.Name
+			}
+			broken = append(broken, b)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// VM directories holding a disk that no record or reservation accounts for.
+		entries, err := os.ReadDir(filepath.Join(s.baseDir, "vms"))
+		if err != nil {
+			return nil
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if !e.IsDir() || strings.HasPrefix(name, ".") || claimed(tx, name) {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(s.vmDir(name), "disk.img")); err == nil {
+				broken = append(broken, domain.BrokenVM{Name: name, Dir: s.vmDir(name), Kind: domain.BrokenMissing, Error: "no VM record"})
+			}
+		}
+		return nil
+	})
+	sort.Slice(vms, func(i, j int) bool { return vms[i].CreatedAt < vms[j].CreatedAt })
+	return vms, broken, err
+}
+
+// Recover writes vm as a fresh record, keeping an unreadable one in the backups
+// bucket as name.broken.
+func (s *Store) Recover(ctx context.Context, vm *domain.VM) error {
+	if err := domain.ValidateName(vm.Name); err != nil {
+		return err
+	}
+	var rec domain.VM
+	err := s.update(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(bucketVMs).Get([]byte(vm.Name)); raw != nil {
+			if err := schema.CheckRecoverable(vm.Name, raw); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketBackups).Put([]byte(vm.Name+".broken"), bytes.Clone(raw)); err != nil {
+				return err
+			}
+		}
+		rec = *vm
+		if rec.ID == "" {
+			rec.ID = domain.NewID(time.Unix(0, rec.CreatedAt))
+		}
+		rec.Revision = 1
+		if err := put(tx, rec); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketReserved).Delete([]byte(rec.Name)); err != nil {
+			return err
+		}
+		return appendChange(tx, domain.VMChange{Type: domain.VMChangeSaved, Name: rec.Name, Revision: rec.Revision})
+	})
+	if err != nil {
+		return err
+	}
+	*vm = rec
+	return nil
+}
+
+// ListByLabel returns the VMs labelled key=value using the label index.
+func (s *Store) ListByLabel(ctx context.Context, key, value string) ([]domain.VM, error) {
+	var vms []domain.VM
+	err := s.view(func(tx *bolt.Tx) error {
+		prefix := []byte(key + "\x00" + value + "\x00")
+		c := tx.Bucket(bucketLabels).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			raw := tx.Bucket(bucketVMs).Get(k[len(prefix):])
+			if raw == nil {
+				continue
+			}
+			if vm, err := schema.Decode(raw); err == nil {
+				vms = append(vms, *vm)
+			}
+		}
+		return nil
+	})
+	sort.Slice(vms, func(i, j int) bool { return vms[i].CreatedAt < vms[j].CreatedAt })
+	return vms, err
+}
+
+func (s *Store) Migrate(ctx context.Context, dryRun bool) ([]domain.SchemaMigration, error) {
+	var names []string
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketVMs).ForEach(func(k, _ []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	var out []domain.SchemaMigration
+	for _, name := range names {
+		m, err := s.migrate(name, dryRun)
+		if errors.Is(err, domain.ErrVMNotFound) {
+			continue
+		}
+		if err != nil {
+			return out, fmt.Errorf("vm %s: %w", name, err)
+		}
+		if m != nil {
+			out = append(out, *m)
+		}
+	}
+	return out, nil
+}
+
+// migrate upgrades one stored record to the current schema, keeping a backup of
+// the original. It returns nil if the record is already current.
+func (s *Store) migrate(name string, dryRun bool) (*domain.SchemaMigration, error) {
+	var m *domain.SchemaMigration
+	fn := func(tx *bolt.Tx) error {
+		raw := tx.Bucket(bucketVMs).Get([]byte(name))
+		if raw == nil {
+			return fmt.Errorf("%w: %s", domain.ErrVMNotFound, name)
+		}
+		up, from, steps, err := schema.Upgrade(raw)
+		if err != nil {
+			return err
+		}
+		if from == schema.Current {
+			return nil
+		}
+		m = &domain.SchemaMigration{VM: name, From: from, To: schema.Current, Steps: steps}
+		if dryRun {
+			return nil
+		}
+		if m.Backup, err = backup(tx, name, raw); err != nil {
+			return err
+		}
+		vm, err := schema.Decode(up)
+		if err != nil {
+			return err
+		}
+		return put(tx, *vm)
+	}
+	if dryRun {
+		return m, s.view(fn)
+	}
+	return m, s.update(fn)
+}
+
+// backup keeps a copy of a record stored at an older schema version before it is
+// overwritten. Current records are not backed up. It returns a description of
+// where the copy lives.
+func backup(tx *bolt.Tx, name string, raw []byte) (string, error) {
+	v, err := schema.Version(raw)
+	if err != nil || v >= schema.Current {
+		return "", err
+	}
+	key := fmt.Sprintf("%s.v%d", name, v)
+	b := tx.Bucket(bucketBackups)
+	if b.Get([]byte(key)) == nil {
+		if err := b.Put([]byte(key), raw); err != nil {
+			return "", err
+		}
+	}
+	return "backups/" + key, nil
+}
+
+// ImportRecords stores each VM not already present, keeping its ID and revision.
+func (s *Store) ImportRecords(ctx context.Context, vms []domain.VM) ([]string, error) {
+	var imported []string
+	err := s.update(func(tx *bolt.Tx) error {
+		for _, vm := range vms {
+			if tx.Bucket(bucketVMs).Get([]byte(vm.Name)) != nil {
+				continue
+			}
+			if vm.ID == "" {
+				vm.ID = domain.NewID(time.Unix(0, vm.CreatedAt))
+			}
+			if err := put(tx, vm); err != nil {
+				return fmt.Errorf("vm %s: %w", vm.Name, err)
+			}
+			if err := tx.Bucket(bucketReserved).Delete([]byte(vm.Name)); err != nil {
+				return err
+			}
+			if err := appendChange(tx, domain.VMChange{Type: domain.VMChangeSaved, Name: vm.Name, Revision: vm.Revision}); err != nil {
+				return err
+			}
+			imported = append(imported, vm.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// appendChange records c in the change log and trims the oldest entry beyond
+// changeLogSize.
+func appendChange(tx *bolt.Tx, c domain.VMChange) error {
+	b := tx.Bucket(bucketChanges)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(seqKey(seq), v); err != nil {
+		return err
+	}
+	if seq > changeLogSize {
+		return b.Delete(seqKey(seq - changeLogSize))
+	}
+	return nil
+}
+
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// Watch streams changes committed after the call, by this or any other process.
+// Saved changes carry the record as of the time they are delivered.
+func (s *Store) Watch(ctx context.Context) (<-chan domain.VMChange, error) {
+	var last uint64
+	err := s.view(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(bucketChanges).Cursor().Last(); k != nil {
+			last = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan domain.VMChange)
+	go func() {
+		defer close(ch)
+		t := time.NewTicker(s.PollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			var batch []domain.VMChange
+			_ = s.view(func(tx *bolt.Tx) error {
+				c := tx.Bucket(bucketChanges).Cursor()
+				for k, v := c.Seek(seqKey(last + 1)); k != nil; k, v = c.Next() {
+					last = binary.BigEndian.Uint64(k)
+					var change domain.VMChange
+					if json.Unmarshal(v, &change) != nil {
+						continue
+					}
+					if change.Type == domain.VMChangeSaved {
+						if raw := tx.Bucket(bucketVMs).Get([]byte(change.Name)); raw != nil {
+							change.VM, _ = schema.Decode(raw)
+						}
+					}
+					batch = append(batch, change)
+				}
+				return nil
+			})
+			for _, c := range batch {
+				select {
+				case ch <- c:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+var (
+	_ domain.VMStore        = (*Store)(nil)
+	_ domain.VMWatcher      = (*Store)(nil)
+	_ domain.LabelIndex     = (*Store)(nil)
+	_ domain.RecordImporter = (*Store)(nil)
+)
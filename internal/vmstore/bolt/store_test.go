@@ -0,0 +1,101 @@
+package bolt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	fsstore "github.com/alechenninger/orchard/internal/vmstore/fs"
+	"github.com/alechenninger/orchard/internal/vmstore/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) domain.VMStore {
+		return New(t.TempDir())
+	})
+}
+
+func TestWatchSeesOtherHandles(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+	watcher := New(dir)
+	watcher.PollInterval = 10 * time.Millisecond
+	// Changes made before Watch is called are not replayed.
+	if err := New(dir).Save(ctx, &domain.VM{Name: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := watcher.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := New(dir)
+	vm := &domain.VM{Name: "web", VMSpec: domain.VMSpec{CPUs: 2}}
+	if err := writer.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Delete(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []domain.VMChange
+	for len(got) < 2 {
+		select {
+		case c := <-changes:
+			got = append(got, c)
+		case <-ctx.Done():
+			t.Fatalf("timed out; got %+v", got)
+		}
+	}
+	if got[0].Type != domain.VMChangeSaved || got[0].Name != "web" || got[0].Revision != 1 {
+		t.Errorf("first change = %+v", got[0])
+	}
+	if got[1].Type != domain.VMChangeDeleted || got[1].Name != "web" || got[1].VM != nil {
+		t.Errorf("second change = %+v", got[1])
+	}
+	cancel()
+	for range changes {
+	}
+}
+
+func TestImportFromJSONStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	src := fsstore.New(dir)
+	vm := &domain.VM{Name: "web", VMSpec: domain.VMSpec{CPUs: 2}, Labels: map[string]string{"env": "prod"}}
+	if err := src.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	vms, _, err := src.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := New(dir)
+	names, err := dst.ImportRecords(ctx, vms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "web" {
+		t.Fatalf("imported %v", names)
+	}
+	got, err := dst.Load(ctx, vm.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "web" || got.Revision != 2 || got.CPUs != 2 {
+		t.Fatalf("imported record %+v does not match %+v", got, vm)
+	}
+	if byLabel, _ := dst.ListByLabel(ctx, "env", "prod"); len(byLabel) != 1 {
+		t.Fatalf("label index not populated: %v", byLabel)
+	}
+	// Importing again is a no-op.
+	if names, err := dst.ImportRecords(ctx, vms); err != nil || len(names) != 0 {
+		t.Fatalf("re-import: %v, %v", names, err)
+	}
+}
@@ -0,0 +1,76 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// lock takes the store-wide advisory lock shared by every orchard process (CLI and
+// shims alike). flock(2) locks are released by the kernel if the holder dies, so a
+// crash can never wedge the store. Filesystems other than the host's (e.g. in-memory
+// ones used by tests) only get the in-process mutex.
+func (s *Store) lock() (unlock func(), err error) {
+	s.mu.Lock()
+	if _, ok := s.fs.(*afero.OsFs); !ok {
+		return s.mu.Unlock, nil
+	}
+	p := filepath.Join(s.baseDir, "state", "store.lock")
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		s.mu.Unlock()
+		return nil, fmt.Errorf("locking store: %w", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+		s.mu.Unlock()
+	}, nil
+}
+
+// writeFileAtomic replaces path with data so readers see either the old or the new
+// contents, never a torn write: write a temp file, fsync it, rename it into place
+// and fsync the directory so the rename itself is durable.
+func writeFileAtomic(fsys afero.Fs, path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := afero.TempFile(fsys, dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		_ = fsys.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		_ = fsys.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = fsys.Remove(tmp)
+		return err
+	}
+	if err := fsys.Rename(tmp, path); err != nil {
+		_ = fsys.Remove(tmp)
+		return err
+	}
+	if df, err := fsys.Open(dir); err == nil {
+		_ = df.Sync()
+		_ = df.Close()
+	}
+	return nil
+}
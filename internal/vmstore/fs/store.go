@@ -14,6 +14,7 @@ import (
 	"os"
 
 	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/vmstore/schema"
 	"github.com/spf13/afero"
 )
 
@@ -27,11 +28,6 @@ func New(baseDir string) *Store { return &Store{baseDir: baseDir, fs: afero.NewO
 
 func NewWithFS(baseDir string, fsys afero.Fs) *Store { return &Store{baseDir: baseDir, fs: fsys} }
 
-// NewDefault constructs a Store rooted at the default base directory.
-func NewDefault() *Store {
-	return New(DefaultBaseDir())
-}
-
 func (s *Store) ensureDirs() error {
 	af := &afero.Afero{Fs: s.fs}
 	return af.MkdirAll(filepath.Join(s.baseDir, "vms"), 0o755)
@@ -42,8 +38,11 @@ func (s *Store) vmDir(name string) string {
 }
 
 func (s *Store) NextName(ctx context.Context) (string, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.lock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
 	af := &afero.Afero{Fs: s.fs}
 	if err := s.ensureDirs(); err != nil {
 		return "", err
@@ -57,90 +56,399 @@ func (s *Store) NextName(ctx context.Context) (string, error) {
 	if b, err := af.ReadFile(seqFile); err == nil {
 		_ = json.Unmarshal(b, &st)
 	}
-	name := fmt.Sprintf("vm-%03d", st.Next)
-	st.Next++
+	// Skip over names already claimed, e.g. chosen explicitly by a user.
+	var name string
+	for {
+		name = fmt.Sprintf("vm-%03d", st.Next)
+		st.Next++
+		err := s.reserve(name)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, domain.ErrVMExists) {
+			return "", err
+		}
+	}
 	b, _ := json.MarshalIndent(st, "", "  ")
-	if err := af.WriteFile(seqFile, b, 0o644); err != nil {
+	if err := writeFileAtomic(s.fs, seqFile, b); err != nil {
 		return "", err
 	}
 	return name, nil
 }
 
-func (s *Store) Save(ctx context.Context, vm domain.VM) error {
+func (s *Store) Reserve(ctx context.Context, name string) error {
+	if err := domain.ValidateName(name); err != nil {
+		return err
+	}
+	if err := s.ensureDirs(); err != nil {
+		return err
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.reserve(name)
+}
+
+// reserve claims name by creating its directory; mkdir is atomic, so only one
+// caller (in any process) can win.
+func (s *Store) reserve(name string) error {
+	if err := s.fs.Mkdir(s.vmDir(name), 0o755); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("vm %s: %w", name, domain.ErrVMExists)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Store) Save(ctx context.Context, vm *domain.VM) error {
 	af := &afero.Afero{Fs: s.fs}
 	if err := s.ensureDirs(); err != nil {
 		return err
 	}
-	d := s.vmDir(vm.Name)
-	if err := af.MkdirAll(d, 0o755); err != nil {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := af.MkdirAll(s.vmDir(vm.Name), 0o755); err != nil {
 		return err
 	}
-	if vm.CreatedAt == 0 {
-		vm.CreatedAt = time.Now().UnixNano()
+	raw, err := s.readRaw(vm.Name)
+	switch {
+	case err == nil:
+		cur, err := schema.Decode(raw)
+		if err != nil {
+			return fmt.Errorf("vm %s: %w", vm.Name, err)
+		}
+		if cur.Revision != vm.Revision {
+			return fmt.Errorf("vm %s: %w (saving revision %d, stored revision %d)", vm.Name, domain.ErrConflict, vm.Revision, cur.Revision)
+		}
+		if _, err := s.backup(vm.Name, raw); err != nil {
+			return err
+		}
+	case errors.Is(err, domain.ErrVMNotFound):
+		if vm.Revision != 0 {
+			return fmt.Errorf("vm %s: %w (record was deleted)", vm.Name, domain.ErrConflict)
+		}
+	default:
+		return err
+	}
+	rec := *vm
+	if rec.CreatedAt == 0 {
+		rec.CreatedAt = time.Now().UnixNano()
+	}
+	if rec.ID == "" {
+		rec.ID = domain.NewID(time.Unix(0, rec.CreatedAt))
+	}
+	rec.Revision++
+	b, err := schema.Encode(rec)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.fs, s.configPath(vm.Name), b); err != nil {
+		return err
 	}
-	b, _ := json.MarshalIndent(vm, "", "  ")
-	return af.WriteFile(filepath.Join(d, "config.json"), b, 0o644)
+	*vm = rec
+	return nil
 }
 
+// Load resolves nameOrID as a VM name first, then as a full ID or unambiguous ID prefix.
 func (s *Store) Load(ctx context.Context, nameOrID string) (*domain.VM, error) {
-	af := &afero.Afero{Fs: s.fs}
 	if err := s.ensureDirs(); err != nil {
 		return nil, err
 	}
-	d := s.vmDir(nameOrID)
-	b, err := af.ReadFile(filepath.Join(d, "config.json"))
+	vm, err := s.loadByName(ctx, nameOrID)
+	if !errors.Is(err, domain.ErrVMNotFound) || s.exists(nameOrID) {
+		return vm, err
+	}
+	vms, _, lerr := s.List(ctx)
+	if lerr != nil {
+		return nil, lerr
+	}
+	return domain.MatchID(vms, nameOrID)
+}
+
+func (s *Store) loadByName(ctx context.Context, name string) (*domain.VM, error) {
+	raw, err := s.readRaw(name)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, fmt.Errorf("vm %s not found", nameOrID)
-		}
 		return nil, err
 	}
-	var vm domain.VM
-	if err := json.Unmarshal(b, &vm); err != nil {
+	if v, err := schema.Version(raw); err == nil && v < schema.Current {
+		// Persist the upgrade once so older records are only migrated on first load.
+		if _, err := s.migrate(name, false); err != nil {
+			return nil, err
+		}
+		if raw, err = s.readRaw(name); err != nil {
+			return nil, err
+		}
+	}
+	vm, err := schema.Decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("vm %s: %w", name, err)
+	}
+	// The directory is authoritative: a rename interrupted between moving the
+	// directory and rewriting the record still resolves under the new name.
+	vm.Name = name
+	return vm, nil
+}
+
+func (s *Store) readRaw(name string) ([]byte, error) {
+	af := &afero.Afero{Fs: s.fs}
+	if domain.ValidateName(name) != nil {
+		return nil, fmt.Errorf("%w: %s", domain.ErrVMNotFound, name)
+	}
+	b, err := af.ReadFile(s.configPath(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", domain.ErrVMNotFound, name)
+		}
 		return nil, err
 	}
-	return &vm, nil
+	return b, nil
 }
 
+func (s *Store) configPath(name string) string { return filepath.Join(s.vmDir(name), "config.json") }
+
 func (s *Store) Delete(ctx context.Context, nameOrID string) error {
 	af := &afero.Afero{Fs: s.fs}
 	if err := s.ensureDirs(); err != nil {
 		return err
 	}
-	d := s.vmDir(nameOrID)
-	return af.RemoveAll(d)
+	name := nameOrID
+	if !s.exists(name) {
+		vm, err := s.Load(ctx, nameOrID)
+		if err != nil {
+			return err
+		}
+		name = vm.Name
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return af.RemoveAll(s.vmDir(name))
+}
+
+// Rename moves the VM directory with a single rename(2), so artifacts are never
+// split between the two names, then rewrites the record under the new name.
+func (s *Store) Rename(ctx context.Context, vm *domain.VM, newName string) error {
+	if err := domain.ValidateName(newName); err != nil {
+		return err
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	raw, err := s.readRaw(vm.Name)
+	if err != nil {
+		return err
+	}
+	cur, err := schema.Decode(raw)
+	if err != nil {
+		return fmt.Errorf("vm %s: %w", vm.Name, err)
+	}
+	if cur.Revision != vm.Revision {
+		return fmt.Errorf("vm %s: %w (renaming revision %d, stored revision %d)", vm.Name, domain.ErrConflict, vm.Revision, cur.Revision)
+	}
+	if _, err := s.fs.Stat(s.vmDir(newName)); err == nil {
+		return fmt.Errorf("vm %s: %w", newName, domain.ErrVMExists)
+	}
+	oldDir, newDir := s.vmDir(vm.Name), s.vmDir(newName)
+	if err := s.fs.Rename(oldDir, newDir); err != nil {
+		return err
+	}
+	rec := *vm
+	rec.Name = newName
+	domain.RebaseArtifacts(&rec, oldDir, newDir)
+	rec.Revision++
+	b, err := schema.Encode(rec)
+	if err == nil {
+		err = writeFileAtomic(s.fs, s.configPath(newName), b)
+	}
+	if err != nil {
+		_ = s.fs.Rename(newDir, oldDir)
+		return err
+	}
+	*vm = rec
+	return nil
+}
+
+// exists reports whether a directory is claimed for name, even if it has no record yet.
+func (s *Store) exists(name string) bool {
+	if domain.ValidateName(name) != nil {
+		return false
+	}
+	_, err := s.fs.Stat(s.vmDir(name))
+	return err == nil
 }
 
-func (s *Store) List(ctx context.Context) ([]domain.VM, error) {
+func (s *Store) List(ctx context.Context) ([]domain.VM, []domain.BrokenVM, error) {
 	af := &afero.Afero{Fs: s.fs}
 	if err := s.ensureDirs(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	entries, err := af.ReadDir(filepath.Join(s.baseDir, "vms"))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	var vms []domain.VM
+	var (
+		vms    []domain.VM
+		broken []domain.BrokenVM
+	)
 	for _, e := range entries {
-		if !e.IsDir() || !strings.HasPrefix(e.Name(), "vm-") {
+		// Hidden entries are staging areas (e.g. in-progress imports), not VMs.
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
 			continue
 		}
-		vm, err := s.Load(ctx, e.Name())
-		if err == nil && vm != nil {
+		vm, err := s.loadByName(ctx, e.Name())
+		if err == nil {
 			vms = append(vms, *vm)
+			continue
+		}
+		if b, ok := s.broken(e.Name(), err); ok {
+			broken = append(broken, b)
 		}
 	}
 	sort.Slice(vms, func(i, j int) bool { return vms[i].CreatedAt < vms[j].CreatedAt })
-	return vms, nil
+	return vms, broken, nil
 }
 
-var _ domain.VMStore = (*Store)(nil)
+// broken describes why name failed to load. A directory without a record is only
+// reported if it holds a disk; an empty one is a reservation for a VM being created.
+func (s *Store) broken(name string, err error) (domain.BrokenVM, bool) {
+	b := domain.BrokenVM{Name: name, Dir: s.vmDir(name), Kind: domain.BrokenUnreadable, Error: err.Error()}
+	if errors.Is(err, domain.ErrVMNotFound) {
+		if _, serr := s.fs.Stat(filepath.Join(s.vmDir(name), "disk.img")); serr != nil {
+			return b, false
+		}
+		b.Kind, b.Error = domain.BrokenMissing, "no VM record"
+		return b, true
+	}
+	if raw, rerr := s.readRaw(name); rerr == nil {
+		if b.Recovered = schema.Salvage(raw); b.Recovered != nil {
+			b.Recovered.Name = name
+		}
+	}
+	return b, true
+}
+
+// Recover writes vm as a fresh record, moving an unreadable one aside to
+// config.json.broken.bak.
+func (s *Store) Recover(ctx context.Context, vm *domain.VM) error {
+	if err := domain.ValidateName(vm.Name); err != nil {
+		return err
+	}
+	af := &afero.Afero{Fs: s.fs}
+	if err := af.MkdirAll(s.vmDir(vm.Name), 0o755); err != nil {
+		return err
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	raw, err := s.readRaw(vm.Name)
+	switch {
+	case err == nil:
+		if err := schema.CheckRecoverable(vm.Name, raw); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(s.fs, s.configPath(vm.Name)+".broken.bak", raw); err != nil {
+			return err
+		}
+	case !errors.Is(err, domain.ErrVMNotFound):
+		return err
+	}
+	rec := *vm
+	if rec.ID == "" {
+		rec.ID = domain.NewID(time.Unix(0, rec.CreatedAt))
+	}
+	rec.Revision = 1
+	b, err := schema.Encode(rec)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.fs, s.configPath(vm.Name), b); err != nil {
+		return err
+	}
+	*vm = rec
+	return nil
+}
+
+func (s *Store) Migrate(ctx context.Context, dryRun bool) ([]domain.SchemaMigration, error) {
+	af := &afero.Afero{Fs: s.fs}
+	if err := s.ensureDirs(); err != nil {
+		return nil, err
+	}
+	entries, err := af.ReadDir(filepath.Join(s.baseDir, "vms"))
+	if err != nil {
+		return nil, err
+	}
+	var out []domain.SchemaMigration
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		m, err := s.migrate(e.Name(), dryRun)
+		if errors.Is(err, domain.ErrVMNotFound) {
+			continue
+		}
+		if err != nil {
+			return out, fmt.Errorf("vm %s: %w", e.Name(), err)
+		}
+		if m != nil {
+			out = append(out, *m)
+		}
+	}
+	return out, nil
+}
 
-// DefaultBaseDir returns the default base directory for VM state.
-func DefaultBaseDir() string {
-	home, err := os.UserHomeDir()
+// migrate upgrades one stored record to the current schema, keeping a backup of
+// the original. It returns nil if the record is already current.
+func (s *Store) migrate(name string, dryRun bool) (*domain.SchemaMigration, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	raw, err := s.readRaw(name)
+	if err != nil {
+		return nil, err
+	}
+	up, from, steps, err := schema.Upgrade(raw)
 	if err != nil {
-		return filepath.Join(os.TempDir(), "orchard")
+		return nil, err
+	}
+	if from == schema.Current {
+		return nil, nil
+	}
+	m := &domain.SchemaMigration{VM: name, From: from, To: schema.Current, Steps: steps}
+	if dryRun {
+		return m, nil
 	}
-	return filepath.Join(home, ".orchard")
+	if m.Backup, err = s.backup(name, raw); err != nil {
+		return nil, err
+	}
+	return m, writeFileAtomic(s.fs, s.configPath(name), up)
 }
+
+// backup keeps a copy of a record stored at an older schema version before it is
+// overwritten, as config.json.vN.bak. Current records are not backed up.
+func (s *Store) backup(name string, raw []byte) (string, error) {
+	v, err := schema.Version(raw)
+	if err != nil || v >= schema.Current {
+		return "", err
+	}
+	p := fmt.Sprintf("%s.v%d.bak", s.configPath(name), v)
+	if _, err := s.fs.Stat(p); err == nil {
+		return p, nil
+	}
+	return p, writeFileAtomic(s.fs, p, raw)
+}
+
+var _ domain.VMStore = (*Store)(nil)
@@ -0,0 +1,176 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/alechenninger/orchard/internal/vmstore/schema"
+	"github.com/alechenninger/orchard/internal/vmstore/storetest"
+	"github.com/spf13/afero"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) domain.VMStore {
+		return NewWithFS("/root", afero.NewMemMapFs())
+	})
+}
+
+func TestSaveRejectsStaleRevision(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := NewWithFS("/root", afero.NewMemMapFs())
+
+	vm := &domain.VM{Name: "vm-001", VMSpec: domain.VMSpec{CPUs: 1}}
+	if err := store.Save(ctx, vm); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if vm.Revision != 1 || vm.ID == "" {
+		t.Fatalf("expected revision 1 and an id, got %+v", vm)
+	}
+
+	a, _ := store.Load(ctx, "vm-001")
+	b, _ := store.Load(ctx, "vm-001")
+	a.CPUs = 2
+	if err := store.Save(ctx, a); err != nil {
+		t.Fatalf("first writer: %v", err)
+	}
+	b.CPUs = 4
+	if err := store.Save(ctx, b); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("expected ErrConflict for stale writer, got %v", err)
+	}
+	got, _ := store.Load(ctx, "vm-001")
+	if got.CPUs != 2 || got.Revision != 2 {
+		t.Fatalf("expected first write to survive, got %+v", got)
+	}
+
+	// Creating over an existing record is also a conflict.
+	if err := store.Save(ctx, &domain.VM{Name: "vm-001"}); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("expected ErrConflict creating existing record, got %v", err)
+	}
+}
+
+func TestSaveLeavesNoTempFiles(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	fsys := afero.NewMemMapFs()
+	store := NewWithFS("/root", fsys)
+	if _, err := store.NextName(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(ctx, &domain.VM{Name: "vm-001"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = afero.Walk(fsys, "/root", func(p string, _ os.FileInfo, _ error) error {
+		if strings.HasSuffix(p, ".tmp") {
+			t.Errorf("leftover temp file %s", p)
+		}
+		return nil
+	})
+}
+
+// TestNextNameAcrossProcesses races several processes allocating names in one
+// store and checks that no name is handed out twice.
+func TestNextNameAcrossProcesses(t *testing.T) {
+	if dir := os.Getenv("ORCHARD_STORE_HELPER_DIR"); dir != "" {
+		store := New(dir)
+		for i := 0; i < 10; i++ {
+			name, err := store.NextName(context.Background())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Println(name)
+		}
+		os.Exit(0)
+	}
+
+	dir := t.TempDir()
+	const procs = 4
+	outs := make([][]byte, procs)
+	errs := make(chan error, procs)
+	for i := 0; i < procs; i++ {
+		go func(i int) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestNextNameAcrossProcesses$")
+			cmd.Env = append(os.Environ(), "ORCHARD_STORE_HELPER_DIR="+dir)
+			out, err := cmd.Output()
+			outs[i] = out
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < procs; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("helper failed: %v", err)
+		}
+	}
+	seen := map[string]bool{}
+	for _, out := range outs {
+		for _, name := range strings.Fields(string(out)) {
+			if seen[name] {
+				t.Fatalf("name %s allocated twice", name)
+			}
+			seen[name] = true
+		}
+	}
+	if len(seen) != procs*10 {
+		t.Fatalf("expected %d names, got %d", procs*10, len(seen))
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "vms"))
+	if len(entries) != procs*10 {
+		t.Fatalf("expected %d reserved directories, got %d", procs*10, len(entries))
+	}
+}
+
+func TestLoadMigratesOldRecordsAndKeepsBackup(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	fsys := afero.NewMemMapFs()
+	store := NewWithFS("/root", fsys)
+	v0 := []byte(`{"id":"","name":"vm-001","createdAt":5,"cpus":2,"status":"stopped"}`)
+	_ = afero.WriteFile(fsys, "/root/vms/vm-001/config.json", v0, 0o644)
+
+	migs, err := store.Migrate(ctx, true)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if len(migs) != 1 || migs[0].From != 0 || migs[0].To != schema.Current || migs[0].Backup != "" {
+		t.Fatalf("unexpected plan: %+v", migs)
+	}
+	if b, _ := afero.ReadFile(fsys, "/root/vms/vm-001/config.json"); string(b) != string(v0) {
+		t.Fatalf("check must not modify the record")
+	}
+
+	vm, err := store.Load(ctx, "vm-001")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if vm.ID == "" || vm.CPUs != 2 {
+		t.Fatalf("unexpected migrated record: %+v", vm)
+	}
+	if b, err := afero.ReadFile(fsys, "/root/vms/vm-001/config.json.v0.bak"); err != nil || string(b) != string(v0) {
+		t.Fatalf("expected backup of original record, got %q: %v", b, err)
+	}
+	b, _ := afero.ReadFile(fsys, "/root/vms/vm-001/config.json")
+	if v, _ := schema.Version(b); v != schema.Current {
+		t.Fatalf("expected stored record at v%d, got v%d", schema.Current, v)
+	}
+	if migs, _ := store.Migrate(ctx, true); len(migs) != 0 {
+		t.Fatalf("expected nothing left to migrate, got %+v", migs)
+	}
+}
+
+func TestLoadRejectsNewerSchema(t *testing.T) {
+	t.Parallel()
+	fsys := afero.NewMemMapFs()
+	store := NewWithFS("/root", fsys)
+	_ = afero.WriteFile(fsys, "/root/vms/vm-001/config.json", []byte(`{"schemaVersion":99,"name":"vm-001"}`), 0o644)
+	if _, err := store.Load(context.Background(), "vm-001"); err == nil {
+		t.Fatalf("expected error loading record from a newer orchard")
+	}
+}
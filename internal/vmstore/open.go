@@ -0,0 +1,22 @@
+// Package vmstore selects a domain.VMStore backend.
+package vmstore
+
+import (
+	"fmt"
+
+	"github.com/alechenninger/orchard/internal/config"
+	"github.com/alechenninger/orchard/internal/domain"
+	boltstore "github.com/alechenninger/orchard/internal/vmstore/bolt"
+	fsstore "github.com/alechenninger/orchard/internal/vmstore/fs"
+)
+
+// Open returns the store backend named by kind, rooted at baseDir.
+func Open(kind, baseDir string) (domain.VMStore, error) {
+	switch kind {
+	case config.StoreJSON, "":
+		return fsstore.New(baseDir), nil
+	case config.StoreBolt:
+		return boltstore.New(baseDir), nil
+	}
+	return nil, fmt.Errorf("unknown store backend %q", kind)
+}
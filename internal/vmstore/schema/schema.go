@@ -0,0 +1,197 @@
+// Package schema versions the JSON encoding of stored VM records and upgrades
+// records written by older orchard releases.
+//
+// Every record carries a top-level "schemaVersion". Records written before
+// versioning existed have no such field and are treated as version 0. Each
+// migration upgrades a record by exactly one version and operates on the raw JSON
+// object, so it can still read fields that no longer exist on domain.VM.
+package schema
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+	"github.com/oklog/ulid/v2"
+)
+
+// Current is the schema version written by this build.
+const Current = 2
+
+type migration struct {
+	from        int
+	description string
+	apply       func(rec map[string]any) error
+}
+
+// migrations is the ordered registry of upgrade steps; migrations[i].from == i.
+var migrations = []migration{
+	{from: 0, description: "add schemaVersion and backfill missing VM id", apply: backfillID},
+	{from: 1, description: "drop stored runtime fields (pid, consoleSock, status); status is observed live", apply: dropRuntimeFields},
+}
+
+// Upgrade brings a stored record up to Current. It returns the upgraded record,
+// the version it was stored at and a description of each step applied. Records
+// already at Current are returned unchanged.
+func Upgrade(b []byte) (out []byte, from int, steps []string, err error) {
+	rec, err := decodeObject(b)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	from, err = versionOf(rec)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if from > Current {
+		return nil, from, nil, fmt.Errorf("record schema version %d is newer than supported version %d; upgrade orchard", from, Current)
+	}
+	if from == Current {
+		return b, from, nil, nil
+	}
+	for v := from; v < Current; v++ {
+		m := migrations[v]
+		if err := m.apply(rec); err != nil {
+			return nil, from, steps, fmt.Errorf("migrating schema v%d to v%d: %w", v, v+1, err)
+		}
+		rec["schemaVersion"] = v + 1
+		steps = append(steps, fmt.Sprintf("v%d→v%d: %s", v, v+1, m.description))
+	}
+	out, err = json.MarshalIndent(rec, "", "  ")
+	return out, from, steps, err
+}
+
+// Encode serializes vm at the current schema version.
+func Encode(vm domain.VM) ([]byte, error) {
+	return json.MarshalIndent(record{SchemaVersion: Current, VM: vm}, "", "  ")
+}
+
+// Decode upgrades and parses a stored record.
+func Decode(b []byte) (*domain.VM, error) {
+	up, _, _, err := Upgrade(b)
+	if err != nil {
+		return nil, err
+	}
+	var r record
+	if err := json.Unmarshal(up, &r); err != nil {
+		return nil, err
+	}
+	return &r.VM, nil
+}
+
+// Salvage recovers what it can from a record that fails to decode. Fields of the
+// wrong type are skipped; if the record is not valid JSON (e.g. truncated), each
+// "key": value line written by Encode is decoded on its own. It returns nil if
+// nothing could be recovered.
+func Salvage(b []byte) *domain.VM {
+	var r record
+	err := json.Unmarshal(b, &r)
+	var typeErr *json.UnmarshalTypeError
+	if err != nil && !errors.As(err, &typeErr) {
+		r = record{}
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+			if strings.HasPrefix(line, `"`) {
+				_ = json.Unmarshal([]byte("{"+line+"}"), &r)
+			}
+		}
+	}
+	if reflect.ValueOf(r.VM).IsZero() {
+		return nil
+	}
+	return &r.VM
+}
+
+// CheckRecoverable reports whether a stored record may be replaced by a rebuilt
+// one. Records that decode, and records written by a newer orchard that this build
+// merely cannot read, must be kept.
+func CheckRecoverable(name string, raw []byte) error {
+	if _, err := Decode(raw); err == nil {
+		return fmt.Errorf("vm %s has a readable record; nothing to repair", name)
+	}
+	if v, err := Version(raw); err == nil && v > Current {
+		return fmt.Errorf("vm %s was written by a newer orchard (schema v%d); upgrade orchard instead of repairing", name, v)
+	}
+	return nil
+}
+
+// Version reports the schema version a stored record was written at.
+func Version(b []byte) (int, error) {
+	rec, err := decodeObject(b)
+	if err != nil {
+		return 0, err
+	}
+	return versionOf(rec)
+}
+
+// decodeObject parses a record keeping numbers as json.Number so that large
+// integers such as createdAt survive a round trip without float rounding.
+func decodeObject(b []byte) (map[string]any, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var rec map[string]any
+	if err := d.Decode(&rec); err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("record is not a JSON object")
+	}
+	return rec, nil
+}
+
+type record struct {
+	SchemaVersion int `json:"schemaVersion"`
+	domain.VM
+}
+
+func versionOf(rec map[string]any) (int, error) {
+	raw, ok := rec["schemaVersion"]
+	if !ok {
+		return 0, nil
+	}
+	n, ok := raw.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("invalid schemaVersion %v", raw)
+	}
+	v, err := n.Int64()
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid schemaVersion %v", raw)
+	}
+	return int(v), nil
+}
+
+// backfillID gives version 0 records (which predate VM IDs) an ID. The ID is derived
+// from the record's creation time and name so that migrating the same record twice,
+// e.g. from two processes, yields the same ID.
+func backfillID(rec map[string]any) error {
+	if id, _ := rec["id"].(string); id != "" {
+		return nil
+	}
+	name, _ := rec["name"].(string)
+	var created int64
+	if n, ok := rec["createdAt"].(json.Number); ok {
+		created, _ = n.Int64()
+	}
+	seed := sha256.Sum256([]byte(name))
+	id, err := ulid.New(ulid.Timestamp(time.Unix(0, created)), bytes.NewReader(seed[:]))
+	if err != nil {
+		return err
+	}
+	rec["id"] = id.String()
+	return nil
+}
+
+// dropRuntimeFields removes the runtime fields version 1 records stored alongside
+// the spec. They were only ever a guess at whether the VM was running; the status
+// is now observed from the runtime state instead.
+func dropRuntimeFields(rec map[string]any) error {
+	for _, k := range []string{"pid", "consoleSock", "status"} {
+		delete(rec, k)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package schema
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// TestUpgradeGolden upgrades a sample record of every historical schema version
+// (testdata/vN.json) and compares the result with testdata/vN.golden.json.
+func TestUpgradeGolden(t *testing.T) {
+	inputs, _ := filepath.Glob("testdata/v*.json")
+	seen := map[int]bool{}
+	for _, in := range inputs {
+		if strings.HasSuffix(in, ".golden.json") {
+			continue
+		}
+		t.Run(filepath.Base(in), func(t *testing.T) {
+			b, err := os.ReadFile(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := Version(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen[v] = true
+			got, from, _, err := Upgrade(b)
+			if err != nil {
+				t.Fatalf("upgrade: %v", err)
+			}
+			if from != v {
+				t.Fatalf("expected from=%d, got %d", v, from)
+			}
+			golden := strings.TrimSuffix(in, ".json") + ".golden.json"
+			if *update {
+				if err := os.WriteFile(golden, append(got, '\n'), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(bytes.TrimSpace(got), bytes.TrimSpace(want)) {
+				t.Fatalf("upgrade of %s differs from golden:\n%s\nwant:\n%s", in, got, want)
+			}
+			again, _, steps, err := Upgrade(got)
+			if err != nil || len(steps) != 0 || !bytes.Equal(again, got) {
+				t.Fatalf("upgraded record is not stable: steps=%v err=%v", steps, err)
+			}
+			if _, err := Decode(got); err != nil {
+				t.Fatalf("decode upgraded record: %v", err)
+			}
+		})
+	}
+	for v := 0; v <= Current; v++ {
+		if !seen[v] {
+			t.Errorf("missing testdata/v%d.json sample for schema version %d", v, v)
+		}
+	}
+}
+
+func TestUpgradeRejectsNewerVersion(t *testing.T) {
+	t.Parallel()
+	if _, _, _, err := Upgrade([]byte(`{"schemaVersion": 99, "name": "vm-001"}`)); err == nil {
+		t.Fatalf("expected error for newer schema version")
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	t.Parallel()
+	vm := domain.VM{ID: "01J000CCCCCCCCCCCCCCCCCCCC", Name: "api-dev", VMSpec: domain.VMSpec{CPUs: 4}, Revision: 3, Labels: map[string]string{"team": "payments"}}
+	b, err := Encode(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := Version(b); v != Current {
+		t.Fatalf("expected version %d, got %d", Current, v)
+	}
+	got, err := Decode(b)
+	if err != nil || !reflect.DeepEqual(*got, vm) {
+		t.Fatalf("round trip: got %+v, %v", got, err)
+	}
+}
+
+func TestSalvage(t *testing.T) {
+	t.Parallel()
+	full, err := Encode(domain.VM{ID: "01J0000000000000000000000V", Name: "web", VMSpec: domain.VMSpec{CPUs: 4, MemoryMiB: 4096}, Labels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Truncated mid-record: fields before the cut survive.
+	truncated := full[:bytes.Index(full, []byte(`"labels"`))]
+	if vm := Salvage(truncated); vm == nil || vm.ID != "01J0000000000000000000000V" || vm.CPUs != 4 || vm.MemoryMiB != 4096 {
+		t.Fatalf("truncated record salvaged as %+v", vm)
+	}
+	// A field of the wrong type is skipped; the rest decodes.
+	if vm := Salvage([]byte(`{"name": "web", "cpus": "two", "memoryMiB": 1024}`)); vm == nil || vm.Name != "web" || vm.MemoryMiB != 1024 {
+		t.Fatalf("mistyped record salvaged as %+v", vm)
+	}
+	if vm := Salvage([]byte("\x00\x00garbage")); vm != nil {
+		t.Fatalf("expected nothing from garbage, got %+v", vm)
+	}
+}
@@ -0,0 +1,323 @@
+// Package storetest is a conformance suite that every domain.VMStore
+// implementation must pass.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alechenninger/orchard/internal/domain"
+)
+
+// Run exercises the VMStore contract against stores returned by newStore, which
+// must return an empty store for each call.
+func Run(t *testing.T, newStore func(t *testing.T) domain.VMStore) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s domain.VMStore)
+	}{
+		{"NextNameIsUniqueAndSkipsReserved", testNextName},
+		{"ReserveRejectsTakenAndInvalidNames", testReserve},
+		{"SaveAssignsIDAndRevision", testSave},
+		{"SaveRejectsStaleRevision", testConflict},
+		{"LoadByIDAndPrefix", testLoadByID},
+		{"LoadEmptyMatchesNothing", testLoadEmpty},
+		{"ListSortedByCreation", testList},
+		{"LabelsRoundTrip", testLabels},
+		{"DeleteReleasesName", testDelete},
+		{"RenameMovesRecordAndIndexes", testRename},
+		{"RecoverRefusesReadableRecords", testRecover},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func testNextName(t *testing.T, s domain.VMStore) {
+	ctx := context.Background()
+	if err := s.Reserve(ctx, "vm-002"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i := 0; i < 3; i++ {
+		name, err := s.NextName(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name)
+	}
+	want := []string{"vm-001", "vm-003", "vm-004"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("NextName sequence = %v, want %v", got, want)
+		}
+	}
+}
+
+func testReserve(t *testing.T, s domain.VMStore) {
+	ctx := context.Background()
+	if err := s.Reserve(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reserve(ctx, "web"); !errors.Is(err, domain.ErrVMExists) {
+		t.Fatalf("second reserve: expected ErrVMExists, got %v", err)
+	}
+	if err := s.Reserve(ctx, "Not_Valid"); err == nil {
+		t.Fatal("expected invalid name to be rejected")
+	}
+	if err := s.Save(ctx, &domain.VM{Name: "db"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reserve(ctx, "db"); !errors.Is(err, domain.ErrVMExists) {
+		t.Fatalf("reserve over record: expected ErrVMExists, got %v", err)
+	}
+}
+
+func testSave(t *testing.T, s domain.VMStore) {
+	ctx := context.Background()
+	vm := &domain.VM{Name: "web", VMSpec: domain.VMSpec{CPUs: 2}}
+	if err := s.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Revision != 1 || vm.ID == "" || vm.CreatedAt == 0 {
+		t.Fatalf("expected revision 1, an id and a creation time, got %+v", vm)
+	}
+	got, err := s.Load(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != vm.ID || got.CPUs != 2 || got.Revision != 1 {
+		t.Fatalf("loaded %+v, saved %+v", got, vm)
+	}
+	got.CPUs = 4
+	if err := s.Save(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Revision != 2 {
+		t.Fatalf("expected revision 2, got %d", got.Revision)
+	}
+}
+
+func testConflict(t *testing.T, s domain.VMStore) {
+	ctx := context.Background()
+	if err := s.Save(ctx, &domain.VM{Name: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := s.Load(ctx, "web")
+	b, _ := s.Load(ctx, "web")
+	a.CPUs = 2
+	if err := s.Save(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	b.CPUs = 4
+	if err := s.Save(ctx, b); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("stale save: expected ErrConflict, got %v", err)
+	}
+	if err := s.Save(ctx, &domain.VM{Name: "web"}); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("create over record: expected ErrConflict, got %v", err)
+	}
+	if err := s.Delete(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(ctx, a); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("save after delete: expected ErrConflict, got %v", err)
+	}
+}
+
+func testLoadByID(t *testing.T, s domain.VMStore) {
+	ctx := context.Background()
+	for _, vm := range []domain.VM{
+		{Name: "a", ID: "01HZZZAAAA0000000000000000"},
+		{Name: "b", ID: "01HZZZAAAB0000000000000000"},
+		{Name: "c", ID: "01HYYY0000000000000000000C"},
+	} {
+		if err := s.Save(ctx, &vm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vm, err := s.Load(ctx, "01HZZZAAAB0000000000000000"); err != nil || vm.Name != "b" {
+		t.Fatalf("load by full id: %v, %v", vm, err)
+	}
+	if vm, err := s.Load(ctx, "01hyyy"); err != nil || vm.Name != "c" {
+		t.Fatalf("load by lowercase prefix: %v, %v", vm, err)
+	}
+	var amb *domain.AmbiguousIDError
+	if _, err := s.Load(ctx, "01HZZZAAA"); !errors.As(err, &amb) || len(amb.Candidates) != 2 {
+		t.Fatalf("expected ambiguity between two VMs, got %v", err)
+	}
+	if _, err := s.Load(ctx, "01HX"); !errors.Is(err, domain.ErrVMNotFound) {
+		t.Fatalf("expected ErrVMNotFound, got %v", err)
+	}
+}
+
+func testLoadEmpty(t *testing.T, s domain.VMStore) {
+	ctx := context.Background()
+	// With a single VM, an empty prefix would otherwise be unambiguous.
+	if err := s.Save(ctx, &domain.VM{Name: "a", ID: "01HZZZAAAA0000000000000000"}); err != nil {
+		t.Fatal(err)
+	}
+	if vm, err := s.Load(ctx, ""); !errors.Is(err, domain.ErrVMNotFound) {
+		t.Fatalf("load of an empty name = %v, %v; want ErrVMNotFound", vm, err)
+	}
+}
+
+func testList(t *testing.T, s domain.VMStore) {
+	ctx := context.Background()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"c", "a", "b"} {
+		vm := &domain.VM{Name: name, CreatedAt: base.Add(time.Duration(i) * time.Minute).UnixNano()}
+		if err := s.Save(ctx, vm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Reserve(ctx, "pending"); err != nil {
+		t.Fatal(err)
+	}
+	vms, broken, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, vm := range vms {
+		got = append(got, vm.Name)
+	}
+	if len(got) != 3 || got[0] != "c" || got[1] != "a" || got[2] != "b" {
+		t.Fatalf("List = %v, want [c a b] without reservations", got)
+	}
+	if len(broken) != 0 {
+		t.Fatalf("healthy store reported broken VMs: %+v", broken)
+	}
+}
+
+func testLabels(t *testing.T, s domain.VMStore) {
+	ctx := context.Background()
+	vm := &domain.VM{Name: "web", Labels: map[string]string{"env": "prod", "tier": "web"}}
+	if err := s.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(ctx, &domain.VM{Name: "db", Labels: map[string]string{"env": "dev"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := s.Load(ctx, "web")
+	if got.Labels["env"] != "prod" || got.Labels["tier"] != "web" {
+		t.Fatalf("labels not persisted: %v", got.Labels)
+	}
+	delete(got.Labels, "tier")
+	got.Labels["env"] = "staging"
+	if err := s.Save(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	idx, ok := s.(domain.LabelIndex)
+	if !ok {
+		return
+	}
+	for _, tc := range []struct {
+		key, value string
+		want       int
+	}{
+		{"env", "staging", 1},
+		{"env", "prod", 0},
+		{"tier", "web", 0},
+		{"env", "dev", 1},
+	} {
+		vms, err := idx.ListByLabel(ctx, tc.key, tc.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vms) != tc.want {
+			t.Errorf("ListByLabel(%s=%s) returned %d VMs, want %d", tc.key, tc.value, len(vms), tc.want)
+		}
+	}
+}
+
+func testDelete(t *testing.T, s domain.VMStore) {
+	ctx := context.Background()
+	vm := &domain.VM{Name: "web"}
+	if err := s.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, vm.ID); err != nil {
+		t.Fatalf("delete by id: %v", err)
+	}
+	if _, err := s.Load(ctx, "web"); !errors.Is(err, domain.ErrVMNotFound) {
+		t.Fatalf("expected ErrVMNotFound after delete, got %v", err)
+	}
+	if _, err := s.Load(ctx, vm.ID); !errors.Is(err, domain.ErrVMNotFound) {
+		t.Fatalf("id index not cleaned up: %v", err)
+	}
+	if err := s.Reserve(ctx, "web"); err != nil {
+		t.Fatalf("name not released: %v", err)
+	}
+	if err := s.Delete(ctx, "web"); err != nil {
+		t.Fatalf("delete reservation: %v", err)
+	}
+	if err := s.Delete(ctx, "missing"); !errors.Is(err, domain.ErrVMNotFound) {
+		t.Fatalf("expected ErrVMNotFound deleting unknown VM, got %v", err)
+	}
+}
+
+func testRename(t *testing.T, s domain.VMStore) {
+	ctx := context.Background()
+	vm := &domain.VM{Name: "old", VMSpec: domain.VMSpec{DiskPath: "/elsewhere/disk.img"}, Labels: map[string]string{"env": "dev"}}
+	if err := s.Save(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(ctx, &domain.VM{Name: "taken"}); err != nil {
+		t.Fatal(err)
+	}
+	id := vm.ID
+	if err := s.Rename(ctx, vm, "taken"); !errors.Is(err, domain.ErrVMExists) {
+		t.Fatalf("rename onto existing VM: expected ErrVMExists, got %v", err)
+	}
+	stale := *vm
+	if err := s.Rename(ctx, vm, "new"); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Name != "new" || vm.ID != id || vm.Revision != 2 || vm.DiskPath != "/elsewhere/disk.img" {
+		t.Fatalf("renamed record = %+v", vm)
+	}
+	if _, err := s.Load(ctx, "old"); !errors.Is(err, domain.ErrVMNotFound) {
+		t.Fatalf("old name still resolves: %v", err)
+	}
+	if got, err := s.Load(ctx, id); err != nil || got.Name != "new" {
+		t.Fatalf("id does not resolve to the new name: %v, %v", got, err)
+	}
+	if idx, ok := s.(domain.LabelIndex); ok {
+		if vms, _ := idx.ListByLabel(ctx, "env", "dev"); len(vms) != 1 || vms[0].Name != "new" {
+			t.Fatalf("label index not updated: %+v", vms)
+		}
+	}
+	if err := s.Rename(ctx, &stale, "other"); err == nil {
+		t.Fatal("expected renaming a stale or missing record to fail")
+	}
+	if err := s.Reserve(ctx, "old"); err != nil {
+		t.Fatalf("old name not released: %v", err)
+	}
+}
+
+func testRecover(t *testing.T, s domain.VMStore) {
+	ctx := context.Background()
+	if err := s.Save(ctx, &domain.VM{Name: "web", VMSpec: domain.VMSpec{CPUs: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Recover(ctx, &domain.VM{Name: "web", VMSpec: domain.VMSpec{CPUs: 8}}); err == nil {
+		t.Fatal("expected Recover to refuse replacing a readable record")
+	}
+	if got, _ := s.Load(ctx, "web"); got.CPUs != 2 {
+		t.Fatalf("readable record was replaced: %+v", got)
+	}
+	vm := &domain.VM{Name: "lost", VMSpec: domain.VMSpec{CPUs: 1}, Revision: 7}
+	if err := s.Recover(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Revision != 1 || vm.ID == "" {
+		t.Fatalf("recovered record = %+v", vm)
+	}
+	if got, err := s.Load(ctx, vm.ID); err != nil || got.Name != "lost" {
+		t.Fatalf("recovered record not indexed: %v, %v", got, err)
+	}
+}